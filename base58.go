@@ -21,7 +21,12 @@ func Base58Encode(input []byte) []byte {
 		result = append(result, b58Alphabet[mod.Int64()])
 	}
 
-	if input[0] == 0x00 {
+	// Leading zero bytes don't change the number, so each is kept as a
+	// leading '1'.
+	for _, b := range input {
+		if b != 0x00 {
+			break
+		}
 		result = append(result, b58Alphabet[0])
 	}
 
@@ -41,7 +46,10 @@ func Base58Decode(input []byte) []byte {
 
 	decoded := result.Bytes()
 
-	if input[0] == b58Alphabet[0] {
+	for _, b := range input {
+		if b != b58Alphabet[0] {
+			break
+		}
 		decoded = append([]byte{0x00}, decoded...)
 	}
 
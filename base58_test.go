@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBase58(t *testing.T) {
+	tests := []struct {
+		name    string
+		decoded []byte
+		encoded string
+	}{
+		{"no leading zeros", []byte{0x01, 0x02}, "5T"},
+		{"one leading zero", []byte{0x00, 0x01}, "12"},
+		{"two leading zeros", []byte{0x00, 0x00, 0x01}, "112"},
+		{"all zeros", []byte{0x00, 0x00}, "11"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(Base58Encode(tt.decoded)); got != tt.encoded {
+				t.Errorf("Base58Encode(%x) = %s, want %s", tt.decoded, got, tt.encoded)
+			}
+			if got := Base58Decode([]byte(tt.encoded)); !bytes.Equal(got, tt.decoded) {
+				t.Errorf("Base58Decode(%s) = %x, want %x", tt.encoded, got, tt.decoded)
+			}
+		})
+	}
+
+	t.Run("address of a key hash with a leading zero", func(t *testing.T) {
+		pubKeyHash := append([]byte{0x00}, bytes.Repeat([]byte{0x01}, pubKeyHashLen-1)...)
+		address := string(AddressFromPubKeyHash(pubKeyHash))
+		got, err := DecodePubKeyHashAddress(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, pubKeyHash) {
+			t.Errorf("%s decodes to %x, want %x", address, got, pubKeyHash)
+		}
+	})
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
 	"log"
 	"time"
@@ -14,36 +15,48 @@ type Block struct {
 	Hash          []byte
 	Nonce         int
 	Height        int
+	// PubKey and Signature seal blocks of proof of authority chains.
+	PubKey    []byte
+	Signature []byte
 }
 
-func NewBlock(transactions []*Transaction, prevBlockHash []byte, height int) *Block {
+func NewGenesisBlock(coinbase *Transaction, engine ConsensusEngine) *Block {
 	block := &Block{
-		time.Now().Unix(), transactions, prevBlockHash, []byte{}, 0, height,
+		Timestamp:     time.Now().Unix(),
+		Transactions:  []*Transaction{coinbase},
+		PrevBlockHash: []byte{},
+		Hash:          []byte{},
+	}
+	err := engine.Seal(context.Background(), nil, block)
+	if err != nil {
+		log.Panic(err)
 	}
-	pow := NewProofOfWork(block)
-	nonce, hash := pow.Run()
-
-	block.Hash = hash[:]
-	block.Nonce = nonce
 
 	return block
 }
 
-func NewGenesisBlock(coinbase *Transaction) *Block {
-	return NewBlock([]*Transaction{coinbase}, []byte{}, 0)
-}
-
 func (b *Block) HashTransactions() []byte {
 	var transactions [][]byte
 
 	for _, tx := range b.Transactions {
-		transactions = append(transactions, tx.Serialize())
+		transactions = append(transactions, tx.HashData())
 	}
 	mTree := NewMerkleTree(transactions)
 
 	return mTree.RootNode.Data
 }
 
+// Size returns the size of the block counted against the network limit: its
+// header, the seal of a signed block, and the sizes of its transactions.
+func (b *Block) Size() int {
+	size := blockHeaderLen + len(b.PubKey) + len(b.Signature)
+	for _, tx := range b.Transactions {
+		size += tx.Size()
+	}
+
+	return size
+}
+
 func (b *Block) Serialize() []byte {
 	var result bytes.Buffer
 	encoder := gob.NewEncoder(&result)
@@ -57,13 +70,24 @@ func (b *Block) Serialize() []byte {
 }
 
 func DeserializeBlock(d []byte) *Block {
+	block, err := DecodeBlock(d)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return block
+}
+
+// DecodeBlock is DeserializeBlock for bytes that may not hold a block, such
+// as those an external miner submits.
+func DecodeBlock(d []byte) (*Block, error) {
 	var block Block
 
 	decoder := gob.NewDecoder(bytes.NewReader(d))
 	err := decoder.Decode(&block)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
-	return &block
+	return &block, nil
 }
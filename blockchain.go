@@ -2,12 +2,16 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
 	"os"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/boltdb/bolt"
 )
@@ -16,11 +20,28 @@ const (
 	dbFile              = "./database_%s.db"
 	blocksBucket        = "blocks"
 	genesisCoinbaseData = "The Times 03/Jan/2009 Chancellor on brink of second bailout for banks"
+	// dbOpenTimeout is how long opening the database waits for another
+	// process holding it, usually a running node, to let it go.
+	dbOpenTimeout = time.Second
 )
 
 type BlockChain struct {
-	tip []byte
-	db  *bolt.DB
+	// mu is held while blocks are validated, stored, connected to the UTXO
+	// set and pruned, so that the tip and the UTXO set move together and no
+	// block is connected twice or pruned while it is disconnected.
+	mu sync.Mutex
+	// tipMu guards tip, which is read without holding mu.
+	tipMu  sync.RWMutex
+	tip    []byte
+	db     *bolt.DB
+	engine ConsensusEngine
+
+	// held is the highest block stored without switching to it while
+	// the assume-valid block was awaited.
+	held *Block
+	// assumeValidChain holds the hashes of the assume-valid block and its
+	// ancestors by height, once it is stored.
+	assumeValidChain [][]byte
 }
 
 type BlockChainIterator struct {
@@ -36,22 +57,34 @@ func dbExist(dbFile string) bool {
 	return true
 }
 
-func CreateBlockChain(address string, nodeID string) *BlockChain {
+func CreateBlockChain(address string, nodeID string, consensus ConsensusConfig) *BlockChain {
 	dbFile := fmt.Sprintf(dbFile, nodeID)
 	if dbExist(dbFile) {
 		fmt.Println("Blockchain already exists.")
 		os.Exit(1)
 	}
 
-	var tip []byte
-
-	cbtx := NewCoinbaseTX(address, genesisCoinbaseData)
-	genesis := NewGenesisBlock(cbtx)
-
 	db, err := bolt.Open(dbFile, 0600, nil)
 	if err != nil {
 		log.Panic("Failed to open blockchain db: ", err)
 	}
+	bc := &BlockChain{db: db}
+	engine, err := NewConsensusEngine(consensus, nodeID, &localClient{bc}, db)
+	if err != nil {
+		log.Panic(err)
+	}
+	cbtx := NewCoinbaseTX(address, genesisCoinbaseData, 0, 0)
+	if consensus.Engine == ConsensusProofOfStake {
+		// Nobody has coins to stake yet, so the creator stakes the genesis
+		// reward and produces the first blocks.
+		stake, err := NewStakeTXOutput(subsidy, address)
+		if err != nil {
+			log.Panic(err)
+		}
+		cbtx.Vout[0] = *stake
+		cbtx.ID = cbtx.Hash()
+	}
+	genesis := NewGenesisBlock(cbtx, engine)
 
 	err = db.Update(func(tx *bolt.Tx) error {
 		b, err := tx.CreateBucket([]byte(blocksBucket))
@@ -68,16 +101,15 @@ func CreateBlockChain(address string, nodeID string) *BlockChain {
 		if err != nil {
 			return err
 		}
-		tip = genesis.Hash
 
-		return nil
+		return writeConsensusConfig(tx, consensus)
 	})
 	if err != nil {
 		log.Panic(err)
 	}
 
-	bc := BlockChain{tip, db}
-	return &bc
+	bc.tip, bc.engine = genesis.Hash, engine
+	return bc
 }
 
 func NewBlockChain(nodeID string) *BlockChain {
@@ -89,7 +121,11 @@ func NewBlockChain(nodeID string) *BlockChain {
 
 	var tip []byte
 
-	db, err := bolt.Open(dbFile, 0600, nil)
+	db, err := bolt.Open(dbFile, 0600, &bolt.Options{Timeout: dbOpenTimeout})
+	if err == bolt.ErrTimeout {
+		fmt.Println("The blockchain is in use by another process, such as a node whose RPC server can't be reached.")
+		os.Exit(1)
+	}
 	if err != nil {
 		log.Panic("Failed to open blockchain db: ", err)
 	}
@@ -104,42 +140,258 @@ func NewBlockChain(nodeID string) *BlockChain {
 		log.Panicln("Failed to init blockchain from db: ", err)
 	}
 
-	bc := BlockChain{tip, db}
-	return &bc
+	consensus, err := readConsensusConfig(db)
+	if err != nil {
+		log.Panic(err)
+	}
+	bc := &BlockChain{tip: tip, db: db}
+	bc.engine, err = NewConsensusEngine(consensus, nodeID, &localClient{bc}, db)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	UTXOSet := UTXOSet{bc}
+	if !chainStateCurrent(db) {
+		fmt.Println("The UTXO set is stored in an old format. Rebuilding it...")
+		UTXOSet.Reindex()
+	}
+	UTXOSet.SyncTip()
+
+	return bc
 }
 
-func (bc *BlockChain) AddBlock(block *Block) {
-	err := bc.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		blockInDb := b.Get(block.Hash)
+// Tip returns the hash of the block at the tip of the main chain.
+func (bc *BlockChain) Tip() []byte {
+	bc.tipMu.RLock()
+	defer bc.tipMu.RUnlock()
 
-		if blockInDb != nil {
-			return nil
-		}
+	return bc.tip
+}
 
-		blockData := block.Serialize()
-		err := b.Put(block.Hash, blockData)
-		if err != nil {
-			return err
+func (bc *BlockChain) setTip(hash []byte) {
+	bc.tipMu.Lock()
+	defer bc.tipMu.Unlock()
+
+	bc.tip = hash
+}
+
+// ProcessBlock validates a block received from a peer or an external miner
+// and stores it. If it is higher than the tip the chain switches to it, and
+// the transactions of each block joining the main chain are validated as it
+// is connected. The chain stays locked throughout, so blocks arriving together
+// are each validated against the chain the others leave.
+//
+// A block below the assume-valid block is held back until that block is
+// stored, or ActivateHeldBlocks is called, so that it is only spared the
+// script checks if it is an ancestor.
+func (bc *BlockChain) ProcessBlock(block *Block) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if _, err := bc.GetBlock(block.Hash); err == nil {
+		return nil
+	}
+	err := bc.ValidateBlock(block)
+	if err != nil {
+		return err
+	}
+
+	bc.storeBlock(block)
+	if block.Height <= bc.GetBestHeight() {
+		return nil
+	}
+	if bc.awaitingAssumeValid(block) {
+		if bc.held == nil || block.Height > bc.held.Height {
+			bc.held = block
 		}
+		return nil
+	}
+	bc.held = nil
 
-		lastHash := b.Get([]byte("l"))
-		lastBlockData := b.Get(lastHash)
-		lastBlock := DeserializeBlock(lastBlockData)
+	return bc.activateBestChain(block)
+}
+
+// ActivateHeldBlocks switches to the highest block ProcessBlock held back,
+// once no more blocks are expected before the assume-valid block.
+func (bc *BlockChain) ActivateHeldBlocks() error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	held := bc.held
+	bc.held = nil
+	if held == nil || held.Height <= bc.GetBestHeight() {
+		return nil
+	}
+
+	return bc.activateBestChain(held)
+}
+
+// BestKnownHeight returns the height of the highest block stored on the way
+// to the tip: the tip, or a block held back above it for the assume-valid
+// block. Peers are told it rather than the tip's, so that they don't send the
+// held blocks again.
+func (bc *BlockChain) BestKnownHeight() int {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	height := bc.GetBestHeight()
+	if bc.held != nil && bc.held.Height > height {
+		height = bc.held.Height
+	}
+
+	return height
+}
+
+// storeBlock stores block without connecting it. bc.mu must be held.
+func (bc *BlockChain) storeBlock(block *Block) {
+	err := bc.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		return b.Put(block.Hash, block.Serialize())
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// activateBestChain switches the tip to candidate. If a block on the way
+// fails validation, it is marked invalid and the chain settles on the highest
+// valid block instead; the error of that first block is returned. bc.mu must
+// be held.
+func (bc *BlockChain) activateBestChain(candidate *Block) error {
+	failed, err := bc.switchTip(candidate)
+	if failed == nil {
+		return nil
+	}
+	result := fmt.Errorf("block %x is invalid: %w", failed.Hash, err)
 
-		if block.Height > lastBlock.Height {
-			err = b.Put([]byte("l"), block.Hash)
+	for failed != nil {
+		var best *Block
+		err = bc.db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte(blocksBucket))
+			err := markBlockInvalid(tx, failed.Hash, findDescendants(b, failed.Hash))
 			if err != nil {
 				return err
 			}
-			bc.tip = block.Hash
+			best = findBestValidBlock(tx)
+			if best.Height <= DeserializeBlock(b.Get(b.Get([]byte("l")))).Height {
+				best = nil
+			}
+
+			return nil
+		})
+		if err != nil {
+			log.Panic(err)
 		}
+		if best == nil {
+			break
+		}
+
+		failed, err = bc.switchTip(best)
+		if failed != nil {
+			fmt.Printf("Block %x is invalid: %s\n", failed.Hash, err)
+		}
+	}
+
+	return result
+}
+
+// switchTip moves the tip to target a block at a time, disconnecting the
+// blocks leaving the main chain and validating and connecting the ones
+// joining it. Each block is committed on its own, so the UTXO set always
+// matches the tip. The mempool loses the transactions the connected blocks
+// confirm or conflict with, and gets back those of the disconnected blocks.
+// The scripts of blocks up to the assume-valid block aren't verified. If a
+// block fails validation the tip stays at its parent, and the block is
+// returned with the error. Pruned blocks can't be disconnected, so a target
+// forking off below them fails as a whole.
+func (bc *BlockChain) switchTip(target *Block) (*Block, error) {
+	var connected, disconnected []*Block
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		connected, disconnected = findReorg(b, DeserializeBlock(b.Get(bc.Tip())), target)
 
 		return nil
 	})
 	if err != nil {
 		log.Panic(err)
 	}
+	if n := len(disconnected); n > 0 && disconnected[n-1].Pruned() {
+		return target, fmt.Errorf("it forks off the main chain below pruned block %x", disconnected[n-1].Hash)
+	}
+
+	for _, block := range disconnected {
+		err := bc.db.Update(func(tx *bolt.Tx) error {
+			err := disconnectBlock(tx, block)
+			if err != nil {
+				return err
+			}
+			return tx.Bucket([]byte(blocksBucket)).Put([]byte("l"), block.PrevBlockHash)
+		})
+		if err != nil {
+			log.Panic(err)
+		}
+		bc.setTip(block.PrevBlockHash)
+		notifier.PublishBlockDisconnected(block)
+	}
+
+	var failed *Block
+	for _, block := range connected {
+		err = bc.validateBlockTransactions(block.Transactions, block.Height, block.Timestamp, !bc.assumedValid(block))
+		if err == nil {
+			err = bc.db.Update(func(tx *bolt.Tx) error {
+				err := connectBlock(tx, block)
+				if err != nil {
+					return err
+				}
+				return tx.Bucket([]byte(blocksBucket)).Put([]byte("l"), block.Hash)
+			})
+		}
+		if err != nil {
+			failed = block
+			break
+		}
+		bc.setTip(block.Hash)
+		mempool.RemoveBlockTransactions(block, RemovalReasonBlock)
+		notifier.PublishBlockConnected(block)
+	}
+	mempool.ResubmitBlockTransactions(bc, disconnected)
+
+	return failed, err
+}
+
+// findReorg walks back from the old and new tips to their common ancestor and
+// returns the blocks that become part of the main chain, oldest first, and
+// the ones that leave it, newest first. If an ancestor of newTip hasn't been
+// received yet the walk stops there.
+func findReorg(b *bolt.Bucket, oldTip, newTip *Block) ([]*Block, []*Block) {
+	var connected, disconnected []*Block
+
+	parent := func(block *Block) *Block {
+		data := b.Get(block.PrevBlockHash)
+		if len(block.PrevBlockHash) == 0 || data == nil {
+			return nil
+		}
+		return DeserializeBlock(data)
+	}
+
+	oldBlock, newBlock := oldTip, newTip
+	for newBlock != nil && newBlock.Height > oldBlock.Height {
+		connected = append([]*Block{newBlock}, connected...)
+		newBlock = parent(newBlock)
+	}
+	for oldBlock != nil && newBlock != nil && oldBlock.Height > newBlock.Height {
+		disconnected = append(disconnected, oldBlock)
+		oldBlock = parent(oldBlock)
+	}
+
+	for newBlock != nil && oldBlock != nil && !bytes.Equal(oldBlock.Hash, newBlock.Hash) {
+		disconnected = append(disconnected, oldBlock)
+		connected = append([]*Block{newBlock}, connected...)
+		oldBlock, newBlock = parent(oldBlock), parent(newBlock)
+	}
+
+	return connected, disconnected
 }
 
 func (bc *BlockChain) GetBestHeight() int {
@@ -183,6 +435,36 @@ func (bc *BlockChain) GetBlock(blockHash []byte) (Block, error) {
 	return block, nil
 }
 
+// blockHeight returns the height of the block with blockHash, if it is known.
+func (bc *BlockChain) blockHeight(blockHash []byte) (int, bool) {
+	block, err := bc.GetBlock(blockHash)
+	if err != nil {
+		return 0, false
+	}
+
+	return block.Height, true
+}
+
+// GetBlockByHeight returns the main chain block at height. There is no height
+// index, so it walks back from the tip and takes longer the deeper the block.
+func (bc *BlockChain) GetBlockByHeight(height int) (Block, error) {
+	bci := bc.Iterator()
+
+	for {
+		block := bci.Next()
+
+		if block.Height == height {
+			return *block, nil
+		}
+
+		if len(block.PrevBlockHash) == 0 || block.Height < height {
+			break
+		}
+	}
+
+	return Block{}, errors.New("Block is not found.")
+}
+
 func (bc *BlockChain) GetBlockHashes() [][]byte {
 	var hashes [][]byte
 	bci := bc.Iterator()
@@ -199,99 +481,107 @@ func (bc *BlockChain) GetBlockHashes() [][]byte {
 	return hashes
 }
 
-func (bc *BlockChain) MineBlock(transactions []*Transaction) *Block {
-	var lastHash []byte
-	var lastHeight int
-
-	for _, tx := range transactions {
-		if !bc.VerifyTransaction(tx) {
-			log.Panic("ERROR: Invalid transaction")
-		}
+// MineBlock mines a block holding transactions on top of the tip, stores it
+// and connects it. It returns an error when the transactions are invalid on
+// the tip, which may have moved since they were chosen, or make the block too
+// large. Mining is abandoned when ctx is done or another block is connected
+// first, since the new block would no longer extend the tip.
+func (bc *BlockChain) MineBlock(ctx context.Context, transactions []*Transaction) (*Block, error) {
+	bc.mu.Lock()
+	lastBlock, err := bc.GetBlock(bc.Tip())
+	if err != nil {
+		log.Panic(err)
 	}
-
-	err := bc.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		lastHash = b.Get([]byte("l"))
-
-		blockData := b.Get(lastHash)
-		block := DeserializeBlock(blockData)
-
-		lastHeight = block.Height
-
-		return nil
-	})
+	timestamp := bc.NextBlockTime(&lastBlock)
+	err = bc.ValidateBlockTransactions(transactions, lastBlock.Height+1, timestamp)
+	bc.mu.Unlock()
 	if err != nil {
-		log.Fatalln("Failed to read lastHash in database: ", err)
+		return nil, fmt.Errorf("invalid block on %x: %w", lastBlock.Hash, err)
 	}
 
-	newBlock := NewBlock(transactions, lastHash, lastHeight+1)
-
-	err = bc.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		err = b.Put(newBlock.Hash, newBlock.Serialize())
-		if err != nil {
-			return err
-		}
-		err = b.Put([]byte("l"), newBlock.Hash)
-		if err != nil {
-			return err
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events := notifier.Subscribe()
+	defer notifier.Unsubscribe(events)
+	go func() {
+		for {
+			select {
+			case e := <-events:
+				if e.Type == EventBlockConnected {
+					cancel()
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
 		}
+	}()
+
+	newBlock := &Block{
+		Timestamp:     timestamp,
+		Transactions:  transactions,
+		PrevBlockHash: lastBlock.Hash,
+		Hash:          []byte{},
+		Height:        lastBlock.Height + 1,
+	}
+	err = bc.engine.Seal(ctx, &lastBlock, newBlock)
+	if err != nil {
+		return nil, fmt.Errorf("sealing a block on %x stopped: %w", lastBlock.Hash, err)
+	}
+	if size := newBlock.Size(); size > netParams.MaxBlockSize {
+		return nil, fmt.Errorf("mined block %x of %d bytes exceeds %d", newBlock.Hash, size, netParams.MaxBlockSize)
+	}
 
-		bc.tip = newBlock.Hash
-
-		return nil
-	})
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if !bytes.Equal(bc.Tip(), lastBlock.Hash) {
+		return nil, fmt.Errorf("mined block %x no longer extends the tip", newBlock.Hash)
+	}
+	bc.storeBlock(newBlock)
+	err = bc.activateBestChain(newBlock)
 	if err != nil {
-		log.Fatalln("Failed to add NewBlock in database: ", err)
+		return nil, err
 	}
 
-	return newBlock
+	return newBlock, nil
 }
 
-func (bc *BlockChain) FindUTXO() map[string]TXOutputs {
-	UTXO := make(map[string]TXOutputs)
-	spentTXOs := make(map[string][]int)
-	bci := bc.Iterator()
-
-	for {
-		block := bci.Next()
-
-		for _, tx := range block.Transactions {
-			txID := hex.EncodeToString(tx.ID)
-
-		Outputs:
-			for outIdx, out := range tx.Vout {
-				if spentTXOs[txID] != nil {
-					for _, spentOutIdx := range spentTXOs[txID] {
-						if spentOutIdx == outIdx {
-							continue Outputs
-						}
-					}
-				}
-
-				outs := UTXO[txID]
-				outs.Outputs = append(outs.Outputs, out)
-				UTXO[txID] = outs
-			}
-
-			if !tx.IsCoinbase() {
-				for _, in := range tx.Vin {
-					inTxID := hex.EncodeToString(in.Txid)
-					spentTXOs[inTxID] = append(spentTXOs[inTxID], in.Vout)
-				}
-			}
-		}
+// MedianTimePast returns the median timestamp of block and the blocks before
+// it, up to MedianTimeBlocks of them. Blocks built on top of block must be
+// later than that.
+func (bc *BlockChain) MedianTimePast(block *Block) int64 {
+	var timestamps []int64
 
+	for len(timestamps) < netParams.MedianTimeBlocks {
+		timestamps = append(timestamps, block.Timestamp)
 		if len(block.PrevBlockHash) == 0 {
 			break
 		}
+		parent, err := bc.GetBlock(block.PrevBlockHash)
+		if err != nil {
+			break
+		}
+		block = &parent
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+	return timestamps[len(timestamps)/2]
+}
+
+// NextBlockTime returns the timestamp for a block mined on top of parent: the
+// network-adjusted time, unless that isn't later than the median time past.
+func (bc *BlockChain) NextBlockTime(parent *Block) int64 {
+	now := timeSource.AdjustedTime()
+	if minTime := bc.MedianTimePast(parent) + 1; now < minTime {
+		return minTime
 	}
 
-	return UTXO
+	return now
 }
 
 func (bc *BlockChain) Iterator() *BlockChainIterator {
-	bci := &BlockChainIterator{bc.tip, bc.db}
+	bci := &BlockChainIterator{bc.Tip(), bc.db}
 	return bci
 }
 
@@ -314,7 +604,47 @@ func (i *BlockChainIterator) Next() *Block {
 	return block
 }
 
+// FindTransaction returns the main chain transaction with ID. Of one in a
+// pruned block, only the outputs still unspent are left.
 func (bc *BlockChain) FindTransaction(ID []byte) (Transaction, error) {
+	block, err := bc.FindTransactionBlock(ID)
+	if err != nil {
+		return Transaction{}, err
+	}
+	if block.Pruned() {
+		UTXOSet := UTXOSet{bc}
+		return *unspentTransaction(ID, UTXOSet.FindOutputs(ID)), nil
+	}
+
+	for _, tx := range block.Transactions {
+		if bytes.Equal(tx.ID, ID) {
+			return *tx, nil
+		}
+	}
+
+	return Transaction{}, errors.New("Transaction is not found")
+}
+
+// FindTransactionBlock returns the main chain block confirming the
+// transaction with ID, which may be pruned. It looks the transaction up in the
+// index first, then scans the chain.
+func (bc *BlockChain) FindTransactionBlock(ID []byte) (*Block, error) {
+	var blockHash []byte
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		if index := tx.Bucket([]byte(txIndexBucket)); index != nil {
+			blockHash = append([]byte{}, index.Get(ID)...)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	if len(blockHash) > 0 {
+		if block, err := bc.GetBlock(blockHash); err == nil {
+			return &block, nil
+		}
+	}
+
 	bci := bc.Iterator()
 
 	for {
@@ -322,7 +652,7 @@ func (bc *BlockChain) FindTransaction(ID []byte) (Transaction, error) {
 
 		for _, tx := range block.Transactions {
 			if bytes.Equal(tx.ID, ID) {
-				return *tx, nil
+				return block, nil
 			}
 		}
 
@@ -331,28 +661,46 @@ func (bc *BlockChain) FindTransaction(ID []byte) (Transaction, error) {
 		}
 	}
 
-	return Transaction{}, errors.New("Transaction is not found")
+	return nil, errors.New("Transaction is not found")
 }
 
-func (bc *BlockChain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey) {
-	prevTXs := make(map[string]Transaction)
+// FindDataCarrier returns the oldest main chain transaction carrying data in
+// a null data output, along with its block, which must not be pruned.
+func (bc *BlockChain) FindDataCarrier(data []byte) (*Block, *Transaction, error) {
+	var txID, blockHash []byte
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		if carriers := tx.Bucket([]byte(nullDataBucket)); carriers != nil && len(data) > 0 {
+			txID = append([]byte{}, carriers.Get(data)...)
+		}
+		if index := tx.Bucket([]byte(txIndexBucket)); index != nil && len(txID) > 0 {
+			blockHash = append([]byte{}, index.Get(txID)...)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	if len(blockHash) == 0 {
+		return nil, nil, errors.New("Data is not anchored")
+	}
 
-	for _, vin := range tx.Vin {
-		prevTX, err := bc.FindTransaction(vin.Txid)
-		if err != nil {
-			log.Panic(err)
+	block, err := bc.GetBlock(blockHash)
+	if err != nil {
+		return nil, nil, err
+	}
+	if block.Pruned() {
+		return nil, nil, fmt.Errorf("transaction %x carrying the data is in block %x, which is pruned", txID, blockHash)
+	}
+	for _, tx := range block.Transactions {
+		if bytes.Equal(tx.ID, txID) {
+			return &block, tx, nil
 		}
-		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
 	}
 
-	tx.Sign(privKey, prevTXs)
+	return nil, nil, fmt.Errorf("transaction %x is not in block %x", txID, blockHash)
 }
 
-func (bc *BlockChain) VerifyTransaction(tx *Transaction) bool {
-	if tx.IsCoinbase() {
-		return true
-	}
-
+func (bc *BlockChain) FindPrevTransactions(tx *Transaction) map[string]Transaction {
 	prevTXs := make(map[string]Transaction)
 
 	for _, vin := range tx.Vin {
@@ -363,5 +711,9 @@ func (bc *BlockChain) VerifyTransaction(tx *Transaction) bool {
 		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
 	}
 
-	return tx.Verify(prevTXs)
+	return prevTXs
+}
+
+func (bc *BlockChain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey) {
+	tx.Sign(privKey, bc.FindPrevTransactions(tx))
 }
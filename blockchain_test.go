@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+const testNodeID = "test"
+
+// newTestChain creates a proof of authority chain in a temporary directory,
+// which becomes the working directory for the test. Its only signer, whose
+// wallet is returned, also received the genesis reward.
+func newTestChain(t *testing.T) (*BlockChain, *Wallet) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	wallets, _ := NewWallets(testNodeID)
+	address := wallets.CreateWallet()
+	wallets.SaveToFile(testNodeID)
+
+	mempool = NewMempool()
+	bc := CreateBlockChain(address, testNodeID, ConsensusConfig{Engine: ConsensusProofOfAuthority, Signers: []string{address}})
+	t.Cleanup(func() { bc.db.Close() })
+	UTXOSet := UTXOSet{bc}
+	UTXOSet.Reindex()
+
+	wallet := wallets.GetWallet(address)
+	return bc, &wallet
+}
+
+// newTestBlock seals a block on parent, a second after it, holding a coinbase
+// paying the subsidy to signer and txs.
+func newTestBlock(parent *Block, signer *Wallet, txs ...*Transaction) *Block {
+	coinbase := NewCoinbaseTX(string(signer.GetAddress()), "", parent.Height+1, 0)
+	block := &Block{
+		Timestamp:     parent.Timestamp + 1,
+		Transactions:  append([]*Transaction{coinbase}, txs...),
+		PrevBlockHash: parent.Hash,
+		Height:        parent.Height + 1,
+	}
+	block.Hash = headerHash(block)
+	block.PubKey = signer.PublicKey
+	block.Signature = SignHash(signer.PrivateKey, block.Hash)
+
+	return block
+}
+
+// newTestTx spends the outputs vouts of prev, which belong to wallet, paying
+// amount back to wallet.
+func newTestTx(wallet *Wallet, prev *Transaction, vouts []int, amount int) *Transaction {
+	var inputs []TXInput
+	for _, vout := range vouts {
+		inputs = append(inputs, TXInput{prev.ID, vout, nil, SequenceFinal})
+	}
+	tx := Transaction{nil, inputs, []TXOutput{*NewTXOutput(amount, string(wallet.GetAddress()))}, 0}
+	tx.ID = tx.Hash()
+	tx.Sign(wallet.PrivateKey, map[string]Transaction{hex.EncodeToString(prev.ID): *prev})
+
+	return &tx
+}
+
+// tipBlock returns the block at the tip of bc.
+func tipBlock(t *testing.T, bc *BlockChain) *Block {
+	t.Helper()
+
+	block, err := bc.GetBlock(bc.Tip())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &block
+}
+
+// genesisCoinbase returns the coinbase of the genesis block of bc.
+func genesisCoinbase(t *testing.T, bc *BlockChain) *Transaction {
+	t.Helper()
+
+	block, err := bc.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return block.Transactions[0]
+}
+
+// addTestBlock hands block to bc as if a peer sent it, failing t if it is
+// rejected.
+func addTestBlock(t *testing.T, bc *BlockChain, block *Block) {
+	t.Helper()
+
+	err := bc.ProcessBlock(block)
+	if err != nil {
+		t.Fatalf("block %x is invalid: %s", block.Hash, err)
+	}
+}
+
+// pruneTestBlock deletes the body of block and records the chain as pruned up
+// to it, as Prune does.
+func pruneTestBlock(t *testing.T, bc *BlockChain, block *Block) {
+	t.Helper()
+
+	err := bc.db.Update(func(tx *bolt.Tx) error {
+		header := *block
+		header.Transactions = nil
+		err := tx.Bucket([]byte(blocksBucket)).Put(block.Hash, header.Serialize())
+		if err != nil {
+			return err
+		}
+		status, err := tx.CreateBucketIfNotExists([]byte(blockStatusBucket))
+		if err != nil {
+			return err
+		}
+		return status.Put(prunedHeightKey, IntToHex(int64(block.Height)))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestConcurrentMining races two miners, a peer delivering a side chain and a
+// reader on one chain. Run it with -race; bolt 1.3.1 trips the pointer checks
+// that come with it, so add -gcflags=all=-d=checkptr=0.
+func TestConcurrentMining(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	address := string(wallet.GetAddress())
+
+	side := []*Block{newTestBlock(tipBlock(t, bc), wallet)}
+	side = append(side, newTestBlock(side[0], wallet))
+
+	const blocks = 3
+	var wg sync.WaitGroup
+	for miner := 0; miner < 2; miner++ {
+		wg.Add(1)
+		go func(miner int) {
+			defer wg.Done()
+			for i := 0; i < blocks; i++ {
+				coinbase := NewCoinbaseTX(address, fmt.Sprintf("miner %d block %d", miner, i), bc.GetBestHeight()+1, 0)
+				bc.MineBlock(context.Background(), []*Transaction{coinbase})
+			}
+		}(miner)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for _, block := range side {
+			bc.ProcessBlock(block)
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			if _, err := bc.GetBlock(bc.Tip()); err != nil {
+				t.Error(err)
+			}
+			utxoBalance(bc, wallet)
+		}
+	}()
+	wg.Wait()
+	<-done
+
+	want := (bc.GetBestHeight() + 1) * subsidy
+	if balance := utxoBalance(bc, wallet); balance != want {
+		t.Errorf("balance is %d at height %d, want %d", balance, bc.GetBestHeight(), want)
+	}
+	UTXOSet := UTXOSet{bc}
+	UTXOSet.Reindex()
+	if balance := utxoBalance(bc, wallet); balance != want {
+		t.Errorf("balance is %d after reindexing, want %d", balance, want)
+	}
+}
+
+// TestFindDataCarrier anchors data twice, then checks that the index follows
+// the oldest carrier through reorganizations and reports it when pruned.
+func TestFindDataCarrier(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	genesis := tipBlock(t, bc)
+	data := []byte("anchored")
+
+	carrierBlock := func(parent *Block) *Block {
+		block := newTestBlock(parent, wallet)
+		block.Transactions[0].AddData(data)
+		block.Hash = headerHash(block)
+		block.Signature = SignHash(wallet.PrivateKey, block.Hash)
+		return block
+	}
+	findCarrier := func(want *Block, errMsg string) {
+		t.Helper()
+		block, carrier, err := bc.FindDataCarrier(data)
+		checkError(t, err, errMsg)
+		if want != nil && (block == nil || string(block.Hash) != string(want.Hash) ||
+			string(carrier.ID) != string(want.Transactions[0].ID)) {
+			t.Errorf("found a carrier other than the coinbase of block %x", want.Hash)
+		}
+	}
+
+	first := carrierBlock(genesis)
+	addTestBlock(t, bc, first)
+	second := carrierBlock(first)
+	addTestBlock(t, bc, second)
+	findCarrier(first, "")
+
+	fork := newTestBlock(genesis, wallet)
+	addTestBlock(t, bc, fork)
+	fork = newTestBlock(fork, wallet)
+	addTestBlock(t, bc, fork)
+	addTestBlock(t, bc, newTestBlock(fork, wallet))
+	findCarrier(nil, "not anchored")
+
+	third := newTestBlock(second, wallet)
+	addTestBlock(t, bc, third)
+	addTestBlock(t, bc, newTestBlock(third, wallet))
+	findCarrier(first, "")
+
+	pruneTestBlock(t, bc, first)
+	findCarrier(nil, "pruned")
+}
+
+// TestReorgBelowPrunedHeight checks that a pruned chain refuses blocks forking
+// off below the pruned height, and side chains that would need its pruned
+// blocks disconnected, rather than failing to disconnect them.
+func TestReorgBelowPrunedHeight(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	genesis := tipBlock(t, bc)
+
+	pruned := newTestBlock(genesis, wallet)
+	addTestBlock(t, bc, pruned)
+	main := newTestBlock(pruned, wallet)
+	addTestBlock(t, bc, main)
+	addTestBlock(t, bc, newTestBlock(main, wallet))
+	side := newTestBlock(genesis, wallet)
+	side.Timestamp += 10
+	side.Hash = headerHash(side)
+	side.Signature = SignHash(wallet.PrivateKey, side.Hash)
+	addTestBlock(t, bc, side)
+	pruneTestBlock(t, bc, pruned)
+
+	err := bc.ProcessBlock(newTestBlock(side, wallet))
+	checkError(t, err, "pruned up to 1")
+	err = bc.ProcessBlock(newTestBlock(main, wallet))
+	checkError(t, err, "")
+
+	// Side chain blocks stored before the chain was pruned.
+	tip := bc.Tip()
+	longer := side
+	bc.mu.Lock()
+	for i := 0; i < 4; i++ {
+		longer = newTestBlock(longer, wallet)
+		bc.storeBlock(longer)
+	}
+	err = bc.activateBestChain(longer)
+	bc.mu.Unlock()
+	checkError(t, err, "below pruned block")
+	if string(bc.Tip()) != string(tip) {
+		t.Errorf("tip moved to %x", bc.Tip())
+	}
+}
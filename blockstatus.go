@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/boltdb/bolt"
+)
+
+// blockStatusBucket maps the hashes of blocks marked invalid to their
+// BlockStatus; blocks not in it are valid. It also holds how far the chain is
+// pruned, under prunedHeightKey.
+const blockStatusBucket = "blockstatus"
+
+type BlockStatus byte
+
+const (
+	// BlockInvalid marks a block an operator invalidated, or whose
+	// transactions failed validation when it was connected.
+	BlockInvalid BlockStatus = 1 << iota
+	// BlockInvalidChild marks a descendant of an invalid block.
+	BlockInvalidChild
+)
+
+func (s BlockStatus) String() string {
+	switch {
+	case s&BlockInvalid != 0:
+		return "invalid"
+	case s&BlockInvalidChild != 0:
+		return "invalid parent"
+	default:
+		return "valid"
+	}
+}
+
+// GetBlockStatus returns the status of the block with blockHash.
+func (bc *BlockChain) GetBlockStatus(blockHash []byte) BlockStatus {
+	var status BlockStatus
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		status = blockStatus(tx, blockHash)
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return status
+}
+
+func blockStatus(tx *bolt.Tx, blockHash []byte) BlockStatus {
+	b := tx.Bucket([]byte(blockStatusBucket))
+	if b == nil {
+		return 0
+	}
+	status := b.Get(blockHash)
+	if len(status) == 0 {
+		return 0
+	}
+
+	return BlockStatus(status[0])
+}
+
+func setBlockStatus(tx *bolt.Tx, blockHash []byte, status BlockStatus) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(blockStatusBucket))
+	if err != nil {
+		return err
+	}
+	if status == 0 {
+		return b.Delete(blockHash)
+	}
+
+	return b.Put(blockHash, []byte{byte(status)})
+}
+
+// InvalidateBlock marks the block with blockHash invalid and its descendants
+// as its children. If the tip is among them, the chain switches to the
+// highest block left valid, disconnecting the invalid blocks from the UTXO
+// set.
+func (bc *BlockChain) InvalidateBlock(blockHash []byte) error {
+	return bc.updateBlockStatus(blockHash, func(tx *bolt.Tx, block *Block, descendants [][]byte) error {
+		if len(block.PrevBlockHash) == 0 {
+			return errors.New("the genesis block can't be invalidated")
+		}
+		if block.Pruned() {
+			return fmt.Errorf("block %x is pruned", block.Hash)
+		}
+
+		return markBlockInvalid(tx, block.Hash, descendants)
+	})
+}
+
+// markBlockInvalid marks the block with blockHash invalid and its
+// descendants as its children.
+func markBlockInvalid(tx *bolt.Tx, blockHash []byte, descendants [][]byte) error {
+	err := setBlockStatus(tx, blockHash, blockStatus(tx, blockHash)|BlockInvalid)
+	if err != nil {
+		return err
+	}
+	for _, hash := range descendants {
+		err := setBlockStatus(tx, hash, blockStatus(tx, hash)|BlockInvalidChild)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReconsiderBlock clears the invalid marks of the block with blockHash, its
+// ancestors and its descendants. If that leaves a valid block higher than the
+// tip, the chain switches to it.
+func (bc *BlockChain) ReconsiderBlock(blockHash []byte) error {
+	return bc.updateBlockStatus(blockHash, func(tx *bolt.Tx, block *Block, descendants [][]byte) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		for hash := block.Hash; len(hash) > 0; {
+			err := setBlockStatus(tx, hash, 0)
+			if err != nil {
+				return err
+			}
+			hash = DeserializeBlock(b.Get(hash)).PrevBlockHash
+		}
+		for _, hash := range descendants {
+			err := setBlockStatus(tx, hash, 0)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// updateBlockStatus lets update change the status of the block with
+// blockHash and its descendants, then moves the tip to the highest valid
+// block if it is higher than the tip or the tip became invalid. The blocks
+// joining the main chain are validated as they are connected; one that fails
+// is marked invalid and the next highest valid block is tried.
+func (bc *BlockChain) updateBlockStatus(blockHash []byte, update func(tx *bolt.Tx, block *Block, descendants [][]byte) error) error {
+	var best *Block
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	err := bc.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		blockData := b.Get(blockHash)
+		if blockData == nil {
+			return fmt.Errorf("block %x is not found", blockHash)
+		}
+
+		err := update(tx, DeserializeBlock(blockData), findDescendants(b, blockHash))
+		if err != nil {
+			return err
+		}
+
+		lastBlock := DeserializeBlock(b.Get(b.Get([]byte("l"))))
+		best = findBestValidBlock(tx)
+		if blockStatus(tx, lastBlock.Hash) == 0 && best.Height <= lastBlock.Height {
+			best = nil
+		}
+
+		return nil
+	})
+	if err != nil || best == nil {
+		return err
+	}
+
+	return bc.activateBestChain(best)
+}
+
+// findDescendants returns the hashes of the stored blocks descending from
+// the block with blockHash.
+func findDescendants(b *bolt.Bucket, blockHash []byte) [][]byte {
+	children := make(map[string][][]byte)
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if bytes.Equal(k, []byte("l")) {
+			continue
+		}
+		block := DeserializeBlock(v)
+		parent := string(block.PrevBlockHash)
+		children[parent] = append(children[parent], block.Hash)
+	}
+
+	var descendants [][]byte
+	queue := children[string(blockHash)]
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		descendants = append(descendants, hash)
+		queue = append(queue, children[string(hash)]...)
+	}
+
+	return descendants
+}
+
+// findBestValidBlock returns the highest stored block that isn't marked
+// invalid. Of blocks at the same height it returns the first by hash.
+func findBestValidBlock(tx *bolt.Tx) *Block {
+	var best *Block
+
+	c := tx.Bucket([]byte(blocksBucket)).Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if bytes.Equal(k, []byte("l")) || blockStatus(tx, k) != 0 {
+			continue
+		}
+		block := DeserializeBlock(v)
+		if best == nil || block.Height > best.Height {
+			best = block
+		}
+	}
+
+	return best
+}
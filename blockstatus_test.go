@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+// TestReconsiderBlockRevalidates reconsiders a block that failed validation.
+// The chain switches back towards it, validating each block on the way.
+func TestReconsiderBlockRevalidates(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	genesis := genesisCoinbase(t, bc)
+
+	spend := newTestBlock(tipBlock(t, bc), wallet, newTestTx(wallet, genesis, []int{0}, subsidy))
+	addTestBlock(t, bc, spend)
+	respend := newTestBlock(spend, wallet, newTestTx(wallet, genesis, []int{0}, subsidy-1))
+	checkError(t, bc.ProcessBlock(respend), "spent")
+
+	err := bc.InvalidateBlock(spend.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if height := bc.GetBestHeight(); height != 0 {
+		t.Fatalf("tip is at height %d after invalidating block 1", height)
+	}
+
+	checkError(t, bc.ReconsiderBlock(respend.Hash), "spent")
+	if height := bc.GetBestHeight(); height != 1 {
+		t.Errorf("tip is at height %d, want 1", height)
+	}
+	if status := bc.GetBlockStatus(respend.Hash); status != BlockInvalid {
+		t.Errorf("block is %s, want invalid", status)
+	}
+	if balance := utxoBalance(bc, wallet); balance != 2*subsidy {
+		t.Errorf("balance is %d, want %d", balance, 2*subsidy)
+	}
+}
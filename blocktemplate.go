@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/hex"
+	"log"
+	"sort"
+)
+
+const (
+	// blockReservedSize and blockReservedSigOps are left in a template for
+	// the block header, its seal and the coinbase's signature checks.
+	blockReservedSize   = 1000
+	blockReservedSigOps = 100
+	// externalCoinbaseSize is left in the templates of external miners for
+	// the coinbase they add.
+	externalCoinbaseSize = 1000
+)
+
+// templateEntry is a mempool transaction considered for a block template.
+type templateEntry struct {
+	tx      Transaction
+	fee     int
+	size    int
+	sigOps  int
+	parents []string
+}
+
+// NewBlockTemplate chooses the mempool transactions for the next block,
+// ordered so that parents come before the children spending their outputs,
+// and returns them with the fees they pay. Transactions are taken as packages
+// with their unconfirmed ancestors, highest ancestor fee rate first, so that a
+// child paying a high fee gets its parent mined, until maxSize, which counts a
+// coinbase of coinbaseSize bytes, or the signature check limit of the network
+// is reached.
+func NewBlockTemplate(bc *BlockChain, mp *Mempool, maxSize, coinbaseSize int) ([]*Transaction, int) {
+	tip, err := bc.GetBlock(bc.Tip())
+	if err != nil {
+		log.Panic(err)
+	}
+	height := tip.Height + 1
+	blockTime := bc.NextBlockTime(&tip)
+	pending := mp.Pending()
+
+	entries := make(map[string]*templateEntry)
+	for id, tx := range pending {
+		fee, sigOps, err := bc.validateTransaction(&tx, height, blockTime, pending, true)
+		if err != nil {
+			continue
+		}
+
+		entry := &templateEntry{tx: tx, fee: fee, size: tx.Size(), sigOps: sigOps}
+		seen := make(map[string]bool)
+		for _, vin := range tx.Vin {
+			parent := hex.EncodeToString(vin.Txid)
+			if _, ok := pending[parent]; ok && !seen[parent] {
+				entry.parents = append(entry.parents, parent)
+				seen[parent] = true
+			}
+		}
+		entries[id] = entry
+	}
+
+	// A transaction can't be mined before its parents, so drop the
+	// descendants of invalid ones.
+	for changed := true; changed; {
+		changed = false
+		for id, entry := range entries {
+			for _, parent := range entry.parents {
+				if _, ok := entries[parent]; !ok {
+					delete(entries, id)
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	var selected []*Transaction
+	included := make(map[string]bool)
+	fees := 0
+	size := blockReservedSize + coinbaseSize
+	sigOps := blockReservedSigOps
+
+	for {
+		var best []string
+		bestFee, bestSize, bestSigOps := 0, 0, 0
+
+		ids := make([]string, 0, len(entries))
+		for id := range entries {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		for _, id := range ids {
+			if included[id] {
+				continue
+			}
+			pkg := packageOf(id, entries, included)
+			pkgFee, pkgSize, pkgSigOps := 0, 0, 0
+			for _, pid := range pkg {
+				pkgFee += entries[pid].fee
+				pkgSize += entries[pid].size
+				pkgSigOps += entries[pid].sigOps
+			}
+			if size+pkgSize > maxSize || sigOps+pkgSigOps > netParams.MaxBlockSigOps {
+				continue
+			}
+			if best == nil || pkgFee*bestSize > bestFee*pkgSize {
+				best, bestFee, bestSize, bestSigOps = pkg, pkgFee, pkgSize, pkgSigOps
+			}
+		}
+
+		if best == nil {
+			break
+		}
+
+		for _, id := range best {
+			tx := entries[id].tx
+			selected = append(selected, &tx)
+			included[id] = true
+		}
+		fees += bestFee
+		size += bestSize
+		sigOps += bestSigOps
+	}
+
+	return selected, fees
+}
+
+// packageOf returns id and its ancestors not yet included, parents first.
+func packageOf(id string, entries map[string]*templateEntry, included map[string]bool) []string {
+	var pkg []string
+	visited := make(map[string]bool)
+
+	var visit func(id string)
+	visit = func(id string) {
+		if visited[id] || included[id] {
+			return
+		}
+		visited[id] = true
+		for _, parent := range entries[id].parents {
+			visit(parent)
+		}
+		pkg = append(pkg, id)
+	}
+	visit(id)
+
+	return pkg
+}
+
+// BlockTemplate is the work handed to an external miner: everything about the
+// next block but its coinbase and proof of work. The coinbase goes first,
+// followed by Transactions.
+type BlockTemplate struct {
+	PrevBlockHash []byte
+	Height        int
+	// Timestamp is the suggested block time; it must be later than
+	// MinTimestamp - 1.
+	Timestamp    int64
+	MinTimestamp int64
+	// Target is the big-endian number the block hash must stay below.
+	Target        []byte
+	CoinbaseValue int
+	Transactions  []*Transaction
+	// MerkleBranch turns the coinbase into the merkle root, see
+	// MerkleRootFromBranch.
+	MerkleBranch [][]byte
+}
+
+// NewExternalBlockTemplate returns the template for the next block on the tip
+// of bc, filled from mp, leaving coinbaseSize bytes for the coinbase.
+func NewExternalBlockTemplate(bc *BlockChain, mp *Mempool, coinbaseSize int) *BlockTemplate {
+	tip, err := bc.GetBlock(bc.Tip())
+	if err != nil {
+		log.Panic(err)
+	}
+	txs, fees := NewBlockTemplate(bc, mp, netParams.MaxBlockSize, coinbaseSize)
+
+	// The branch doesn't depend on the coinbase, so any stands in for it.
+	leaves := [][]byte{{}}
+	for _, tx := range txs {
+		leaves = append(leaves, tx.HashData())
+	}
+
+	return &BlockTemplate{
+		PrevBlockHash: tip.Hash,
+		Height:        tip.Height + 1,
+		Timestamp:     bc.NextBlockTime(&tip),
+		MinTimestamp:  bc.MedianTimePast(&tip) + 1,
+		Target:        NewProofOfWork(&Block{}).target.FillBytes(make([]byte, 32)),
+		CoinbaseValue: subsidy + fees,
+		Transactions:  txs,
+		MerkleBranch:  MerkleBranch(leaves, 0),
+	}
+}
+
+// NewBlock returns the unsolved block with coinbase that t describes.
+func (t *BlockTemplate) NewBlock(coinbase *Transaction) *Block {
+	txs := append([]*Transaction{coinbase}, t.Transactions...)
+
+	return &Block{
+		Timestamp:     t.Timestamp,
+		Transactions:  txs,
+		PrevBlockHash: t.PrevBlockHash,
+		Hash:          []byte{},
+		Height:        t.Height,
+	}
+}
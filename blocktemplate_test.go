@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestNewBlockTemplateCountsCoinbase(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	tx := newTestTx(wallet, genesisCoinbase(t, bc), []int{0}, subsidy)
+	err := processTx(bc, *tx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	maxSize := blockReservedSize + tx.Size() + 10
+
+	tests := []struct {
+		name         string
+		coinbaseSize int
+		want         int
+	}{
+		{"coinbase leaves room", 10, 1},
+		{"coinbase takes the room", 11, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			txs, _ := NewBlockTemplate(bc, mempool, maxSize, tt.coinbaseSize)
+			if len(txs) != tt.want {
+				t.Errorf("template holds %d transactions, want %d", len(txs), tt.want)
+			}
+		})
+	}
+}
+
+func TestMineBlockTooLarge(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	tip := bc.Tip()
+
+	params := *netParams
+	params.MaxBlockSize = 500
+	saved := netParams
+	netParams = &params
+	t.Cleanup(func() { netParams = saved })
+
+	coinbase := NewCoinbaseTX(string(wallet.GetAddress()), strings.Repeat("x", 500), 1, 0)
+	_, err := bc.MineBlock(context.Background(), []*Transaction{coinbase})
+	checkError(t, err, "exceeds")
+	if string(bc.Tip()) != string(tip) {
+		t.Errorf("tip moved to %x", bc.Tip())
+	}
+}
+
+// TestNewBlockTemplateOrder takes a parent paying no fee with the child
+// paying for it, ahead of a transaction paying less for its size, and always
+// puts the parent first.
+func TestNewBlockTemplateOrder(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	genesis := genesisCoinbase(t, bc)
+	address := string(wallet.GetAddress())
+
+	split := Transaction{nil, []TXInput{{genesis.ID, 0, nil, SequenceFinal}}, []TXOutput{*NewTXOutput(4, address), *NewTXOutput(3, address), *NewTXOutput(3, address)}, 0}
+	split.ID = split.Hash()
+	split.Sign(wallet.PrivateKey, map[string]Transaction{hex.EncodeToString(genesis.ID): *genesis})
+	addTestBlock(t, bc, newTestBlock(tipBlock(t, bc), wallet, &split))
+
+	parent := newTestTx(wallet, &split, []int{0}, 4)
+	child := newTestTx(wallet, parent, []int{0}, 1)
+	single := newTestTx(wallet, &split, []int{1}, 2)
+	free := newTestTx(wallet, &split, []int{2}, 3)
+	for _, tx := range []*Transaction{free, single, parent, child} {
+		err := processTx(bc, *tx, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	names := map[string]string{
+		hex.EncodeToString(parent.ID): "parent",
+		hex.EncodeToString(child.ID):  "child",
+		hex.EncodeToString(single.ID): "single",
+		hex.EncodeToString(free.ID):   "free",
+	}
+	room := func(txs ...*Transaction) int {
+		size := blockReservedSize
+		for _, tx := range txs {
+			size += tx.Size()
+		}
+		return size
+	}
+
+	tests := []struct {
+		name    string
+		maxSize int
+		want    []string
+		fees    int
+	}{
+		{"room for all", room(parent, child, single, free), []string{"parent", "child", "single", "free"}, 4},
+		{"room for the package", room(parent, child), []string{"parent", "child"}, 3},
+		{"room for one", room(single), []string{"single"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			txs, fees := NewBlockTemplate(bc, mempool, tt.maxSize, 0)
+			var got []string
+			for _, tx := range txs {
+				got = append(got, names[hex.EncodeToString(tx.ID)])
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") || fees != tt.fees {
+				t.Errorf("template holds %v paying %d, want %v paying %d", got, fees, tt.want, tt.fees)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Checkpoint pins the block at Height of the network's chain to the one with
+// hex hash Hash.
+type Checkpoint struct {
+	Height int
+	Hash   string
+}
+
+// ParseCheckpoint parses a checkpoint written as HEIGHT:HASH.
+func ParseCheckpoint(s string) (Checkpoint, error) {
+	height, hash, ok := strings.Cut(s, ":")
+	if !ok {
+		return Checkpoint{}, fmt.Errorf("invalid checkpoint %q, want HEIGHT:HASH", s)
+	}
+	h, err := strconv.Atoi(height)
+	if err != nil || h < 0 {
+		return Checkpoint{}, fmt.Errorf("invalid checkpoint height in %q", s)
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		return Checkpoint{}, fmt.Errorf("invalid checkpoint %q: %w", s, err)
+	}
+
+	return Checkpoint{h, strings.ToLower(hash)}, nil
+}
+
+func (c Checkpoint) String() string {
+	return fmt.Sprintf("%d:%s", c.Height, c.Hash)
+}
+
+// checkCheckpoints rejects block if it is at the height of a checkpoint but
+// isn't the checkpointed block, or if it forks off the chain below a
+// checkpoint the chain already holds, which rules out reorganizing past it.
+func (bc *BlockChain) checkCheckpoints(block *Block) error {
+	if _, err := bc.GetBlock(block.Hash); err == nil {
+		return nil
+	}
+
+	hash := hex.EncodeToString(block.Hash)
+	for _, cp := range netParams.Checkpoints {
+		if block.Height == cp.Height && hash != cp.Hash {
+			return fmt.Errorf("block at height %d conflicts with checkpoint %s", block.Height, cp.Hash)
+		}
+
+		// Only the checkpointed block can be at its height, so once it
+		// is stored every block below it is its ancestor, and also
+		// stored.
+		if block.Height > cp.Height {
+			continue
+		}
+		cpHash, _ := hex.DecodeString(cp.Hash)
+		if _, err := bc.GetBlock(cpHash); err == nil {
+			return fmt.Errorf("block at height %d forks off below checkpoint %s", block.Height, cp)
+		}
+	}
+
+	return nil
+}
+
+// assumedValid reports whether block is the assume-valid block or one of its
+// ancestors, so that its scripts needn't be verified. That can only be told
+// once the assume-valid block is stored; its ancestors are then looked up
+// once. bc.mu must be held.
+func (bc *BlockChain) assumedValid(block *Block) bool {
+	if netParams.AssumeValid.Hash == "" || block.Height > netParams.AssumeValid.Height {
+		return false
+	}
+
+	if bc.assumeValidChain == nil {
+		hash, _ := hex.DecodeString(netParams.AssumeValid.Hash)
+		ancestor, err := bc.GetBlock(hash)
+		if err != nil {
+			return false
+		}
+		chain := make([][]byte, ancestor.Height+1)
+		for {
+			chain[ancestor.Height] = ancestor.Hash
+			if len(ancestor.PrevBlockHash) == 0 {
+				break
+			}
+			ancestor, err = bc.GetBlock(ancestor.PrevBlockHash)
+			if err != nil {
+				log.Panic(err)
+			}
+		}
+		bc.assumeValidChain = chain
+	}
+
+	return block.Height < len(bc.assumeValidChain) && bytes.Equal(bc.assumeValidChain[block.Height], block.Hash)
+}
+
+// awaitingAssumeValid reports whether block is below the assume-valid block,
+// which isn't stored yet. Connecting it now would verify its scripts, which
+// can be skipped if it turns out to be an ancestor.
+func (bc *BlockChain) awaitingAssumeValid(block *Block) bool {
+	if netParams.AssumeValid.Hash == "" || block.Height >= netParams.AssumeValid.Height {
+		return false
+	}
+	hash, _ := hex.DecodeString(netParams.AssumeValid.Hash)
+	_, err := bc.GetBlock(hash)
+
+	return err != nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// TestAssumeValid connects a block with a bad signature below the
+// assume-valid block. Its scripts are only skipped if it turns out to be an
+// ancestor of the assume-valid block.
+func TestAssumeValid(t *testing.T) {
+	tests := []struct {
+		name string
+		// stored is whether the assume-valid block arrives.
+		stored bool
+		err    string
+	}{
+		{"ancestor of the assume-valid block", true, ""},
+		{"assume-valid block missing", false, "script verification failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc, wallet := newTestChain(t)
+			genesis := genesisCoinbase(t, bc)
+
+			forged := newTestTx(wallet, genesis, []int{0}, subsidy)
+			scriptSig := forged.Vin[0].ScriptSig
+			scriptSig[len(scriptSig)-1] ^= 1
+			bad := newTestBlock(tipBlock(t, bc), wallet, forged)
+			assumeValid := newTestBlock(bad, wallet)
+
+			params := *netParams
+			params.AssumeValid = Checkpoint{assumeValid.Height, hex.EncodeToString(assumeValid.Hash)}
+			saved := netParams
+			netParams = &params
+			t.Cleanup(func() { netParams = saved })
+
+			addTestBlock(t, bc, bad)
+			if height := bc.GetBestHeight(); height != 0 {
+				t.Fatalf("tip is at height %d before the assume-valid block came", height)
+			}
+			if height := bc.BestKnownHeight(); height != 1 {
+				t.Errorf("best known height is %d with a block held, want 1", height)
+			}
+			if tt.stored {
+				addTestBlock(t, bc, assumeValid)
+			}
+			checkError(t, bc.ActivateHeldBlocks(), tt.err)
+
+			want := 2
+			if !tt.stored {
+				want = 0
+			}
+			if height := bc.GetBestHeight(); height != want {
+				t.Errorf("tip is at height %d, want %d", height, want)
+			}
+		})
+	}
+}
+
+// TestAssumeValidIsNoCheckpoint accepts a chain that doesn't hold the
+// assume-valid block.
+func TestAssumeValidIsNoCheckpoint(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	block := newTestBlock(tipBlock(t, bc), wallet)
+
+	params := *netParams
+	params.AssumeValid = Checkpoint{block.Height, hex.EncodeToString([]byte("another block"))}
+	saved := netParams
+	netParams = &params
+	t.Cleanup(func() { netParams = saved })
+
+	addTestBlock(t, bc, block)
+	if height := bc.GetBestHeight(); height != block.Height {
+		t.Errorf("tip is at height %d, want %d", height, block.Height)
+	}
+}
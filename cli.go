@@ -1,11 +1,14 @@
 package main
 
 import (
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"runtime"
 	"strconv"
+	"strings"
 )
 
 type CLI struct {
@@ -22,13 +25,51 @@ func (cli *CLI) validateArgs() {
 func (cli *CLI) printUsage() {
 	fmt.Println("Usage:")
 	fmt.Println("  printchain - print all the blocks of the blockchain")
-	fmt.Println("  createblockchain -address ADDRESS - create a blockchain and send genesis block reward to ADDRESS")
+	fmt.Println("  createblockchain -address ADDRESS -consensus ENGINE -signers ADDRESS,... -period SECONDS - create a blockchain and send genesis block reward to ADDRESS")
+	fmt.Println("    -consensus is pow (default), poa, where the -signers take turns signing a block at most every -period seconds, or pos, where stakers sign blocks in proportion to their stake, starting with ADDRESS staking the genesis reward")
 	fmt.Println("  createwallet - generates a new key-pair and saves it into the wallet file")
 	fmt.Println("  listaddresses - lists all addresses from the wallet file")
 	fmt.Println("  getbalance -address ADDRESS - get balance of ADDRESS")
+	fmt.Println("  listunspent -address ADDRESS - list the unspent outputs of ADDRESS")
 	fmt.Println("  reindexutxo - Rebuilds the UTXO set")
-	fmt.Println("  send -from FROM -to TO -amount AMOUNT -mine - send AMOUNT of coins from FROM address to TO. Mine on the same node, when -mine is set.")
-	fmt.Println("  startnode -miner ADDRESS - Start a node with ID specified in NODE_ID envvar. -miner enables mining")
+	fmt.Println("  invalidateblock -hash HASH - mark block HASH and its descendants invalid, switching to the best valid chain if the tip was among them")
+	fmt.Println("  reconsiderblock -hash HASH - clear the invalid mark of block HASH and its descendants, switching to them if they make a longer chain")
+	fmt.Println("  send -from FROM -to TO -amount AMOUNT -feerate RATE -coinselect STRATEGY -inputs TXID:VOUT,... -locktime LOCKTIME -sequence SEQUENCE -rbf -data DATA -mine - send AMOUNT of coins from FROM address to TO. Mine on the same node, when -mine is set.")
+	fmt.Println("    -feerate is the fee paid per 1000 bytes of transaction")
+	fmt.Println("    -coinselect picks the coins to spend: largest (default), smallest, bnb (exact match, no change) or random")
+	fmt.Println("    -inputs spends exactly the listed TXID:VOUT outputs, as shown by listunspent")
+	fmt.Println("    -locktime keeps the transaction out of blocks until that height, or Unix time from 500000000 on")
+	fmt.Println("    -sequence sets a relative lock on every input: a number of blocks, or of 512 seconds with bit 22 set")
+	fmt.Println("    -rbf lets the transaction be replaced by one paying a higher fee until it is mined")
+	fmt.Printf("    -data attaches up to %d bytes of hex data in an unspendable output\n", maxDataCarrierSize)
+	fmt.Println("  bumpfee -txid TXID -feerate RATE -mine - replace an unconfirmed transaction that signaled -rbf with one paying a higher fee out of its change")
+	fmt.Println("  sendmany -from FROM -file PATH -feerate RATE -mine - pay every address/amount pair listed in a CSV or JSON file from FROM in one transaction")
+	fmt.Println("  stake -address ADDRESS -amount AMOUNT -mine - lock AMOUNT of the coins of ADDRESS as proof of stake for it to produce blocks")
+	fmt.Println("  unstake -address ADDRESS -amount AMOUNT -withdraw -mine - unbond AMOUNT (default all) of the stake of ADDRESS, which stays locked and slashable for the unbonding period; -withdraw spends the unbonded coins back to ADDRESS once it is over")
+	fmt.Println("  validators - list the stake and unbonding coins of every staker and the next block producer")
+	fmt.Println("  anchor -from FROM -file PATH -mine - record the SHA-256 hash of a file on chain in a transaction from FROM")
+	fmt.Println("  verifyanchor -file PATH | -hash HASH - show the block in which a file or hash was anchored")
+	fmt.Println("  getpubkey -address ADDRESS - print the public key of a wallet address, to share with multisig co-signers")
+	fmt.Println("  createmultisig -required M -pubkeys KEY,KEY,... - create an M-of-N multisig address and its script hash address from hex public keys")
+	fmt.Println("  createscripthash -script SCRIPT - print the pay-to-script-hash address of a hex redeem script")
+	fmt.Println("  createmultisigtx -from MULTISIG -to TO -amount AMOUNT -redeemscript SCRIPT -out FILE - create an unsigned multisig spend and save it to FILE; -redeemscript is needed for script hash addresses")
+	fmt.Println("  signmultisigtx -in FILE -address ADDRESS - add the signature of wallet ADDRESS to the multisig spend in FILE")
+	fmt.Println("  finalizemultisigtx -in FILE -mine - check the collected signatures and broadcast the multisig spend in FILE")
+	fmt.Println("  initiate -from FROM -to TO -amount AMOUNT -locktime LOCKTIME -mine - start an atomic swap: lock AMOUNT in a contract TO can redeem with a new secret, refundable to FROM after LOCKTIME (default 48 hours)")
+	fmt.Println("  participate -from FROM -to TO -amount AMOUNT -secrethash HASH -locktime LOCKTIME -mine - answer an atomic swap with a contract locked by the initiator's secret hash (default lock 24 hours)")
+	fmt.Println("  redeem -contract CONTRACT -contracttx TXID -secret SECRET -mine - claim a swap contract by revealing its secret")
+	fmt.Println("  refund -contract CONTRACT -contracttx TXID -mine - take back the coins of an expired swap contract")
+	fmt.Println("  auditcontract -contract CONTRACT -contracttx TXID - show the terms and value of a swap contract")
+	fmt.Println("  extractsecret -redeemtx TXID -secrethash HASH - recover the secret revealed by the redemption of a swap contract")
+	fmt.Printf("  startnode -miner ADDRESS -workers N -mintxs N -interval DURATION -maxblocksize BYTES -pool HOST:PORT -poolreward ADDRESS -rest HOST:PORT -explorer HOST:PORT -assumevalid HEIGHT:HASH -prunedepth N -prunebudget MB - Start a node with ID specified in NODE_ID envvar. -miner enables mining on N goroutines (default: one per CPU) with the policy of startmining, -pool runs a mining pool for poolmine workers that pays what shares don't cover to -poolreward, -rest serves the read-only REST API and the /events stream, -explorer serves the block explorer, -assumevalid skips the script checks of the blocks up to HASH while syncing, -prunedepth and -prunebudget delete the bodies of blocks N deep or beyond MB megabytes, always keeping the last %d\n", minPruneDepth)
+	fmt.Println("  startmining -address ADDRESS -mintxs N -interval DURATION -maxblocksize BYTES - start mining on the running node, paying rewards to ADDRESS")
+	fmt.Println("    -mintxs mines once that many transactions are waiting (default 2)")
+	fmt.Println("    -interval mines whatever is waiting, even nothing, that long after the last block (default 10m, 0 disables)")
+	fmt.Println("    -maxblocksize keeps mined blocks below the network limit")
+	fmt.Println("  stopmining - stop mining on the running node")
+	fmt.Println("  runminer -address ADDRESS -workers N - mine on N goroutines in this process for the running node, fetching block templates and submitting solved blocks over RPC")
+	fmt.Println("  poolmine -pool HOST:PORT -address ADDRESS -workers N - mine shares on N goroutines for a node started with -pool, earning a part of every block it finds for ADDRESS")
+	fmt.Println("  getmininginfo - show whether the running node is mining, its policy and its hash rate")
 }
 
 func (cli *CLI) Run() {
@@ -45,17 +86,131 @@ func (cli *CLI) Run() {
 	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
 	listAddressesCmd := flag.NewFlagSet("listaddresses", flag.ExitOnError)
 	getBalanceCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
+	listUnspentCmd := flag.NewFlagSet("listunspent", flag.ExitOnError)
 	reindexUTXOCmd := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
+	invalidateBlockCmd := flag.NewFlagSet("invalidateblock", flag.ExitOnError)
+	reconsiderBlockCmd := flag.NewFlagSet("reconsiderblock", flag.ExitOnError)
 	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
+	sendManyCmd := flag.NewFlagSet("sendmany", flag.ExitOnError)
+	bumpFeeCmd := flag.NewFlagSet("bumpfee", flag.ExitOnError)
 	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
+	startMiningCmd := flag.NewFlagSet("startmining", flag.ExitOnError)
+	stopMiningCmd := flag.NewFlagSet("stopmining", flag.ExitOnError)
+	getMiningInfoCmd := flag.NewFlagSet("getmininginfo", flag.ExitOnError)
+	runMinerCmd := flag.NewFlagSet("runminer", flag.ExitOnError)
+	poolMineCmd := flag.NewFlagSet("poolmine", flag.ExitOnError)
+	stakeCmd := flag.NewFlagSet("stake", flag.ExitOnError)
+	unstakeCmd := flag.NewFlagSet("unstake", flag.ExitOnError)
+	validatorsCmd := flag.NewFlagSet("validators", flag.ExitOnError)
+	anchorCmd := flag.NewFlagSet("anchor", flag.ExitOnError)
+	verifyAnchorCmd := flag.NewFlagSet("verifyanchor", flag.ExitOnError)
+	initiateCmd := flag.NewFlagSet("initiate", flag.ExitOnError)
+	participateCmd := flag.NewFlagSet("participate", flag.ExitOnError)
+	redeemCmd := flag.NewFlagSet("redeem", flag.ExitOnError)
+	refundCmd := flag.NewFlagSet("refund", flag.ExitOnError)
+	auditContractCmd := flag.NewFlagSet("auditcontract", flag.ExitOnError)
+	extractSecretCmd := flag.NewFlagSet("extractsecret", flag.ExitOnError)
+	getPubKeyCmd := flag.NewFlagSet("getpubkey", flag.ExitOnError)
+	createMultiSigCmd := flag.NewFlagSet("createmultisig", flag.ExitOnError)
+	createScriptHashCmd := flag.NewFlagSet("createscripthash", flag.ExitOnError)
+	createMultiSigTxCmd := flag.NewFlagSet("createmultisigtx", flag.ExitOnError)
+	signMultiSigTxCmd := flag.NewFlagSet("signmultisigtx", flag.ExitOnError)
+	finalizeMultiSigTxCmd := flag.NewFlagSet("finalizemultisigtx", flag.ExitOnError)
 
 	createChainAddress := createChainCmd.String("address", "", "The address to send genesis block reward to")
+	createChainConsensus := createChainCmd.String("consensus", ConsensusProofOfWork, "Consensus engine: pow, poa or pos")
+	createChainSigners := createChainCmd.String("signers", "", "Comma separated addresses of the proof of authority signers, in turn order")
+	createChainPeriod := createChainCmd.Int64("period", 5, "Minimum seconds between proof of authority or proof of stake blocks")
 	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for")
+	listUnspentAddress := listUnspentCmd.String("address", "", "The address to list unspent outputs for")
 	sendFrom := sendCmd.String("from", "", "Source wallet address")
 	sendTo := sendCmd.String("to", "", "Destination wallet address")
 	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
+	sendFeeRate := sendCmd.Int("feerate", DefaultFeeRate, "Fee per 1000 bytes")
+	sendCoinSelect := sendCmd.String("coinselect", CoinSelectLargestFirst, "Coin selection strategy: largest, smallest, bnb or random")
+	sendInputs := sendCmd.String("inputs", "", "Comma separated TXID:VOUT outputs to spend")
+	sendLockTime := sendCmd.Int64("locktime", 0, "Height or Unix time before which the transaction can't be mined")
+	sendSequence := sendCmd.Uint("sequence", SequenceFinal, "Relative lock of every input, encoded as in BIP 68")
+	sendRBF := sendCmd.Bool("rbf", false, "Allow replacing the transaction by one paying a higher fee")
+	sendData := sendCmd.String("data", "", "Hex data to attach in an unspendable output")
 	sendMine := sendCmd.Bool("mine", false, "Mine immediately on the same node")
+	sendManyFrom := sendManyCmd.String("from", "", "Source wallet address")
+	sendManyFile := sendManyCmd.String("file", "", "CSV or JSON file of addresses and amounts")
+	sendManyFeeRate := sendManyCmd.Int("feerate", DefaultFeeRate, "Fee per 1000 bytes")
+	sendManyMine := sendManyCmd.Bool("mine", false, "Mine immediately on the same node")
+	bumpFeeTxID := bumpFeeCmd.String("txid", "", "ID of the transaction to replace")
+	bumpFeeRate := bumpFeeCmd.Int("feerate", 0, "New fee per 1000 bytes; by default the fee grows by the default rate")
+	bumpFeeMine := bumpFeeCmd.Bool("mine", false, "Mine immediately on the same node")
 	startNodeMiner := startNodeCmd.String("miner", "", "Enable mining mode and send reward to ADDRESS")
+	startNodeWorkers := startNodeCmd.Int("workers", runtime.NumCPU(), "Mine on N goroutines")
+	startNodeMinTxs := startNodeCmd.Int("mintxs", DefaultMiningPolicy.MinTransactions, "Mine once N transactions are waiting")
+	startNodeInterval := startNodeCmd.Duration("interval", DefaultMiningPolicy.TargetInterval, "Mine whatever is waiting, even nothing, this long after the last block; 0 disables")
+	startNodeMaxBlockSize := startNodeCmd.Int("maxblocksize", 0, "Mine blocks of at most BYTES; 0 is the network limit")
+	startNodePool := startNodeCmd.String("pool", "", "Run a mining pool on HOST:PORT")
+	startNodePoolReward := startNodeCmd.String("poolreward", "", "The address to send the rewards shares don't cover to")
+	startNodeREST := startNodeCmd.String("rest", "", "Serve the REST API on HOST:PORT")
+	startNodeExplorer := startNodeCmd.String("explorer", "", "Serve the block explorer on HOST:PORT")
+	startNodeAssumeValid := startNodeCmd.String("assumevalid", "", "Skip the script checks of blocks up to the block HEIGHT:HASH")
+	startNodePruneDepth := startNodeCmd.Int("prunedepth", 0, "Delete the bodies of blocks N or more below the tip; 0 keeps them")
+	startNodePruneBudget := startNodeCmd.Int("prunebudget", 0, "Delete the bodies of the oldest blocks to keep them under MB megabytes; 0 keeps them")
+	startMiningAddress := startMiningCmd.String("address", "", "The address to send block rewards to")
+	startMiningMinTxs := startMiningCmd.Int("mintxs", DefaultMiningPolicy.MinTransactions, "Mine once N transactions are waiting")
+	startMiningInterval := startMiningCmd.Duration("interval", DefaultMiningPolicy.TargetInterval, "Mine whatever is waiting, even nothing, this long after the last block; 0 disables")
+	startMiningMaxBlockSize := startMiningCmd.Int("maxblocksize", 0, "Mine blocks of at most BYTES; 0 is the network limit")
+	runMinerAddress := runMinerCmd.String("address", "", "The address to send block rewards to")
+	runMinerWorkers := runMinerCmd.Int("workers", runtime.NumCPU(), "Mine on N goroutines")
+	poolMinePool := poolMineCmd.String("pool", "", "HOST:PORT of the mining pool")
+	poolMineAddress := poolMineCmd.String("address", "", "The address to send the share of block rewards to")
+	poolMineWorkers := poolMineCmd.Int("workers", runtime.NumCPU(), "Mine on N goroutines")
+	stakeAddress := stakeCmd.String("address", "", "Wallet address staking its coins")
+	stakeAmount := stakeCmd.Int("amount", 0, "Amount to stake")
+	stakeMine := stakeCmd.Bool("mine", false, "Mine immediately on the same node")
+	unstakeAddress := unstakeCmd.String("address", "", "Wallet address whose stake to unbond")
+	unstakeAmount := unstakeCmd.Int("amount", 0, "Amount to unbond; 0 unbonds all")
+	unstakeWithdraw := unstakeCmd.Bool("withdraw", false, "Spend the coins whose unbonding period is over instead")
+	unstakeMine := unstakeCmd.Bool("mine", false, "Mine immediately on the same node")
+	invalidateBlockHash := invalidateBlockCmd.String("hash", "", "Hash of the block to invalidate")
+	reconsiderBlockHash := reconsiderBlockCmd.String("hash", "", "Hash of the block to reconsider")
+	anchorFrom := anchorCmd.String("from", "", "Wallet address paying for the anchor")
+	anchorFile := anchorCmd.String("file", "", "File whose hash to anchor")
+	anchorMine := anchorCmd.Bool("mine", false, "Mine immediately on the same node")
+	verifyAnchorFile := verifyAnchorCmd.String("file", "", "File whose hash to look up")
+	verifyAnchorHash := verifyAnchorCmd.String("hash", "", "Hex hash to look up")
+	initiateFrom := initiateCmd.String("from", "", "Initiator wallet address, refunded after the lock time")
+	initiateTo := initiateCmd.String("to", "", "Participant address that can redeem the contract")
+	initiateAmount := initiateCmd.Int("amount", 0, "Amount to lock in the contract")
+	initiateLockTime := initiateCmd.Int64("locktime", 0, "Height or Unix time after which the contract can be refunded")
+	initiateMine := initiateCmd.Bool("mine", false, "Mine immediately on the same node")
+	participateFrom := participateCmd.String("from", "", "Participant wallet address, refunded after the lock time")
+	participateTo := participateCmd.String("to", "", "Initiator address that can redeem the contract")
+	participateAmount := participateCmd.Int("amount", 0, "Amount to lock in the contract")
+	participateSecretHash := participateCmd.String("secrethash", "", "Secret hash of the initiator's contract")
+	participateLockTime := participateCmd.Int64("locktime", 0, "Height or Unix time after which the contract can be refunded")
+	participateMine := participateCmd.Bool("mine", false, "Mine immediately on the same node")
+	redeemContract := redeemCmd.String("contract", "", "Hex contract script")
+	redeemContractTx := redeemCmd.String("contracttx", "", "ID of the transaction funding the contract")
+	redeemSecret := redeemCmd.String("secret", "", "Hex swap secret")
+	redeemMine := redeemCmd.Bool("mine", false, "Mine immediately on the same node")
+	refundContract := refundCmd.String("contract", "", "Hex contract script")
+	refundContractTx := refundCmd.String("contracttx", "", "ID of the transaction funding the contract")
+	refundMine := refundCmd.Bool("mine", false, "Mine immediately on the same node")
+	auditContractContract := auditContractCmd.String("contract", "", "Hex contract script")
+	auditContractContractTx := auditContractCmd.String("contracttx", "", "ID of the transaction funding the contract")
+	extractSecretRedeemTx := extractSecretCmd.String("redeemtx", "", "ID of the transaction redeeming the contract")
+	extractSecretSecretHash := extractSecretCmd.String("secrethash", "", "Secret hash of the contract")
+	getPubKeyAddress := getPubKeyCmd.String("address", "", "Wallet address")
+	createMultiSigRequired := createMultiSigCmd.Int("required", 0, "Number of signatures required")
+	createMultiSigPubKeys := createMultiSigCmd.String("pubkeys", "", "Comma separated hex public keys")
+	createScriptHashScript := createScriptHashCmd.String("script", "", "Hex redeem script")
+	createMultiSigTxFrom := createMultiSigTxCmd.String("from", "", "Source multisig address")
+	createMultiSigTxTo := createMultiSigTxCmd.String("to", "", "Destination address")
+	createMultiSigTxAmount := createMultiSigTxCmd.Int("amount", 0, "Amount to send")
+	createMultiSigTxRedeemScript := createMultiSigTxCmd.String("redeemscript", "", "Hex redeem script of a script hash address")
+	createMultiSigTxOut := createMultiSigTxCmd.String("out", "", "File to write the unsigned transaction to")
+	signMultiSigTxIn := signMultiSigTxCmd.String("in", "", "Partially signed transaction file")
+	signMultiSigTxAddress := signMultiSigTxCmd.String("address", "", "Wallet address to sign with")
+	finalizeMultiSigTxIn := finalizeMultiSigTxCmd.String("in", "", "Partially signed transaction file")
+	finalizeMultiSigTxMine := finalizeMultiSigTxCmd.Bool("mine", false, "Mine immediately on the same node")
 
 	switch os.Args[1] {
 	case "printchain":
@@ -83,21 +238,156 @@ func (cli *CLI) Run() {
 		if err != nil {
 			log.Panic("getbalance command parse failed!: ", err)
 		}
+	case "listunspent":
+		err := listUnspentCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "reindexutxo":
 		err := reindexUTXOCmd.Parse(os.Args[2:])
 		if err != nil {
 			log.Panic(err)
 		}
+	case "invalidateblock":
+		err := invalidateBlockCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "reconsiderblock":
+		err := reconsiderBlockCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "send":
 		err := sendCmd.Parse(os.Args[2:])
 		if err != nil {
 			log.Panic("send command parse failed!: ", err)
 		}
+	case "sendmany":
+		err := sendManyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "bumpfee":
+		err := bumpFeeCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "startnode":
 		err := startNodeCmd.Parse(os.Args[2:])
 		if err != nil {
 			log.Panic(err)
 		}
+	case "startmining":
+		err := startMiningCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "stopmining":
+		err := stopMiningCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getmininginfo":
+		err := getMiningInfoCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "runminer":
+		err := runMinerCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "poolmine":
+		err := poolMineCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "stake":
+		err := stakeCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "unstake":
+		err := unstakeCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "validators":
+		err := validatorsCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "anchor":
+		err := anchorCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "verifyanchor":
+		err := verifyAnchorCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "initiate":
+		err := initiateCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "participate":
+		err := participateCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "redeem":
+		err := redeemCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "refund":
+		err := refundCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "auditcontract":
+		err := auditContractCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "extractsecret":
+		err := extractSecretCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getpubkey":
+		err := getPubKeyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createmultisig":
+		err := createMultiSigCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createscripthash":
+		err := createScriptHashCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createmultisigtx":
+		err := createMultiSigTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "signmultisigtx":
+		err := signMultiSigTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "finalizemultisigtx":
+		err := finalizeMultiSigTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	default:
 		cli.printUsage()
 		os.Exit(1)
@@ -112,7 +402,19 @@ func (cli *CLI) Run() {
 			createChainCmd.Usage()
 			os.Exit(1)
 		}
-		cli.createBlockChain(*createChainAddress, nodeID)
+		consensus := ConsensusConfig{Engine: *createChainConsensus}
+		switch consensus.Engine {
+		case ConsensusProofOfAuthority:
+			if *createChainSigners == "" {
+				createChainCmd.Usage()
+				os.Exit(1)
+			}
+			consensus.Signers = strings.Split(*createChainSigners, ",")
+			consensus.Period = *createChainPeriod
+		case ConsensusProofOfStake:
+			consensus.Period = *createChainPeriod
+		}
+		cli.createBlockChain(*createChainAddress, consensus, nodeID)
 	}
 
 	if createWalletCmd.Parsed() {
@@ -131,37 +433,330 @@ func (cli *CLI) Run() {
 		cli.getBalance(*getBalanceAddress, nodeID)
 	}
 
+	if listUnspentCmd.Parsed() {
+		if *listUnspentAddress == "" {
+			listUnspentCmd.Usage()
+			os.Exit(1)
+		}
+		cli.listUnspent(*listUnspentAddress, nodeID)
+	}
+
 	if reindexUTXOCmd.Parsed() {
 		cli.reindexUTXO(nodeID)
 	}
 
+	if invalidateBlockCmd.Parsed() {
+		if *invalidateBlockHash == "" {
+			invalidateBlockCmd.Usage()
+			os.Exit(1)
+		}
+		cli.invalidateBlock(*invalidateBlockHash, nodeID)
+	}
+
+	if reconsiderBlockCmd.Parsed() {
+		if *reconsiderBlockHash == "" {
+			reconsiderBlockCmd.Usage()
+			os.Exit(1)
+		}
+		cli.reconsiderBlock(*reconsiderBlockHash, nodeID)
+	}
+
 	if sendCmd.Parsed() {
 		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 {
 			sendCmd.Usage()
 			os.Exit(1)
 		}
 
-		cli.send(*sendFrom, *sendTo, *sendAmount, nodeID, *sendMine)
+		data, err := hex.DecodeString(*sendData)
+		if err != nil {
+			log.Panic(err)
+		}
+		var inputs []OutPoint
+		if *sendInputs != "" {
+			for _, s := range strings.Split(*sendInputs, ",") {
+				input, err := ParseOutPoint(strings.TrimSpace(s))
+				if err != nil {
+					log.Panic(err)
+				}
+				inputs = append(inputs, input)
+			}
+		}
+		req := TransactionRequest{
+			From:          *sendFrom,
+			Payments:      []Payment{{*sendTo, *sendAmount}},
+			FeeRate:       *sendFeeRate,
+			CoinSelection: *sendCoinSelect,
+			Inputs:        inputs,
+			Data:          data,
+		}
+		sequence := uint32(*sendSequence)
+		if *sendRBF && sequence >= SequenceMaxNonReplaceable {
+			sequence = SequenceReplaceable
+		}
+		cli.send(req, *sendLockTime, sequence, nodeID, *sendMine)
+	}
+
+	if sendManyCmd.Parsed() {
+		if *sendManyFrom == "" || *sendManyFile == "" {
+			sendManyCmd.Usage()
+			os.Exit(1)
+		}
+		cli.sendMany(*sendManyFrom, *sendManyFile, *sendManyFeeRate, nodeID, *sendManyMine)
+	}
+
+	if bumpFeeCmd.Parsed() {
+		if *bumpFeeTxID == "" {
+			bumpFeeCmd.Usage()
+			os.Exit(1)
+		}
+		cli.bumpFee(*bumpFeeTxID, *bumpFeeRate, nodeID, *bumpFeeMine)
 	}
 
 	if startNodeCmd.Parsed() {
-		cli.startNode(nodeID, *startNodeMiner)
+		if *startNodeWorkers < 1 || (*startNodePool != "") != (*startNodePoolReward != "") || *startNodePruneDepth < 0 || *startNodePruneBudget < 0 {
+			startNodeCmd.Usage()
+			os.Exit(1)
+		}
+		var assumeValid *Checkpoint
+		if *startNodeAssumeValid != "" {
+			cp, err := ParseCheckpoint(*startNodeAssumeValid)
+			if err != nil {
+				log.Panic(err)
+			}
+			assumeValid = &cp
+		}
+		cli.startNode(nodeID, ServerOptions{
+			MinerAddress: *startNodeMiner,
+			MinerWorkers: *startNodeWorkers,
+			MiningPolicy: MiningPolicy{
+				MinTransactions: *startNodeMinTxs,
+				TargetInterval:  *startNodeInterval,
+				MaxBlockSize:    *startNodeMaxBlockSize,
+			},
+			PoolAddress:       *startNodePool,
+			PoolRewardAddress: *startNodePoolReward,
+			RESTAddress:       *startNodeREST,
+			ExplorerAddress:   *startNodeExplorer,
+			AssumeValid:       assumeValid,
+			Prune: PrunePolicy{
+				Depth:  *startNodePruneDepth,
+				Budget: *startNodePruneBudget * 1000000,
+			},
+		})
+	}
+
+	if startMiningCmd.Parsed() {
+		if *startMiningAddress == "" {
+			startMiningCmd.Usage()
+			os.Exit(1)
+		}
+		cli.startMining(*startMiningAddress, MiningPolicy{
+			MinTransactions: *startMiningMinTxs,
+			TargetInterval:  *startMiningInterval,
+			MaxBlockSize:    *startMiningMaxBlockSize,
+		}, nodeID)
+	}
+
+	if stopMiningCmd.Parsed() {
+		cli.stopMining(nodeID)
+	}
+
+	if getMiningInfoCmd.Parsed() {
+		cli.getMiningInfo(nodeID)
+	}
+
+	if runMinerCmd.Parsed() {
+		if *runMinerAddress == "" || *runMinerWorkers < 1 {
+			runMinerCmd.Usage()
+			os.Exit(1)
+		}
+		cli.runMiner(*runMinerAddress, *runMinerWorkers, nodeID)
+	}
+
+	if poolMineCmd.Parsed() {
+		if *poolMinePool == "" || *poolMineAddress == "" || *poolMineWorkers < 1 {
+			poolMineCmd.Usage()
+			os.Exit(1)
+		}
+		cli.poolMine(*poolMinePool, *poolMineAddress, *poolMineWorkers)
+	}
+
+	if stakeCmd.Parsed() {
+		if *stakeAddress == "" || *stakeAmount <= 0 {
+			stakeCmd.Usage()
+			os.Exit(1)
+		}
+		cli.stake(*stakeAddress, *stakeAmount, nodeID, *stakeMine)
+	}
+
+	if unstakeCmd.Parsed() {
+		if *unstakeAddress == "" || *unstakeAmount < 0 || (*unstakeWithdraw && *unstakeAmount != 0) {
+			unstakeCmd.Usage()
+			os.Exit(1)
+		}
+		if *unstakeWithdraw {
+			cli.withdrawStake(*unstakeAddress, nodeID, *unstakeMine)
+		} else {
+			cli.unstake(*unstakeAddress, *unstakeAmount, nodeID, *unstakeMine)
+		}
+	}
+
+	if validatorsCmd.Parsed() {
+		cli.validators(nodeID)
+	}
+
+	if anchorCmd.Parsed() {
+		if *anchorFrom == "" || *anchorFile == "" {
+			anchorCmd.Usage()
+			os.Exit(1)
+		}
+		cli.anchor(*anchorFrom, *anchorFile, nodeID, *anchorMine)
+	}
+
+	if verifyAnchorCmd.Parsed() {
+		if (*verifyAnchorFile == "") == (*verifyAnchorHash == "") {
+			verifyAnchorCmd.Usage()
+			os.Exit(1)
+		}
+		cli.verifyAnchor(*verifyAnchorFile, *verifyAnchorHash, nodeID)
+	}
+
+	if initiateCmd.Parsed() {
+		if *initiateFrom == "" || *initiateTo == "" || *initiateAmount <= 0 {
+			initiateCmd.Usage()
+			os.Exit(1)
+		}
+		cli.initiateSwap(*initiateFrom, *initiateTo, *initiateAmount, *initiateLockTime, nodeID, *initiateMine)
+	}
+
+	if participateCmd.Parsed() {
+		if *participateFrom == "" || *participateTo == "" || *participateAmount <= 0 || *participateSecretHash == "" {
+			participateCmd.Usage()
+			os.Exit(1)
+		}
+		cli.participateSwap(*participateFrom, *participateTo, *participateAmount, *participateSecretHash, *participateLockTime, nodeID, *participateMine)
+	}
+
+	if redeemCmd.Parsed() {
+		if *redeemContract == "" || *redeemContractTx == "" || *redeemSecret == "" {
+			redeemCmd.Usage()
+			os.Exit(1)
+		}
+		cli.redeemSwap(*redeemContract, *redeemContractTx, *redeemSecret, nodeID, *redeemMine)
+	}
+
+	if refundCmd.Parsed() {
+		if *refundContract == "" || *refundContractTx == "" {
+			refundCmd.Usage()
+			os.Exit(1)
+		}
+		cli.refundSwap(*refundContract, *refundContractTx, nodeID, *refundMine)
+	}
+
+	if auditContractCmd.Parsed() {
+		if *auditContractContract == "" || *auditContractContractTx == "" {
+			auditContractCmd.Usage()
+			os.Exit(1)
+		}
+		cli.auditContract(*auditContractContract, *auditContractContractTx, nodeID)
+	}
+
+	if extractSecretCmd.Parsed() {
+		if *extractSecretRedeemTx == "" || *extractSecretSecretHash == "" {
+			extractSecretCmd.Usage()
+			os.Exit(1)
+		}
+		cli.extractSecret(*extractSecretRedeemTx, *extractSecretSecretHash, nodeID)
+	}
+
+	if getPubKeyCmd.Parsed() {
+		if *getPubKeyAddress == "" {
+			getPubKeyCmd.Usage()
+			os.Exit(1)
+		}
+		cli.getPubKey(*getPubKeyAddress, nodeID)
+	}
+
+	if createMultiSigCmd.Parsed() {
+		if *createMultiSigRequired <= 0 || *createMultiSigPubKeys == "" {
+			createMultiSigCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createMultiSig(*createMultiSigRequired, strings.Split(*createMultiSigPubKeys, ","))
+	}
+
+	if createScriptHashCmd.Parsed() {
+		if *createScriptHashScript == "" {
+			createScriptHashCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createScriptHash(*createScriptHashScript)
+	}
+
+	if createMultiSigTxCmd.Parsed() {
+		if *createMultiSigTxFrom == "" || *createMultiSigTxTo == "" || *createMultiSigTxAmount <= 0 || *createMultiSigTxOut == "" {
+			createMultiSigTxCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createMultiSigTx(*createMultiSigTxFrom, *createMultiSigTxTo, *createMultiSigTxAmount, *createMultiSigTxRedeemScript, *createMultiSigTxOut, nodeID)
+	}
+
+	if signMultiSigTxCmd.Parsed() {
+		if *signMultiSigTxIn == "" || *signMultiSigTxAddress == "" {
+			signMultiSigTxCmd.Usage()
+			os.Exit(1)
+		}
+		cli.signMultiSigTx(*signMultiSigTxIn, *signMultiSigTxAddress, nodeID)
+	}
+
+	if finalizeMultiSigTxCmd.Parsed() {
+		if *finalizeMultiSigTxIn == "" {
+			finalizeMultiSigTxCmd.Usage()
+			os.Exit(1)
+		}
+		cli.finalizeMultiSigTx(*finalizeMultiSigTxIn, nodeID, *finalizeMultiSigTxMine)
 	}
 }
 
 func (cli *CLI) printChain(nodeID string) {
-	bc := NewBlockChain(nodeID)
-	defer bc.db.Close()
+	client := NewNodeClient(nodeID)
+	defer client.Close()
 
-	bci := bc.Iterator()
+	consensus, err := client.GetConsensus()
+	if err != nil {
+		log.Panic(err)
+	}
+	engine, err := NewConsensusEngine(consensus, nodeID, client, nil)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	hash, err := client.GetBestBlockHash()
+	if err != nil {
+		log.Panic(err)
+	}
 
 	for {
-		b := bci.Next()
+		b, err := client.GetBlock(hash)
+		if err != nil {
+			log.Panic(err)
+		}
+
 		fmt.Printf("============== Block %x ==============\n", b.Hash)
 		fmt.Printf("Height: %d\n", b.Height)
 		fmt.Printf("Prev. block: %x\n", b.PrevBlockHash)
-		pow := NewProofOfWork(b)
-		fmt.Printf("PoW: %s\n\n", strconv.FormatBool(pow.Validate()))
+		var parent *Block
+		if len(b.PrevBlockHash) > 0 {
+			parent, err = client.GetBlock(b.PrevBlockHash)
+			if err != nil {
+				log.Panic(err)
+			}
+		}
+		if b.Pruned() {
+			fmt.Printf("Seal: pruned\n\n")
+		} else {
+			fmt.Printf("Seal: %s\n\n", strconv.FormatBool(engine.VerifySeal(parent, b) == nil))
+		}
 		for _, tx := range b.Transactions {
 			fmt.Println(tx)
 		}
@@ -170,11 +765,12 @@ func (cli *CLI) printChain(nodeID string) {
 		if len(b.PrevBlockHash) == 0 {
 			break
 		}
+		hash = b.PrevBlockHash
 	}
 }
 
-func (cli *CLI) createBlockChain(address, nodeID string) {
-	bc := CreateBlockChain(address, nodeID)
+func (cli *CLI) createBlockChain(address string, consensus ConsensusConfig, nodeID string) {
+	bc := CreateBlockChain(address, nodeID, consensus)
 	defer bc.db.Close()
 
 	UTXOSet := UTXOSet{bc}
@@ -184,22 +780,34 @@ func (cli *CLI) createBlockChain(address, nodeID string) {
 }
 
 func (cli *CLI) getBalance(address, nodeID string) {
-	bc := NewBlockChain(nodeID)
-	UTXOSet := UTXOSet{bc}
-	defer bc.db.Close()
-
-	balance := 0
-	pubKeyHash := Base58Decode([]byte(address))
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	UTXOs := UTXOSet.FindUTXO(pubKeyHash)
+	client := NewNodeClient(nodeID)
+	defer client.Close()
 
-	for _, out := range UTXOs {
-		balance += out.Value
+	balance, err := client.GetBalance(address)
+	if err != nil {
+		log.Panic(err)
 	}
 
 	fmt.Printf("Balance of '%s' : %d\n", address, balance)
 }
 
+func (cli *CLI) listUnspent(address, nodeID string) {
+	client := NewNodeClient(nodeID)
+	defer client.Close()
+
+	UTXOs, err := client.ListUnspent(address)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	total := 0
+	for _, utxo := range UTXOs {
+		fmt.Printf("%s %d\n", OutPoint{utxo.TxID, utxo.Index}, utxo.Output.Value)
+		total += utxo.Output.Value
+	}
+	fmt.Printf("%d outputs, %d in total\n", len(UTXOs), total)
+}
+
 func (cli *CLI) reindexUTXO(nodeID string) {
 	bc := NewBlockChain(nodeID)
 	UTXOSet := UTXOSet{bc}
@@ -210,27 +818,70 @@ func (cli *CLI) reindexUTXO(nodeID string) {
 	fmt.Printf("Done! There are %d transactions in the UTXO set.\n", count)
 }
 
-func (cli *CLI) send(from, to string, amount int, nodeID string, mineNow bool) {
-	bc := NewBlockChain(nodeID)
-	UTXOSet := UTXOSet{bc}
-	defer bc.db.Close()
+func (cli *CLI) invalidateBlock(hash string, nodeID string) {
+	blockHash, err := hex.DecodeString(hash)
+	if err != nil {
+		log.Panic(err)
+	}
 
+	client := NewNodeClient(nodeID)
+	defer client.Close()
+
+	err = client.InvalidateBlock(blockHash)
+	if err != nil {
+		log.Panic(err)
+	}
+	printBestBlock(client)
+}
+
+func (cli *CLI) reconsiderBlock(hash string, nodeID string) {
+	blockHash, err := hex.DecodeString(hash)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	client := NewNodeClient(nodeID)
+	defer client.Close()
+
+	err = client.ReconsiderBlock(blockHash)
+	if err != nil {
+		log.Panic(err)
+	}
+	printBestBlock(client)
+}
+
+func printBestBlock(client NodeClient) {
+	hash, err := client.GetBestBlockHash()
+	if err != nil {
+		log.Panic(err)
+	}
+	block, err := client.GetBlock(hash)
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Best block is %x at height %d\n", block.Hash, block.Height)
+}
+
+func (cli *CLI) send(req TransactionRequest, lockTime int64, sequence uint32, nodeID string, mineNow bool) {
 	wallets, err := NewWallets(nodeID)
 	if err != nil {
 		log.Panic(err)
 	}
-	wallet := wallets.GetWallet(from)
+	wallet := wallets.GetWallet(req.From)
+
+	client := NewNodeClient(nodeID)
+	defer client.Close()
 
-	tx := NewUTXOTransaction(&wallet, to, amount, &UTXOSet)
-	if mineNow {
-		cbTx := NewCoinbaseTX(from, "")
-		txs := []*Transaction{cbTx, tx}
+	tx, prevTXs, err := client.CreateTransaction(req)
+	if err != nil {
+		log.Panic(err)
+	}
+	tx.SetLocks(lockTime, sequence)
+	tx.Sign(wallet.PrivateKey, prevTXs)
 
-		newBlock := bc.MineBlock(txs)
-		UTXOSet.Update(newBlock)
-	} else {
-		fmt.Printf("send to central node : %s", knownNodes[0])
-		sendTx(knownNodes[0], tx)
+	err = client.SubmitTransaction(tx, mineNow, req.From)
+	if err != nil {
+		log.Panic(err)
 	}
 	fmt.Println("Success!")
 }
@@ -254,14 +905,13 @@ func (cli *CLI) listAddresses(nodeID string) {
 	}
 }
 
-func (cli *CLI) startNode(nodeID, minerAddress string) {
+func (cli *CLI) startNode(nodeID string, opts ServerOptions) {
 	fmt.Printf("Starting node %s\n", nodeID)
-	if len(minerAddress) > 0 {
-		if ValidateAddress(minerAddress) {
-			fmt.Println("Mining is on. Address to receive rewards: ", minerAddress)
-		} else {
-			log.Panic("Wrong miner address!")
-		}
+	if len(opts.MinerAddress) > 0 && !ValidateAddress(opts.MinerAddress) {
+		log.Panic("Wrong miner address!")
+	}
+	if len(opts.PoolRewardAddress) > 0 && !ValidateAddress(opts.PoolRewardAddress) {
+		log.Panic("Wrong pool reward address!")
 	}
-	StartServer(nodeID, minerAddress)
+	StartServer(nodeID, opts)
 }
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// anchorAmount is paid back to the sender by anchoring transactions, which
+// need at least one input to be valid.
+const anchorAmount = 1
+
+func (cli *CLI) anchor(from, path, nodeID string, mineNow bool) {
+	hash := hashFile(path)
+
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallet := wallets.GetWallet(from)
+
+	client := NewNodeClient(nodeID)
+	defer client.Close()
+
+	tx, prevTXs, err := client.CreateTransaction(TransactionRequest{From: from, Payments: []Payment{{from, anchorAmount}}, FeeRate: DefaultFeeRate, Data: hash})
+	if err != nil {
+		log.Panic(err)
+	}
+	tx.Sign(wallet.PrivateKey, prevTXs)
+
+	err = client.SubmitTransaction(tx, mineNow, from)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Anchored %x in transaction %x\n", hash, tx.ID)
+}
+
+func (cli *CLI) verifyAnchor(path, hexHash, nodeID string) {
+	var hash []byte
+	if path != "" {
+		hash = hashFile(path)
+	} else {
+		var err error
+		hash, err = hex.DecodeString(hexHash)
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+
+	client := NewNodeClient(nodeID)
+	defer client.Close()
+
+	block, tx, err := client.FindDataCarrier(hash)
+	if err != nil {
+		fmt.Printf("%x is not anchored\n", hash)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Hash:        %x\n", hash)
+	fmt.Printf("Transaction: %x\n", tx.ID)
+	fmt.Printf("Block:       %x\n", block.Hash)
+	fmt.Printf("Height:      %d\n", block.Height)
+	fmt.Printf("Time:        %s\n", time.Unix(block.Timestamp, 0).Format(time.RFC3339))
+}
+
+func hashFile(path string) []byte {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		log.Panic(err)
+	}
+	hash := sha256.Sum256(content)
+
+	return hash[:]
+}
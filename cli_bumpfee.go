@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+)
+
+func (cli *CLI) bumpFee(hexTxID string, feeRate int, nodeID string, mineNow bool) {
+	txID, err := hex.DecodeString(hexTxID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	client := NewNodeClient(nodeID)
+	defer client.Close()
+
+	tx, err := client.GetTransaction(txID)
+	if err != nil {
+		log.Panic(err)
+	}
+	if tx.IsCoinbase() {
+		log.Panicf("ERROR: Transaction %x is a coinbase", tx.ID)
+	}
+
+	prevTXs := make(map[string]Transaction)
+	for _, vin := range tx.Vin {
+		prevTx, err := client.GetTransaction(vin.Txid)
+		if err != nil {
+			log.Panic(err)
+		}
+		prevTXs[hex.EncodeToString(prevTx.ID)] = *prevTx
+	}
+	from := prevTXs[hex.EncodeToString(tx.Vin[0].Txid)].Vout[tx.Vin[0].Vout].Address()
+
+	// A transaction whose inputs are no longer unspent has been mined and
+	// can't be replaced.
+	UTXOs, err := client.ListUnspent(from)
+	if err != nil {
+		log.Panic(err)
+	}
+	unspent := make(map[string]bool)
+	for _, utxo := range UTXOs {
+		unspent[OutPoint{utxo.TxID, utxo.Index}.String()] = true
+	}
+	for _, vin := range tx.Vin {
+		if !unspent[OutPoint{vin.Txid, vin.Vout}.String()] {
+			log.Panicf("ERROR: Transaction %x is already confirmed", tx.ID)
+		}
+	}
+
+	wallet := cli.walletFor(from, nodeID)
+
+	oldFee := tx.Fee(prevTXs)
+	fee := oldFee + FeeForSize(tx.Size(), DefaultFeeRate)
+	if feeRate > 0 {
+		fee = FeeForSize(tx.Size(), feeRate)
+	}
+
+	bumped, err := NewFeeBumpTransaction(tx, prevTXs, fee, wallet)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = client.SubmitTransaction(bumped, mineNow, from)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Replaced %x, paying %d, with %x, paying %d\n", tx.ID, oldFee, bumped.ID, fee)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	// runminer asks for a new template every templateRefresh, and checks
+	// every tipPollInterval whether its block is stale.
+	templateRefresh = 30 * time.Second
+	tipPollInterval = time.Second
+)
+
+// dialNode connects to the running node, which is the only one that can
+// mine in the background.
+func dialNode(nodeID string) *rpcClient {
+	client, err := DialRPC(nodeID)
+	if err != nil {
+		log.Panicf("ERROR: Node %s is not running: %s", nodeID, err)
+	}
+
+	return client
+}
+
+func (cli *CLI) startMining(address string, policy MiningPolicy, nodeID string) {
+	client := dialNode(nodeID)
+	defer client.Close()
+
+	err := client.StartMining(address, policy)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Println("Mining started")
+}
+
+func (cli *CLI) stopMining(nodeID string) {
+	client := dialNode(nodeID)
+	defer client.Close()
+
+	err := client.StopMining()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Println("Mining stopped")
+}
+
+func (cli *CLI) getMiningInfo(nodeID string) {
+	client := dialNode(nodeID)
+	defer client.Close()
+
+	info, err := client.GetMiningInfo()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Running:          %t\n", info.Running)
+	if info.Address != "" {
+		fmt.Printf("Address:          %s\n", info.Address)
+		fmt.Printf("Min transactions: %d\n", info.Policy.MinTransactions)
+		fmt.Printf("Target interval:  %s\n", info.Policy.TargetInterval)
+		fmt.Printf("Max block size:   %d\n", info.Policy.maxBlockSize())
+	}
+	fmt.Printf("Blocks mined:     %d\n", info.BlocksMined)
+	fmt.Printf("Hash rate:        %.0f hashes/s\n", info.HashRate)
+}
+
+// runMiner mines for the running node from this process. The template is
+// refreshed when the tip moves, or every templateRefresh to pick up new
+// transactions.
+func (cli *CLI) runMiner(address string, workers int, nodeID string) {
+	if !ValidateAddress(address) {
+		log.Panic("ERROR: Address is not valid")
+	}
+
+	client := dialNode(nodeID)
+	defer client.Close()
+
+	m := NewMiner(workers)
+	for {
+		template, err := client.GetBlockTemplate()
+		if err != nil {
+			log.Panic(err)
+		}
+		coinbase := NewCoinbaseTX(address, "", template.Height, template.CoinbaseValue-subsidy)
+		block := template.NewBlock(coinbase)
+
+		ctx, cancel := context.WithTimeout(context.Background(), templateRefresh)
+		go watchTip(ctx, cancel, client, template.PrevBlockHash)
+		err = m.Solve(ctx, block)
+		cancel()
+		if err != nil {
+			fmt.Println("Refreshing the block template")
+			continue
+		}
+
+		err = client.SubmitBlock(block)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		fmt.Printf("Submitted block %x at height %d with %d transactions\n", block.Hash, block.Height, len(block.Transactions))
+	}
+}
+
+// watchTip cancels mining once the node's tip is no longer tip.
+func watchTip(ctx context.Context, cancel context.CancelFunc, client *rpcClient, tip []byte) {
+	ticker := time.NewTicker(tipPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			hash, err := client.GetBestBlockHash()
+			if err != nil || !bytes.Equal(hash, tip) {
+				cancel()
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+)
+
+func (cli *CLI) getPubKey(address, nodeID string) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallet, ok := wallets.Wallets[address]
+	if !ok {
+		log.Panicf("Address %s is not in the wallet", address)
+	}
+
+	fmt.Println(hex.EncodeToString(wallet.PublicKey))
+}
+
+func (cli *CLI) createMultiSig(required int, hexPubKeys []string) {
+	var pubKeys [][]byte
+	for _, hexPubKey := range hexPubKeys {
+		pubKey, err := hex.DecodeString(hexPubKey)
+		if err != nil {
+			log.Panic(err)
+		}
+		pubKeys = append(pubKeys, pubKey)
+	}
+
+	address, err := NewMultiSigAddress(required, pubKeys)
+	if err != nil {
+		log.Panic(err)
+	}
+	redeemScript := MultiSigScript(required, pubKeys)
+
+	fmt.Printf("Your new %d-of-%d multisig address: %s\n", required, len(pubKeys), address)
+
+	scriptHashAddress, err := NewScriptHashAddress(redeemScript)
+	if err != nil {
+		fmt.Printf("No script hash address: %s\n", err)
+		return
+	}
+	fmt.Printf("Script hash address: %s\n", scriptHashAddress)
+	fmt.Printf("Redeem script: %x\n", redeemScript)
+}
+
+func (cli *CLI) createScriptHash(hexScript string) {
+	redeemScript, err := hex.DecodeString(hexScript)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	address, err := NewScriptHashAddress(redeemScript)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Script: %s\n", DisasmScript(redeemScript))
+	fmt.Printf("Script hash address: %s\n", address)
+}
+
+func (cli *CLI) createMultiSigTx(from, to string, amount int, hexRedeemScript, out, nodeID string) {
+	redeemScript, err := hex.DecodeString(hexRedeemScript)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	client := NewNodeClient(nodeID)
+	defer client.Close()
+
+	tx, prevTXs, err := client.CreateTransaction(TransactionRequest{From: from, Payments: []Payment{{to, amount}}, FeeRate: DefaultFeeRate})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	ptx, err := NewPartialTransaction(tx, prevTXs, redeemScript)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = ptx.SaveToFile(out)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Unsigned transaction %x written to %s\n", tx.ID, out)
+}
+
+func (cli *CLI) signMultiSigTx(in, address, nodeID string) {
+	ptx, err := LoadPartialTransaction(in)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallet, ok := wallets.Wallets[address]
+	if !ok {
+		log.Panicf("Address %s is not in the wallet", address)
+	}
+
+	signed := ptx.Sign(wallet)
+	if signed == 0 {
+		log.Panicf("Address %s is not a signer of any input", address)
+	}
+
+	err = ptx.SaveToFile(in)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Signed %d input(s) of %x\n", signed, ptx.Tx.ID)
+}
+
+func (cli *CLI) finalizeMultiSigTx(in, nodeID string, mineNow bool) {
+	ptx, err := LoadPartialTransaction(in)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	tx, err := ptx.Finalize()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	client := NewNodeClient(nodeID)
+	defer client.Close()
+
+	prevTx := ptx.PrevTXs[hex.EncodeToString(tx.Vin[0].Txid)]
+	rewardAddress := prevTx.Vout[tx.Vin[0].Vout].Address()
+
+	err = client.SubmitTransaction(tx, mineNow, rewardAddress)
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Println("Success!")
+}
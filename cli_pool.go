@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// poolMine mines shares for address on the pool at poolAddress until the
+// connection is closed.
+func (cli *CLI) poolMine(poolAddress, address string, workers int) {
+	if !ValidateAddress(address) {
+		log.Panic("ERROR: Address is not valid")
+	}
+
+	conn, err := net.Dial(protocol, poolAddress)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer conn.Close()
+
+	var mu sync.Mutex
+	nextID := 0
+	send := func(method string, params any) {
+		data, err := json.Marshal(params)
+		if err != nil {
+			log.Panic(err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		nextID++
+		err = json.NewEncoder(conn).Encode(poolMessage{ID: nextID, Method: method, Params: data})
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+
+	send(poolMethodSubscribe, poolSubscribeParams{address})
+
+	var extraNonce1 uint32
+	var accepted, rejected atomic.Int64
+	jobs := make(chan PoolJob, 1)
+	go func() {
+		defer close(jobs)
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			var msg poolMessage
+			err := json.Unmarshal(scanner.Bytes(), &msg)
+			if err != nil {
+				log.Panic(err)
+			}
+
+			switch {
+			case msg.Method == poolMethodNotify:
+				var job PoolJob
+				err = json.Unmarshal(msg.Params, &job)
+				if err != nil {
+					log.Panic(err)
+				}
+				jobs <- job
+			case msg.Error != "" && msg.ID == 1:
+				log.Panicf("ERROR: Pool refused the subscription: %s", msg.Error)
+			case msg.ID == 1:
+				var result poolSubscribeResult
+				err = json.Unmarshal(msg.Result, &result)
+				if err != nil {
+					log.Panic(err)
+				}
+				extraNonce1 = result.ExtraNonce1
+			case msg.Error != "":
+				rejected.Add(1)
+				fmt.Printf("Share rejected: %s\n", msg.Error)
+			default:
+				accepted.Add(1)
+			}
+		}
+	}()
+
+	m := NewMiner(workers)
+	var cancel context.CancelFunc
+	var done chan struct{}
+	for job := range jobs {
+		if cancel != nil {
+			cancel()
+			<-done
+		}
+		fmt.Printf("New job %s at height %d; %d shares accepted, %d rejected, %.0f hashes/s\n",
+			job.JobID, job.Height, accepted.Load(), rejected.Load(), m.HashRate())
+
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		done = make(chan struct{})
+		go func(job PoolJob, done chan struct{}) {
+			defer close(done)
+			mineShares(ctx, m, job, extraNonce1, func(extraNonce2 uint32, nonce int) {
+				send(poolMethodSubmit, poolSubmitParams{job.JobID, extraNonce2, nonce})
+			})
+		}(job, done)
+	}
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+
+	fmt.Println("Pool closed the connection")
+}
+
+// mineShares searches job for hashes below its share target until ctx is
+// done, calling submit for each.
+func mineShares(ctx context.Context, m *Miner, job PoolJob, extraNonce1 uint32, submit func(extraNonce2 uint32, nonce int)) {
+	prevBlockHash, err := hex.DecodeString(job.PrevBlockHash)
+	if err != nil {
+		log.Panic(err)
+	}
+	coinbaseData, err := hex.DecodeString(job.Coinbase)
+	if err != nil {
+		log.Panic(err)
+	}
+	coinbase := DeserializeTransaction(coinbaseData)
+	var branch [][]byte
+	for _, h := range job.MerkleBranch {
+		hash, err := hex.DecodeString(h)
+		if err != nil {
+			log.Panic(err)
+		}
+		if len(hash) == 0 {
+			hash = nil
+		}
+		branch = append(branch, hash)
+	}
+	shareTarget, ok := new(big.Int).SetString(job.ShareTarget, 16)
+	if !ok {
+		log.Panicf("ERROR: Invalid share target %s", job.ShareTarget)
+	}
+
+	m.Search(ctx, shareTarget,
+		func(extraNonce uint64) []byte {
+			extraNonce = uint64(extraNonce1)<<32 | uint64(uint32(extraNonce))
+			cb := coinbase.WithExtraNonce(extraNonce)
+			root := MerkleRootFromBranch(cb.HashData(), 0, branch)
+			return blockHeader(prevBlockHash, root, job.Timestamp)
+		},
+		func(extraNonce uint64, nonce int, hash []byte) bool {
+			submit(uint32(extraNonce), nonce)
+			return true
+		})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func (cli *CLI) sendMany(from, path string, feeRate int, nodeID string, mineNow bool) {
+	payments, err := readPayments(path)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallet := wallets.GetWallet(from)
+
+	client := NewNodeClient(nodeID)
+	defer client.Close()
+
+	tx, prevTXs, err := client.CreateTransaction(TransactionRequest{From: from, Payments: payments, FeeRate: feeRate})
+	if err != nil {
+		log.Panic(err)
+	}
+	tx.Sign(wallet.PrivateKey, prevTXs)
+
+	err = client.SubmitTransaction(tx, mineNow, from)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Paid %d addresses with a fee of %d in transaction %x\n", len(payments), tx.Fee(prevTXs), tx.ID)
+}
+
+// readPayments reads the payments of a sendmany file. A JSON file holds an
+// array of {"address": ..., "amount": ...} objects; anything else is read as
+// CSV lines of address,amount, optionally under a header line.
+func readPayments(path string) ([]Payment, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+
+	if bytes.HasPrefix(data, []byte("[")) {
+		var entries []struct {
+			Address string `json:"address"`
+			Amount  int    `json:"amount"`
+		}
+		err := json.Unmarshal(data, &entries)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		payments := make([]Payment, len(entries))
+		for i, entry := range entries {
+			payments[i] = Payment{entry.Address, entry.Amount}
+		}
+		return payments, nil
+	}
+
+	var payments []Payment
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = 2
+	r.TrimLeadingSpace = true
+	for line := 1; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		amount, err := strconv.Atoi(strings.TrimSpace(record[1]))
+		if err != nil {
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("%s:%d: invalid amount %q", path, line, record[1])
+		}
+		payments = append(payments, Payment{strings.TrimSpace(record[0]), amount})
+	}
+
+	return payments, nil
+}
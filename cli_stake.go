@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+)
+
+func (cli *CLI) stake(address string, amount int, nodeID string, mineNow bool) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallet := wallets.GetWallet(address)
+
+	client := NewNodeClient(nodeID)
+	defer client.Close()
+
+	tx, prevTXs, err := client.CreateTransaction(TransactionRequest{From: address, Payments: []Payment{{address, amount}}, FeeRate: DefaultFeeRate})
+	if err != nil {
+		log.Panic(err)
+	}
+	stake, err := NewStakeTXOutput(amount, address)
+	if err != nil {
+		log.Panic(err)
+	}
+	tx.Vout[0] = *stake
+	tx.ID = tx.Hash()
+	tx.Sign(wallet.PrivateKey, prevTXs)
+
+	err = client.SubmitTransaction(tx, mineNow, address)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Staked %d in transaction %x\n", amount, tx.ID)
+}
+
+// unstake moves amount of the bonded stake of address, or all of it when
+// amount is zero, to an unbonding output, paying the fee out of it. The rest
+// stays bonded.
+func (cli *CLI) unstake(address string, amount int, nodeID string, mineNow bool) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallet := wallets.GetWallet(address)
+	pubKeyHash := HashPubKey(wallet.PublicKey)
+
+	client := NewNodeClient(nodeID)
+	defer client.Close()
+
+	bonded, _ := findStakes(client, address)
+	inputs, prevTXs, total := stakeInputs(client, bonded)
+	if total == 0 {
+		fmt.Printf("%s has no bonded stake\n", address)
+		os.Exit(1)
+	}
+	if amount == 0 {
+		amount = total
+	}
+	if amount > total {
+		log.Panicf("ERROR: %s has only %d bonded", address, total)
+	}
+
+	unbondingTx := func(fee int) *Transaction {
+		outputs := []TXOutput{{amount - fee, UnbondingScript(int64(netParams.UnbondingPeriod), pubKeyHash)}}
+		if total > amount {
+			outputs = append(outputs, TXOutput{total - amount, StakeScript(pubKeyHash)})
+		}
+		tx := Transaction{nil, inputs, outputs, 0}
+		tx.ID = tx.Hash()
+		return &tx
+	}
+	fee := FeeForSize(unbondingTx(0).EstimatedSignedSize(), DefaultFeeRate)
+	if amount <= fee {
+		log.Panicf("ERROR: Unbonding %d doesn't cover the fee %d", amount, fee)
+	}
+	tx := unbondingTx(fee)
+	tx.Sign(wallet.PrivateKey, prevTXs)
+
+	err = client.SubmitTransaction(tx, mineNow, address)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Unbonding %d in transaction %x\n", amount-fee, tx.ID)
+	fmt.Printf("It can be withdrawn %d blocks after the transaction is mined\n", netParams.UnbondingPeriod)
+}
+
+// withdrawStake spends the unbonding outputs of address whose unbonding
+// period is over back to address.
+func (cli *CLI) withdrawStake(address string, nodeID string, mineNow bool) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallet := wallets.GetWallet(address)
+
+	client := NewNodeClient(nodeID)
+	defer client.Close()
+
+	_, unbonding := findStakes(client, address)
+	hash, err := client.GetBestBlockHash()
+	if err != nil {
+		log.Panic(err)
+	}
+	tip, err := client.GetBlock(hash)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	var unbonded []Stake
+	for _, stake := range unbonding {
+		if stake.Height+int(stake.Unbonding) <= tip.Height+1 {
+			unbonded = append(unbonded, stake)
+		}
+	}
+	inputs, prevTXs, total := stakeInputs(client, unbonded)
+	if total == 0 {
+		fmt.Printf("%s has no unbonded coins to withdraw yet\n", address)
+		os.Exit(1)
+	}
+
+	tx := Transaction{nil, inputs, []TXOutput{*NewTXOutput(total, address)}, 0}
+	fee := FeeForSize(tx.EstimatedSignedSize(), DefaultFeeRate)
+	if total <= fee {
+		log.Panicf("ERROR: Withdrawing %d doesn't cover the fee %d", total, fee)
+	}
+	tx.Vout[0].Value = total - fee
+	tx.ID = tx.Hash()
+	tx.Sign(wallet.PrivateKey, prevTXs)
+
+	err = client.SubmitTransaction(&tx, mineNow, address)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Withdrew %d in transaction %x\n", total-fee, tx.ID)
+}
+
+func (cli *CLI) validators(nodeID string) {
+	client := NewNodeClient(nodeID)
+	defer client.Close()
+
+	consensus, err := client.GetConsensus()
+	if err != nil {
+		log.Panic(err)
+	}
+	hash, err := client.GetBestBlockHash()
+	if err != nil {
+		log.Panic(err)
+	}
+	stakes, err := client.ListStakes(hash)
+	if err != nil {
+		log.Panic(err)
+	}
+	validators := Validators(stakes)
+
+	total := 0
+	for _, v := range validators {
+		total += v.Stake
+	}
+	for _, v := range validators {
+		share := 0.0
+		if total > 0 {
+			share = 100 * float64(v.Stake) / float64(total)
+		}
+		fmt.Printf("%s stake %d (%.1f%%) unbonding %d\n", v.Address, v.Stake, share, v.Unbonding)
+	}
+	fmt.Printf("%d validators, %d staked in total\n", len(validators), total)
+
+	if consensus.Engine == ConsensusProofOfStake {
+		engine, err := NewConsensusEngine(consensus, nodeID, client, nil)
+		if err != nil {
+			log.Panic(err)
+		}
+		tip, err := client.GetBlock(hash)
+		if err != nil {
+			log.Panic(err)
+		}
+		producer := engine.NextProducer(tip)
+		if producer == "" {
+			producer = "none, no mature stake is bonded"
+		}
+		fmt.Printf("Next producer: %s\n", producer)
+	}
+}
+
+// findStakes returns the bonded and the unbonding outputs of address on the
+// main chain.
+func findStakes(client NodeClient, address string) ([]Stake, []Stake) {
+	hash, err := client.GetBestBlockHash()
+	if err != nil {
+		log.Panic(err)
+	}
+	stakes, err := client.ListStakes(hash)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	var bonded, unbonding []Stake
+	for _, stake := range stakes {
+		switch {
+		case stake.Address != address:
+		case stake.Unbonding == 0:
+			bonded = append(bonded, stake)
+		default:
+			unbonding = append(unbonding, stake)
+		}
+	}
+
+	return bonded, unbonding
+}
+
+// stakeInputs spends stakes in inputs whose sequence satisfies the relative
+// lock of unbonding outputs, and returns them with the transactions they
+// spend and their total value.
+func stakeInputs(client NodeClient, stakes []Stake) ([]TXInput, map[string]Transaction, int) {
+	var inputs []TXInput
+	prevTXs := make(map[string]Transaction)
+	total := 0
+
+	for _, stake := range stakes {
+		sequence := uint32(SequenceFinal)
+		if stake.Unbonding > 0 {
+			sequence = uint32(stake.Unbonding)
+		}
+		inputs = append(inputs, TXInput{stake.TxID, stake.Index, nil, sequence})
+		total += stake.Output.Value
+
+		prevTx, err := client.GetTransaction(stake.TxID)
+		if err != nil {
+			log.Panic(err)
+		}
+		prevTXs[hex.EncodeToString(prevTx.ID)] = *prevTx
+	}
+
+	return inputs, prevTXs, total
+}
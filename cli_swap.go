@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	initiatorLockDuration   = 48 * time.Hour
+	participantLockDuration = 24 * time.Hour
+)
+
+func (cli *CLI) initiateSwap(from, to string, amount int, lockTime int64, nodeID string, mineNow bool) {
+	if lockTime == 0 {
+		lockTime = time.Now().Add(initiatorLockDuration).Unix()
+	}
+	secret, secretHash := NewSwapSecret()
+
+	fmt.Printf("Secret:      %x\n", secret)
+	fmt.Printf("Secret hash: %x\n", secretHash)
+	cli.fundContract(from, to, amount, secretHash, lockTime, nodeID, mineNow)
+}
+
+func (cli *CLI) participateSwap(from, to string, amount int, hexSecretHash string, lockTime int64, nodeID string, mineNow bool) {
+	secretHash, err := hex.DecodeString(hexSecretHash)
+	if err != nil {
+		log.Panic(err)
+	}
+	if lockTime == 0 {
+		lockTime = time.Now().Add(participantLockDuration).Unix()
+	}
+
+	cli.fundContract(from, to, amount, secretHash, lockTime, nodeID, mineNow)
+}
+
+// fundContract pays amount from the from address into a contract that to can
+// redeem with the secret and from can refund after lockTime.
+func (cli *CLI) fundContract(from, to string, amount int, secretHash []byte, lockTime int64, nodeID string, mineNow bool) {
+	recipientHash, err := DecodePubKeyHashAddress(to)
+	if err != nil {
+		log.Panic(err)
+	}
+	refundHash, err := DecodePubKeyHashAddress(from)
+	if err != nil {
+		log.Panic(err)
+	}
+	htlc := &HTLC{secretHash, recipientHash, refundHash, lockTime}
+	contract := htlc.Script()
+
+	contractAddress, err := NewScriptHashAddress(contract)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallet := wallets.GetWallet(from)
+
+	client := NewNodeClient(nodeID)
+	defer client.Close()
+
+	tx, prevTXs, err := client.CreateTransaction(TransactionRequest{From: from, Payments: []Payment{{contractAddress, amount}}, FeeRate: DefaultFeeRate})
+	if err != nil {
+		log.Panic(err)
+	}
+	tx.Sign(wallet.PrivateKey, prevTXs)
+
+	err = client.SubmitTransaction(tx, mineNow, from)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Contract address: %s\n", contractAddress)
+	fmt.Printf("Contract:         %x\n", contract)
+	fmt.Printf("Contract transaction: %x\n", tx.ID)
+	fmt.Printf("Refundable after: %s\n", formatLockTime(lockTime))
+}
+
+func (cli *CLI) redeemSwap(hexContract, hexContractTx, hexSecret, nodeID string, mineNow bool) {
+	contract, contractTx, htlc := cli.loadContract(hexContract, hexContractTx, nodeID)
+	secret, err := hex.DecodeString(hexSecret)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	address := string(AddressFromPubKeyHash(htlc.RecipientHash))
+	wallet := cli.walletFor(address, nodeID)
+
+	tx, err := NewHTLCRedeemTransaction(contractTx, contract, secret, wallet)
+	if err != nil {
+		log.Panic(err)
+	}
+	cli.submitContractSpend(tx, address, nodeID, mineNow)
+
+	fmt.Printf("Redeem transaction: %x\n", tx.ID)
+}
+
+func (cli *CLI) refundSwap(hexContract, hexContractTx, nodeID string, mineNow bool) {
+	contract, contractTx, htlc := cli.loadContract(hexContract, hexContractTx, nodeID)
+
+	address := string(AddressFromPubKeyHash(htlc.RefundHash))
+	wallet := cli.walletFor(address, nodeID)
+
+	tx, err := NewHTLCRefundTransaction(contractTx, contract, wallet)
+	if err != nil {
+		log.Panic(err)
+	}
+	cli.submitContractSpend(tx, address, nodeID, mineNow)
+
+	fmt.Printf("Refund transaction: %x\n", tx.ID)
+}
+
+func (cli *CLI) auditContract(hexContract, hexContractTx, nodeID string) {
+	contract, contractTx, htlc := cli.loadContract(hexContract, hexContractTx, nodeID)
+
+	vout, err := FindContractOutput(contractTx, contract)
+	if err != nil {
+		log.Panic(err)
+	}
+	contractAddress, err := NewScriptHashAddress(contract)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Contract address:  %s\n", contractAddress)
+	fmt.Printf("Contract value:    %d\n", contractTx.Vout[vout].Value)
+	fmt.Printf("Recipient address: %s\n", AddressFromPubKeyHash(htlc.RecipientHash))
+	fmt.Printf("Refund address:    %s\n", AddressFromPubKeyHash(htlc.RefundHash))
+	fmt.Printf("Secret hash:       %x\n", htlc.SecretHash)
+	fmt.Printf("Refundable after:  %s\n", formatLockTime(htlc.LockTime))
+}
+
+func (cli *CLI) extractSecret(hexRedeemTx, hexSecretHash, nodeID string) {
+	txID, err := hex.DecodeString(hexRedeemTx)
+	if err != nil {
+		log.Panic(err)
+	}
+	secretHash, err := hex.DecodeString(hexSecretHash)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	client := NewNodeClient(nodeID)
+	defer client.Close()
+
+	tx, err := client.GetTransaction(txID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	secret, err := ExtractSwapSecret(tx, secretHash)
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Secret: %x\n", secret)
+}
+
+func (cli *CLI) loadContract(hexContract, hexContractTx, nodeID string) ([]byte, *Transaction, *HTLC) {
+	contract, err := hex.DecodeString(hexContract)
+	if err != nil {
+		log.Panic(err)
+	}
+	htlc, ok := ExtractHTLC(contract)
+	if !ok {
+		log.Panicf("%s is not a swap contract", hexContract)
+	}
+	txID, err := hex.DecodeString(hexContractTx)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	client := NewNodeClient(nodeID)
+	defer client.Close()
+
+	contractTx, err := client.GetTransaction(txID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return contract, contractTx, htlc
+}
+
+func (cli *CLI) walletFor(address, nodeID string) *Wallet {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallet, ok := wallets.Wallets[address]
+	if !ok {
+		log.Panicf("Address %s is not in the wallet", address)
+	}
+
+	return wallet
+}
+
+func (cli *CLI) submitContractSpend(tx *Transaction, rewardAddress, nodeID string, mineNow bool) {
+	client := NewNodeClient(nodeID)
+	defer client.Close()
+
+	err := client.SubmitTransaction(tx, mineNow, rewardAddress)
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+func formatLockTime(lockTime int64) string {
+	if lockTime < lockTimeThreshold {
+		return fmt.Sprintf("block %d", lockTime)
+	}
+
+	return time.Unix(lockTime, 0).Format(time.RFC3339)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+)
+
+// NodeClient is what the CLI needs from a node. While `startnode` is running
+// it holds the database open, so commands are served over RPC; otherwise the
+// database is opened directly, failing if another process still holds it.
+type NodeClient interface {
+	GetBalance(address string) (int, error)
+	GetBestBlockHash() ([]byte, error)
+	GetBlock(hash []byte) (*Block, error)
+	GetTransaction(id []byte) (*Transaction, error)
+	FindDataCarrier(data []byte) (*Block, *Transaction, error)
+	ListUnspent(address string) ([]UTXO, error)
+	ListStakes(blockHash []byte) ([]Stake, error)
+	GetConsensus() (ConsensusConfig, error)
+	InvalidateBlock(hash []byte) error
+	ReconsiderBlock(hash []byte) error
+	CreateTransaction(req TransactionRequest) (*Transaction, map[string]Transaction, error)
+	SubmitTransaction(tx *Transaction, mine bool, rewardAddress string) error
+	Close() error
+}
+
+func NewNodeClient(nodeID string) NodeClient {
+	client, err := DialRPC(nodeID)
+	if err == nil {
+		return client
+	}
+
+	return &localClient{NewBlockChain(nodeID)}
+}
+
+type localClient struct {
+	bc *BlockChain
+}
+
+func (c *localClient) GetBalance(address string) (int, error) {
+	if !ValidateAddress(address) {
+		return 0, fmt.Errorf("invalid address %s", address)
+	}
+
+	UTXOSet := UTXOSet{c.bc}
+	balance := 0
+	UTXOs := UTXOSet.FindUTXO(address)
+
+	for _, out := range UTXOs {
+		balance += out.Value
+	}
+
+	return balance, nil
+}
+
+func (c *localClient) GetBestBlockHash() ([]byte, error) {
+	return c.bc.Tip(), nil
+}
+
+func (c *localClient) GetBlock(hash []byte) (*Block, error) {
+	block, err := c.bc.GetBlock(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &block, nil
+}
+
+func (c *localClient) GetTransaction(id []byte) (*Transaction, error) {
+	tx, err := c.bc.FindTransaction(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tx, nil
+}
+
+func (c *localClient) FindDataCarrier(data []byte) (*Block, *Transaction, error) {
+	return c.bc.FindDataCarrier(data)
+}
+
+func (c *localClient) ListUnspent(address string) ([]UTXO, error) {
+	if !ValidateAddress(address) {
+		return nil, fmt.Errorf("invalid address %s", address)
+	}
+
+	UTXOSet := UTXOSet{c.bc}
+	return UTXOSet.FindUnspentOutputs(address), nil
+}
+
+func (c *localClient) ListStakes(blockHash []byte) ([]Stake, error) {
+	return c.bc.FindStakes(blockHash)
+}
+
+func (c *localClient) GetConsensus() (ConsensusConfig, error) {
+	return readConsensusConfig(c.bc.db)
+}
+
+func (c *localClient) InvalidateBlock(hash []byte) error {
+	return c.bc.InvalidateBlock(hash)
+}
+
+func (c *localClient) ReconsiderBlock(hash []byte) error {
+	return c.bc.ReconsiderBlock(hash)
+}
+
+func (c *localClient) CreateTransaction(req TransactionRequest) (*Transaction, map[string]Transaction, error) {
+	addresses := []string{req.From}
+	for _, payment := range req.Payments {
+		addresses = append(addresses, payment.Address)
+	}
+	for _, address := range addresses {
+		if !ValidateAddress(address) {
+			return nil, nil, fmt.Errorf("invalid address %s", address)
+		}
+	}
+
+	// Outputs already spent by mempool transactions are left out. Unconfirmed
+	// outputs are only spent when chosen as inputs, as when a child pays for
+	// its parent.
+	UTXOSet := UTXOSet{c.bc}
+	var UTXOs []UTXO
+	for _, utxo := range UTXOSet.FindUnspentOutputs(req.From) {
+		if !mempool.IsSpent(utxo.TxID, utxo.Index) {
+			UTXOs = append(UTXOs, utxo)
+		}
+	}
+	if len(req.Inputs) > 0 {
+		UTXOs = append(UTXOs, mempool.UnspentOutputs(req.From)...)
+	}
+
+	tx, err := NewUnsignedUTXOTransaction(req, UTXOs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	prevTXs := make(map[string]Transaction)
+	for _, vin := range tx.Vin {
+		prevTx, ok := mempool.Get(vin.Txid)
+		if !ok {
+			prevTx, err = c.bc.FindTransaction(vin.Txid)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		prevTXs[hex.EncodeToString(prevTx.ID)] = prevTx
+	}
+
+	return tx, prevTXs, nil
+}
+
+func (c *localClient) SubmitTransaction(tx *Transaction, mine bool, rewardAddress string) error {
+	if !mine {
+		fmt.Printf("send to central node : %s\n", knownNodes[0])
+		sendTx(knownNodes[0], tx)
+		return nil
+	}
+
+	fee, err := c.bc.ValidateTransaction(tx, c.bc.GetBestHeight()+1, timeSource.AdjustedTime(), nil)
+	if err != nil {
+		return fmt.Errorf("transaction %x is invalid: %w", tx.ID, err)
+	}
+
+	cbTx := NewCoinbaseTX(rewardAddress, "", c.bc.GetBestHeight()+1, fee)
+	txs := []*Transaction{cbTx, tx}
+
+	_, err = c.bc.MineBlock(context.Background(), txs)
+
+	return err
+}
+
+func (c *localClient) Close() error {
+	return c.bc.db.Close()
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Names of the coin selection strategies a TransactionRequest can ask for.
+const (
+	CoinSelectLargestFirst  = "largest"
+	CoinSelectSmallestFirst = "smallest"
+	CoinSelectBranchBound   = "bnb"
+	CoinSelectRandom        = "random"
+
+	// maxBranchBoundTries bounds the search for an exact match.
+	maxBranchBoundTries = 100000
+)
+
+// CoinSelector picks which unspent outputs of an address pay for a
+// transaction. target includes the fee.
+type CoinSelector interface {
+	Select(utxos []UTXO, target int) ([]UTXO, error)
+}
+
+// OutPoint names a transaction output.
+type OutPoint struct {
+	Txid []byte
+	Vout int
+}
+
+func (o OutPoint) String() string {
+	return fmt.Sprintf("%x:%d", o.Txid, o.Vout)
+}
+
+// ParseOutPoint parses an output written as TXID:VOUT.
+func ParseOutPoint(s string) (OutPoint, error) {
+	txid, vout, ok := strings.Cut(s, ":")
+	if !ok {
+		return OutPoint{}, fmt.Errorf("invalid output %q, want TXID:VOUT", s)
+	}
+	id, err := hex.DecodeString(txid)
+	if err != nil {
+		return OutPoint{}, fmt.Errorf("invalid output %q: %w", s, err)
+	}
+	index, err := strconv.Atoi(vout)
+	if err != nil || index < 0 {
+		return OutPoint{}, fmt.Errorf("invalid output index in %q", s)
+	}
+
+	return OutPoint{id, index}, nil
+}
+
+// NewCoinSelector returns the selector for the strategy named by req. Inputs
+// chosen by the user take precedence over any strategy.
+func NewCoinSelector(req TransactionRequest) (CoinSelector, error) {
+	if len(req.Inputs) > 0 {
+		return manualSelector{req.Inputs}, nil
+	}
+
+	switch req.CoinSelection {
+	case "", CoinSelectLargestFirst:
+		return largestFirstSelector{}, nil
+	case CoinSelectSmallestFirst:
+		return smallestFirstSelector{}, nil
+	case CoinSelectBranchBound:
+		return branchBoundSelector{}, nil
+	case CoinSelectRandom:
+		return randomSelector{}, nil
+	default:
+		return nil, fmt.Errorf("unknown coin selection strategy %q", req.CoinSelection)
+	}
+}
+
+// largestFirstSelector spends the fewest outputs, keeping transactions small.
+type largestFirstSelector struct{}
+
+func (largestFirstSelector) Select(utxos []UTXO, target int) ([]UTXO, error) {
+	return accumulate(sortedByValue(utxos, true), target)
+}
+
+// smallestFirstSelector consolidates dust at the cost of larger transactions.
+type smallestFirstSelector struct{}
+
+func (smallestFirstSelector) Select(utxos []UTXO, target int) ([]UTXO, error) {
+	return accumulate(sortedByValue(utxos, false), target)
+}
+
+// randomSelector spends outputs in random order, so that the choice reveals
+// less about the wallet.
+type randomSelector struct{}
+
+func (randomSelector) Select(utxos []UTXO, target int) ([]UTXO, error) {
+	shuffled := make([]UTXO, len(utxos))
+	copy(shuffled, utxos)
+	rand.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+
+	return accumulate(shuffled, target)
+}
+
+// branchBoundSelector searches depth first for outputs adding up to exactly
+// the target, so that the transaction needs no change output. When there is
+// no such set it falls back to largest first.
+type branchBoundSelector struct{}
+
+func (branchBoundSelector) Select(utxos []UTXO, target int) ([]UTXO, error) {
+	sorted := sortedByValue(utxos, true)
+
+	// remaining[i] is the value of sorted[i:], to prune branches that can't
+	// reach the target.
+	remaining := make([]int, len(sorted)+1)
+	for i := len(sorted) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + sorted[i].Output.Value
+	}
+
+	var selected []UTXO
+	tries := 0
+	var search func(i, sum int) bool
+	search = func(i, sum int) bool {
+		tries++
+		if sum == target {
+			return true
+		}
+		if i == len(sorted) || sum > target || sum+remaining[i] < target || tries > maxBranchBoundTries {
+			return false
+		}
+
+		selected = append(selected, sorted[i])
+		if search(i+1, sum+sorted[i].Output.Value) {
+			return true
+		}
+		selected = selected[:len(selected)-1]
+
+		return search(i+1, sum)
+	}
+
+	if search(0, 0) {
+		return selected, nil
+	}
+
+	return largestFirstSelector{}.Select(utxos, target)
+}
+
+// manualSelector spends exactly the outputs the user chose.
+type manualSelector struct {
+	inputs []OutPoint
+}
+
+func (s manualSelector) Select(utxos []UTXO, target int) ([]UTXO, error) {
+	available := make(map[string]UTXO)
+	for _, utxo := range utxos {
+		available[OutPoint{utxo.TxID, utxo.Index}.String()] = utxo
+	}
+
+	var selected []UTXO
+	total := 0
+	for _, input := range s.inputs {
+		utxo, ok := available[input.String()]
+		if !ok {
+			return nil, fmt.Errorf("ERROR: %s is not an unspent output of the sending address", input)
+		}
+		delete(available, input.String())
+		selected = append(selected, utxo)
+		total += utxo.Output.Value
+	}
+
+	if total < target {
+		return nil, fmt.Errorf("ERROR: Not enough funds in the chosen inputs: have %d, need %d", total, target)
+	}
+
+	return selected, nil
+}
+
+// accumulate takes outputs in order until they cover target.
+func accumulate(utxos []UTXO, target int) ([]UTXO, error) {
+	var selected []UTXO
+	total := 0
+	for _, utxo := range utxos {
+		if total >= target {
+			break
+		}
+		selected = append(selected, utxo)
+		total += utxo.Output.Value
+	}
+
+	if total < target {
+		return nil, fmt.Errorf("ERROR: Not enough funds: have %d, need %d", total, target)
+	}
+
+	return selected, nil
+}
+
+func sortedByValue(utxos []UTXO, descending bool) []UTXO {
+	sorted := make([]UTXO, len(utxos))
+	copy(sorted, utxos)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if descending {
+			return sorted[i].Output.Value > sorted[j].Output.Value
+		}
+		return sorted[i].Output.Value < sorted[j].Output.Value
+	})
+
+	return sorted
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCoinSelectors(t *testing.T) {
+	var utxos []UTXO
+	for i, value := range []int{5, 1, 20, 2, 10} {
+		utxos = append(utxos, UTXO{[]byte{byte(i)}, i, TXOutput{Value: value}})
+	}
+	outPoint := func(i int) OutPoint { return OutPoint{utxos[i].TxID, utxos[i].Index} }
+
+	tests := []struct {
+		name     string
+		strategy string
+		inputs   []OutPoint
+		target   int
+		want     []int
+		errMsg   string
+	}{
+		{"largest first by default", "", nil, 12, []int{20}, ""},
+		{"largest first", CoinSelectLargestFirst, nil, 25, []int{20, 10}, ""},
+		{"largest first short of funds", CoinSelectLargestFirst, nil, 39, nil, "have 38, need 39"},
+		{"smallest first", CoinSelectSmallestFirst, nil, 7, []int{1, 2, 5}, ""},
+		{"smallest first overshooting", CoinSelectSmallestFirst, nil, 4, []int{1, 2, 5}, ""},
+		{"smallest first short of funds", CoinSelectSmallestFirst, nil, 39, nil, "have 38, need 39"},
+		{"branch and bound exact match", CoinSelectBranchBound, nil, 17, []int{10, 5, 2}, ""},
+		{"branch and bound exact match with the smallest", CoinSelectBranchBound, nil, 36, []int{20, 10, 5, 1}, ""},
+		{"branch and bound falls back to largest first", CoinSelectBranchBound, nil, 4, []int{20}, ""},
+		{"branch and bound short of funds", CoinSelectBranchBound, nil, 39, nil, "have 38, need 39"},
+		{"manual inputs in the order given", CoinSelectLargestFirst, []OutPoint{outPoint(0), outPoint(1)}, 6, []int{5, 1}, ""},
+		{"manual inputs short of funds", "", []OutPoint{outPoint(0), outPoint(1)}, 7, nil, "have 6, need 7"},
+		{"manual input not owned", "", []OutPoint{{[]byte{0xff}, 0}}, 1, nil, "is not an unspent output"},
+		{"manual input given twice", "", []OutPoint{outPoint(2), outPoint(2)}, 1, nil, "is not an unspent output"},
+		{"unknown strategy", "oldest", nil, 1, nil, "unknown coin selection strategy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector, err := NewCoinSelector(TransactionRequest{CoinSelection: tt.strategy, Inputs: tt.inputs})
+			if err == nil {
+				var selected []UTXO
+				selected, err = selector.Select(utxos, tt.target)
+				var values []int
+				for _, utxo := range selected {
+					values = append(values, utxo.Output.Value)
+				}
+				if !reflect.DeepEqual(values, tt.want) {
+					t.Errorf("selected %v, want %v", values, tt.want)
+				}
+			}
+			checkError(t, err, tt.errMsg)
+		})
+	}
+
+	t.Run("random", func(t *testing.T) {
+		for target := 1; target <= 38; target++ {
+			selected, err := randomSelector{}.Select(utxos, target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			total := 0
+			for _, utxo := range selected {
+				total += utxo.Output.Value
+			}
+			if total < target || total-selected[len(selected)-1].Output.Value >= target {
+				t.Errorf("selected %d for %d, want the last output needed", total, target)
+			}
+		}
+		_, err := randomSelector{}.Select(utxos, 39)
+		checkError(t, err, "have 38, need 39")
+	})
+}
+
+func TestParseOutPoint(t *testing.T) {
+	tests := []struct {
+		in     string
+		want   string
+		errMsg string
+	}{
+		{"00ff:1", "00ff:1", ""},
+		{"00FF:0", "00ff:0", ""},
+		{"00ff", "", "want TXID:VOUT"},
+		{"zz:1", "", "invalid output"},
+		{"00ff:-1", "", "invalid output index"},
+		{"00ff:x", "", "invalid output index"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			outPoint, err := ParseOutPoint(tt.in)
+			checkError(t, err, tt.errMsg)
+			if err == nil && outPoint.String() != tt.want {
+				t.Errorf("ParseOutPoint(%q) = %s, want %s", tt.in, outPoint, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Names of the consensus engines a chain can be created with.
+const (
+	ConsensusProofOfWork      = "pow"
+	ConsensusProofOfAuthority = "poa"
+	ConsensusProofOfStake     = "pos"
+
+	consensusBucket = "consensus"
+	consensusKey    = "config"
+)
+
+// ErrNotProducer is returned by Seal when this node may not produce the block.
+var ErrNotProducer = errors.New("this node may not produce the next block")
+
+// ConsensusEngine decides who produces blocks and proves that they did.
+type ConsensusEngine interface {
+	Name() string
+	// NextProducer returns the address that may produce the block after
+	// parent, or "" when anyone may.
+	NextProducer(parent *Block) string
+	// Seal sets the Hash of block, which extends parent, and whatever shows
+	// it was produced by the rules. parent is nil for the genesis block. It
+	// returns ErrNotProducer when this node may not produce block, and
+	// ctx.Err() when ctx is done first.
+	Seal(ctx context.Context, parent, block *Block) error
+	// VerifySeal checks what Seal set.
+	VerifySeal(parent, block *Block) error
+}
+
+// ConsensusConfig is stored with a chain when it is created and picks the
+// engine of every node that opens it.
+type ConsensusConfig struct {
+	Engine string
+	// Signers configures proof of authority, and Period both it and proof
+	// of stake.
+	Signers []string `json:",omitempty"`
+	Period  int64    `json:",omitempty"`
+}
+
+// NewConsensusEngine returns the engine config picks. Proof of authority
+// reads blocks from chain, and proof of stake its stakes and blocks too and,
+// unless db is nil, records there what this node signs.
+func NewConsensusEngine(config ConsensusConfig, nodeID string, chain stakeChain, db *bolt.DB) (ConsensusEngine, error) {
+	switch config.Engine {
+	case "", ConsensusProofOfWork:
+		return ProofOfWorkEngine{}, nil
+	case ConsensusProofOfAuthority:
+		return NewProofOfAuthority(config.Signers, config.Period, nodeID, chain)
+	case ConsensusProofOfStake:
+		return NewProofOfStake(config.Period, nodeID, chain, db)
+	default:
+		return nil, fmt.Errorf("unknown consensus engine %q", config.Engine)
+	}
+}
+
+// readConsensusConfig returns the configuration stored in db. Chains created
+// before it was stored use proof of work.
+func readConsensusConfig(db *bolt.DB) (ConsensusConfig, error) {
+	var config ConsensusConfig
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(consensusBucket))
+		if b == nil {
+			return nil
+		}
+		return json.Unmarshal(b.Get([]byte(consensusKey)), &config)
+	})
+
+	return config, err
+}
+
+func writeConsensusConfig(tx *bolt.Tx, config ConsensusConfig) error {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	b, err := tx.CreateBucketIfNotExists([]byte(consensusBucket))
+	if err != nil {
+		return err
+	}
+
+	return b.Put([]byte(consensusKey), data)
+}
+
+// VerifySeal checks the seal of block against its parent in bc.
+func (bc *BlockChain) VerifySeal(block *Block) error {
+	if len(block.PrevBlockHash) == 0 {
+		return bc.engine.VerifySeal(nil, block)
+	}
+
+	parent, err := bc.GetBlock(block.PrevBlockHash)
+	if err != nil {
+		return fmt.Errorf("parent block %x is unknown", block.PrevBlockHash)
+	}
+
+	return bc.engine.VerifySeal(&parent, block)
+}
+
+// signSeal signs block with the key of producer, if the wallets of nodeID
+// hold it, once period seconds have passed since parent.
+func signSeal(ctx context.Context, nodeID, producer string, period int64, parent, block *Block) error {
+	wallets, err := NewWallets(nodeID)
+	if err != nil || wallets.Wallets[producer] == nil {
+		return fmt.Errorf("%w: it is the turn of %s", ErrNotProducer, producer)
+	}
+	wallet := wallets.GetWallet(producer)
+
+	if earliest := parent.Timestamp + period; block.Timestamp < earliest {
+		block.Timestamp = earliest
+	}
+	timer := time.NewTimer(time.Until(time.Unix(block.Timestamp, 0)))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+	}
+
+	block.Hash = headerHash(block)
+	block.PubKey = wallet.PublicKey
+	block.Signature = SignHash(wallet.PrivateKey, block.Hash)
+	fmt.Printf("Signed block %x as %s\n", block.Hash, producer)
+
+	return nil
+}
+
+// verifySignedSeal checks what signSeal set.
+func verifySignedSeal(producer string, period int64, parent, block *Block) error {
+	err := verifyHeaderHash(block)
+	if err != nil {
+		return err
+	}
+	if signer := string(AddressFromPubKeyHash(HashPubKey(block.PubKey))); signer != producer {
+		return fmt.Errorf("signed by %s instead of %s", signer, producer)
+	}
+	if !VerifySignature(block.PubKey, block.Hash, block.Signature) {
+		return errors.New("signature is invalid")
+	}
+	if block.Timestamp < parent.Timestamp+period {
+		return fmt.Errorf("produced less than %d seconds after its parent", period)
+	}
+
+	return nil
+}
+
+func verifyHeaderHash(block *Block) error {
+	if !bytes.Equal(headerHash(block), block.Hash) {
+		return errors.New("hash doesn't match the header")
+	}
+
+	return nil
+}
+
+// headerHash is the hash of the block header, including the nonce.
+func headerHash(block *Block) []byte {
+	hash := sha256.Sum256(NewProofOfWork(block).prepareData(block.Nonce))
+
+	return hash[:]
+}
+
+// ProofOfWorkEngine lets anyone produce a block by finding a header hash below
+// the target.
+type ProofOfWorkEngine struct{}
+
+func (ProofOfWorkEngine) Name() string {
+	return "proof of work"
+}
+
+func (ProofOfWorkEngine) NextProducer(parent *Block) string {
+	return ""
+}
+
+func (ProofOfWorkEngine) Seal(ctx context.Context, parent, block *Block) error {
+	return miner.Solve(ctx, block)
+}
+
+func (ProofOfWorkEngine) VerifySeal(parent, block *Block) error {
+	if !NewProofOfWork(block).Validate() {
+		return errors.New("proof of work is invalid")
+	}
+
+	return nil
+}
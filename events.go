@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/hex"
+	"sync"
+)
+
+const (
+	EventBlockConnected    = "block_connected"
+	EventBlockDisconnected = "block_disconnected"
+	EventTxAccepted        = "tx_accepted"
+	EventTxRemoved         = "tx_removed"
+	EventAddressReceived   = "address_received"
+
+	eventBufferSize = 64
+)
+
+type Event struct {
+	Type      string `json:"type"`
+	BlockHash string `json:"block_hash,omitempty"`
+	Height    int    `json:"height,omitempty"`
+	TxID      string `json:"txid,omitempty"`
+	Address   string `json:"address,omitempty"`
+	Value     int    `json:"value,omitempty"`
+	Confirmed bool   `json:"confirmed,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// EventBus fans chain and mempool events out to subscribers. Publishing never
+// blocks: a subscriber that falls behind misses events instead of stalling
+// block connection.
+type EventBus struct {
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+var notifier = NewEventBus()
+
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[chan Event]struct{})}
+}
+
+func (eb *EventBus) Subscribe() chan Event {
+	ch := make(chan Event, eventBufferSize)
+
+	eb.mu.Lock()
+	eb.subscribers[ch] = struct{}{}
+	eb.mu.Unlock()
+
+	return ch
+}
+
+func (eb *EventBus) Unsubscribe(ch chan Event) {
+	eb.mu.Lock()
+	delete(eb.subscribers, ch)
+	eb.mu.Unlock()
+}
+
+func (eb *EventBus) Publish(e Event) {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+
+	for ch := range eb.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+func (eb *EventBus) PublishBlockConnected(b *Block) {
+	blockHash := hex.EncodeToString(b.Hash)
+	eb.Publish(Event{Type: EventBlockConnected, BlockHash: blockHash, Height: b.Height})
+
+	for _, tx := range b.Transactions {
+		eb.publishReceived(tx, true)
+	}
+}
+
+func (eb *EventBus) PublishBlockDisconnected(b *Block) {
+	eb.Publish(Event{Type: EventBlockDisconnected, BlockHash: hex.EncodeToString(b.Hash), Height: b.Height})
+}
+
+func (eb *EventBus) PublishTxAccepted(tx *Transaction) {
+	eb.Publish(Event{Type: EventTxAccepted, TxID: hex.EncodeToString(tx.ID)})
+	eb.publishReceived(tx, false)
+}
+
+func (eb *EventBus) PublishTxRemoved(tx *Transaction, reason string) {
+	eb.Publish(Event{Type: EventTxRemoved, TxID: hex.EncodeToString(tx.ID), Reason: reason})
+}
+
+func (eb *EventBus) publishReceived(tx *Transaction, confirmed bool) {
+	txID := hex.EncodeToString(tx.ID)
+
+	for _, out := range tx.Vout {
+		address := out.Address()
+		if address == "" {
+			continue
+		}
+
+		eb.Publish(Event{
+			Type:      EventAddressReceived,
+			TxID:      txID,
+			Address:   address,
+			Value:     out.Value,
+			Confirmed: confirmed,
+		})
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+)
+
+const explorerRecentBlocks = 20
+
+type explorer struct {
+	bc        *BlockChain
+	templates *template.Template
+}
+
+type explorerTx struct {
+	Tx    *Transaction
+	Block *Block
+}
+
+type explorerOutput struct {
+	Index   int
+	Value   int
+	Address string
+	Script  string
+	Spent   bool
+}
+
+func StartExplorer(address string, bc *BlockChain) {
+	funcs := template.FuncMap{
+		"hex": func(data []byte) string {
+			return hex.EncodeToString(data)
+		},
+		"disasm": DisasmScript,
+		"time": func(timestamp int64) string {
+			return time.Unix(timestamp, 0).UTC().Format(time.RFC3339)
+		},
+		"sealValid": func(b *Block) bool {
+			return bc.VerifySeal(b) == nil
+		},
+	}
+	e := &explorer{bc, template.Must(template.New("explorer").Funcs(funcs).Parse(explorerTemplates))}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", e.handleIndex)
+	mux.HandleFunc("GET /block/{hash}", e.handleBlock)
+	mux.HandleFunc("GET /tx/{id}", e.handleTransaction)
+	mux.HandleFunc("GET /address/{addr}", e.handleAddress)
+	mux.HandleFunc("GET /mempool", e.handleMempool)
+	mux.HandleFunc("GET /search", e.handleSearch)
+
+	fmt.Printf("Block explorer listening on http://%s/\n", address)
+	go func() {
+		err := http.ListenAndServe(address, mux)
+		if err != nil {
+			log.Panic(err)
+		}
+	}()
+}
+
+func (e *explorer) render(w http.ResponseWriter, name string, data any) {
+	var buf bytes.Buffer
+
+	err := e.templates.ExecuteTemplate(&buf, name, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, err = buf.WriteTo(w)
+	if err != nil {
+		log.Println("Failed to write page: ", err)
+	}
+}
+
+func (e *explorer) notFound(w http.ResponseWriter, what string) {
+	w.WriteHeader(http.StatusNotFound)
+	e.render(w, "notfound", what)
+}
+
+func (e *explorer) handleIndex(w http.ResponseWriter, r *http.Request) {
+	var blocks []*Block
+	bci := e.bc.Iterator()
+
+	for len(blocks) < explorerRecentBlocks {
+		block := bci.Next()
+		blocks = append(blocks, block)
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	e.render(w, "index", map[string]any{
+		"Blocks":      blocks,
+		"MempoolSize": mempool.Len(),
+	})
+}
+
+func (e *explorer) handleBlock(w http.ResponseWriter, r *http.Request) {
+	hash, err := hex.DecodeString(r.PathValue("hash"))
+	if err != nil {
+		e.notFound(w, "block")
+		return
+	}
+
+	block, err := e.bc.GetBlock(hash)
+	if err != nil {
+		e.notFound(w, "block")
+		return
+	}
+
+	e.render(w, "block", &block)
+}
+
+func (e *explorer) handleTransaction(w http.ResponseWriter, r *http.Request) {
+	id, err := hex.DecodeString(r.PathValue("id"))
+	if err != nil {
+		e.notFound(w, "transaction")
+		return
+	}
+
+	var tx *Transaction
+	var block *Block
+
+	if mempoolTx, ok := mempool.Get(id); ok {
+		tx = &mempoolTx
+	} else {
+		block, err = e.bc.FindTransactionBlock(id)
+		if err != nil {
+			e.notFound(w, "transaction")
+			return
+		}
+		if block.Pruned() {
+			w.WriteHeader(http.StatusGone)
+			e.render(w, "pruned", block)
+			return
+		}
+		for _, blockTx := range block.Transactions {
+			if bytes.Equal(blockTx.ID, id) {
+				tx = blockTx
+			}
+		}
+		if tx == nil {
+			e.notFound(w, "transaction")
+			return
+		}
+	}
+
+	UTXOSet := UTXOSet{e.bc}
+	unspent := UTXOSet.FindOutputs(tx.ID)
+
+	var outputs []explorerOutput
+	for i, out := range tx.Vout {
+		_, isUnspent := unspent[i]
+		outputs = append(outputs, explorerOutput{
+			Index:   i,
+			Value:   out.Value,
+			Address: out.Address(),
+			Script:  DisasmScript(out.ScriptPubKey),
+			Spent:   block != nil && !isUnspent,
+		})
+	}
+
+	e.render(w, "tx", map[string]any{
+		"Tx":      tx,
+		"Block":   block,
+		"Outputs": outputs,
+	})
+}
+
+func (e *explorer) handleAddress(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("addr")
+	if !ValidateAddress(address) {
+		e.notFound(w, "address")
+		return
+	}
+
+	UTXOSet := UTXOSet{e.bc}
+	UTXOs := UTXOSet.FindUnspentOutputs(address)
+	balance := 0
+	for _, utxo := range UTXOs {
+		balance += utxo.Output.Value
+	}
+
+	var history []explorerTx
+	for _, tx := range mempool.Transactions() {
+		if touchesAddress(&tx, address) {
+			history = append(history, explorerTx{&tx, nil})
+		}
+	}
+
+	bci := e.bc.Iterator()
+	for {
+		block := bci.Next()
+
+		for _, tx := range block.Transactions {
+			if touchesAddress(tx, address) {
+				history = append(history, explorerTx{tx, block})
+			}
+		}
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	e.render(w, "address", map[string]any{
+		"Address": address,
+		"Balance": balance,
+		"UTXOs":   UTXOs,
+		"History": history,
+	})
+}
+
+func (e *explorer) handleMempool(w http.ResponseWriter, r *http.Request) {
+	var txs []*Transaction
+	for _, tx := range mempool.Transactions() {
+		txs = append(txs, &tx)
+	}
+
+	e.render(w, "mempool", txs)
+}
+
+// handleSearch redirects a block hash, transaction ID, height or address to
+// its page.
+func (e *explorer) handleSearch(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+
+	if ValidateAddress(q) {
+		http.Redirect(w, r, "/address/"+q, http.StatusFound)
+		return
+	}
+
+	var height int
+	if _, err := fmt.Sscanf(q, "%d", &height); err == nil && len(q) < 16 {
+		block, err := e.bc.GetBlockByHeight(height)
+		if err == nil {
+			http.Redirect(w, r, "/block/"+hex.EncodeToString(block.Hash), http.StatusFound)
+			return
+		}
+	}
+
+	id, err := hex.DecodeString(q)
+	if err == nil {
+		if _, err := e.bc.GetBlock(id); err == nil {
+			http.Redirect(w, r, "/block/"+q, http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, "/tx/"+q, http.StatusFound)
+		return
+	}
+
+	e.notFound(w, q)
+}
+
+// touchesAddress reports whether tx pays to address or, for key addresses,
+// spends with the address's key.
+func touchesAddress(tx *Transaction, address string) bool {
+	for _, out := range tx.Vout {
+		if out.IsLockedToAddress(address) {
+			return true
+		}
+	}
+
+	addrVersion, pubKeyHash, err := DecodeAddress(address)
+	if err != nil || addrVersion != version || tx.IsCoinbase() {
+		return false
+	}
+
+	for _, in := range tx.Vin {
+		if in.UsesKey(pubKeyHash) {
+			return true
+		}
+	}
+
+	return false
+}
+
+const explorerTemplates = `
+{{define "header"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Block explorer</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+code { font-size: 0.9em; }
+.invalid { color: #b00; }
+</style>
+</head>
+<body>
+<p><a href="/">Blocks</a> | <a href="/mempool">Mempool</a>
+<form action="/search" style="display:inline"><input name="q" size="70" placeholder="block hash, height, transaction ID or address"></form></p>
+{{end}}
+
+{{define "footer"}}</body>
+</html>
+{{end}}
+
+{{define "index"}}{{template "header"}}
+<h1>Recent blocks</h1>
+<p>{{.MempoolSize}} transaction(s) in the <a href="/mempool">mempool</a></p>
+<table>
+<tr><th>Height</th><th>Hash</th><th>Time</th><th>Transactions</th></tr>
+{{range .Blocks}}<tr><td>{{.Height}}</td><td><a href="/block/{{hex .Hash}}"><code>{{hex .Hash}}</code></a></td><td>{{time .Timestamp}}</td><td>{{len .Transactions}}</td></tr>
+{{end}}</table>
+{{template "footer"}}{{end}}
+
+{{define "block"}}{{template "header"}}
+<h1>Block {{.Height}}</h1>
+<table>
+<tr><th>Hash</th><td><code>{{hex .Hash}}</code></td></tr>
+<tr><th>Previous block</th><td>{{if .PrevBlockHash}}<a href="/block/{{hex .PrevBlockHash}}"><code>{{hex .PrevBlockHash}}</code></a>{{else}}none (genesis){{end}}</td></tr>
+<tr><th>Time</th><td>{{time .Timestamp}}</td></tr>
+<tr><th>Nonce</th><td>{{.Nonce}}</td></tr>
+<tr><th>Seal</th><td>{{if sealValid .}}valid{{else}}<span class="invalid">invalid</span>{{end}}</td></tr>
+</table>
+<h2>Transactions</h2>
+{{range .Transactions}}{{template "txsummary" .}}{{end}}
+{{template "footer"}}{{end}}
+
+{{define "txsummary"}}<h3><a href="/tx/{{hex .ID}}"><code>{{hex .ID}}</code></a></h3>
+<table>
+<tr><th>Inputs</th><th>Outputs</th></tr>
+<tr><td>{{if .IsCoinbase}}coinbase{{else}}{{range .Vin}}<a href="/tx/{{hex .Txid}}"><code>{{hex .Txid}}</code></a>:{{.Vout}}<br>{{end}}{{end}}</td>
+<td>{{range .Vout}}{{with .Address}}<a href="/address/{{.}}">{{.}}</a>{{else}}<code>{{disasm .ScriptPubKey}}</code>{{end}}: {{.Value}}<br>{{end}}</td></tr>
+</table>
+{{end}}
+
+{{define "tx"}}{{template "header"}}
+<h1>Transaction</h1>
+<p><code>{{hex .Tx.ID}}</code></p>
+<p>{{if .Block}}Confirmed in block <a href="/block/{{hex .Block.Hash}}">{{.Block.Height}}</a>{{else}}Unconfirmed (in mempool){{end}}</p>
+<h2>Inputs</h2>
+{{if .Tx.IsCoinbase}}<p>Coinbase</p>{{else}}<table>
+<tr><th>#</th><th>Previous output</th><th>Unlocking script</th></tr>
+{{range $i, $in := .Tx.Vin}}<tr><td>{{$i}}</td><td><a href="/tx/{{hex $in.Txid}}"><code>{{hex $in.Txid}}</code></a>:{{$in.Vout}}</td><td><code>{{disasm $in.ScriptSig}}</code></td></tr>
+{{end}}</table>{{end}}
+<h2>Outputs</h2>
+<table>
+<tr><th>#</th><th>Address</th><th>Locking script</th><th>Value</th><th>Status</th></tr>
+{{range .Outputs}}<tr><td>{{.Index}}</td><td>{{with .Address}}<a href="/address/{{.}}">{{.}}</a>{{end}}</td><td><code>{{.Script}}</code></td><td>{{.Value}}</td><td>{{if .Spent}}spent{{else}}unspent{{end}}</td></tr>
+{{end}}</table>
+{{template "footer"}}{{end}}
+
+{{define "address"}}{{template "header"}}
+<h1>Address {{.Address}}</h1>
+<p>Balance: {{.Balance}}</p>
+<h2>Unspent outputs</h2>
+<table>
+<tr><th>Output</th><th>Value</th></tr>
+{{range .UTXOs}}<tr><td><a href="/tx/{{hex .TxID}}"><code>{{hex .TxID}}</code></a>:{{.Index}}</td><td>{{.Output.Value}}</td></tr>
+{{end}}</table>
+<h2>History</h2>
+<table>
+<tr><th>Transaction</th><th>Block</th></tr>
+{{range .History}}<tr><td><a href="/tx/{{hex .Tx.ID}}"><code>{{hex .Tx.ID}}</code></a></td><td>{{if .Block}}<a href="/block/{{hex .Block.Hash}}">{{.Block.Height}}</a>{{else}}unconfirmed{{end}}</td></tr>
+{{end}}</table>
+{{template "footer"}}{{end}}
+
+{{define "mempool"}}{{template "header"}}
+<h1>Mempool</h1>
+{{range .}}{{template "txsummary" .}}{{else}}<p>The mempool is empty.</p>{{end}}
+{{template "footer"}}{{end}}
+
+{{define "pruned"}}{{template "header"}}
+<h1>Pruned</h1>
+<p>The transaction was confirmed in block <a href="/block/{{hex .Hash}}">{{.Height}}</a>, whose transactions this node has pruned.</p>
+{{template "footer"}}{{end}}
+
+{{define "notfound"}}{{template "header"}}
+<h1>Not found</h1>
+<p>No {{.}} matches the request.</p>
+{{template "footer"}}{{end}}
+`
@@ -3,7 +3,8 @@ module github.com/Chungws/go-blockchain
 go 1.22.1
 
 require (
-	github.com/boltdb/bolt v1.3.1 // indirect
-	golang.org/x/crypto v0.25.0 // indirect
-	golang.org/x/sys v0.22.0 // indirect
+	github.com/boltdb/bolt v1.3.1
+	golang.org/x/crypto v0.25.0
 )
+
+require golang.org/x/sys v0.22.0 // indirect
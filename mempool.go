@@ -0,0 +1,330 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const (
+	RemovalReasonBlock    = "block"
+	RemovalReasonReplaced = "replaced"
+	RemovalReasonConflict = "conflict"
+
+	// maxReplacementEvictions limits how many transactions, counting the
+	// descendants of the ones replaced, a single replacement may evict.
+	maxReplacementEvictions = 100
+)
+
+// Mempool holds transactions waiting to be mined. It is shared between the
+// connection handlers and the RPC and REST servers.
+type Mempool struct {
+	mu   sync.RWMutex
+	txs  map[string]Transaction
+	fees map[string]int
+	// spends maps each output spent by a mempool transaction to the spender.
+	spends map[string]string
+}
+
+func NewMempool() *Mempool {
+	return &Mempool{
+		txs:    make(map[string]Transaction),
+		fees:   make(map[string]int),
+		spends: make(map[string]string),
+	}
+}
+
+// Add puts tx, which pays fee, in the mempool. A transaction spending the same
+// outputs as ones already in the mempool replaces them, along with their
+// descendants, if they all signal replaceability, tx spends none of their
+// outputs and it pays a higher fee than all of them together and a higher fee
+// rate than each it conflicts with.
+func (mp *Mempool) Add(tx Transaction, fee int) error {
+	txID := hex.EncodeToString(tx.ID)
+
+	mp.mu.Lock()
+	if _, exists := mp.txs[txID]; exists {
+		mp.mu.Unlock()
+		return nil
+	}
+
+	evicted, err := mp.replacedBy(&tx, fee)
+	if err != nil {
+		mp.mu.Unlock()
+		return err
+	}
+	for _, e := range evicted {
+		mp.remove(e)
+	}
+
+	mp.txs[txID] = tx
+	mp.fees[txID] = fee
+	for _, vin := range tx.Vin {
+		mp.spends[OutPoint{vin.Txid, vin.Vout}.String()] = txID
+	}
+	mp.mu.Unlock()
+
+	for _, e := range evicted {
+		notifier.PublishTxRemoved(&e.tx, RemovalReasonReplaced)
+	}
+	notifier.PublishTxAccepted(&tx)
+
+	return nil
+}
+
+type evictedTx struct {
+	id string
+	tx Transaction
+}
+
+// replacedBy returns the transactions tx would evict, or why it can't replace
+// them. mp.mu must be held.
+func (mp *Mempool) replacedBy(tx *Transaction, fee int) ([]evictedTx, error) {
+	conflicts := make(map[string]bool)
+	for _, vin := range tx.Vin {
+		if id, ok := mp.spends[OutPoint{vin.Txid, vin.Vout}.String()]; ok {
+			conflicts[id] = true
+		}
+	}
+	if len(conflicts) == 0 {
+		return nil, nil
+	}
+
+	size := tx.Size()
+	for id := range conflicts {
+		conflict := mp.txs[id]
+		if !conflict.SignalsReplacement() {
+			return nil, fmt.Errorf("conflicts with non-replaceable transaction %s", id)
+		}
+		// Compare fee rates without rounding: fee/size > conflictFee/conflictSize.
+		if fee*conflict.Size() <= mp.fees[id]*size {
+			return nil, fmt.Errorf("fee rate doesn't exceed that of transaction %s", id)
+		}
+	}
+
+	var evicted []evictedTx
+	seen := make(map[string]bool)
+	queue := make([]string, 0, len(conflicts))
+	for id := range conflicts {
+		queue = append(queue, id)
+	}
+	sort.Strings(queue)
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		evicted = append(evicted, evictedTx{id, mp.txs[id]})
+		if len(evicted) > maxReplacementEvictions {
+			return nil, fmt.Errorf("replacement would evict more than %d transactions", maxReplacementEvictions)
+		}
+		queue = append(queue, mp.children(mp.txs[id])...)
+	}
+	// Evicting the transactions tx spends from would leave it without inputs.
+	for _, vin := range tx.Vin {
+		if id := hex.EncodeToString(vin.Txid); seen[id] {
+			return nil, fmt.Errorf("spends an output of transaction %s it replaces", id)
+		}
+	}
+
+	evictedFees := 0
+	for _, e := range evicted {
+		evictedFees += mp.fees[e.id]
+	}
+	if fee <= evictedFees {
+		return nil, fmt.Errorf("fee %d doesn't exceed the %d paid by the %d transactions it replaces", fee, evictedFees, len(evicted))
+	}
+
+	return evicted, nil
+}
+
+// children returns the IDs of mempool transactions spending outputs of tx.
+// mp.mu must be held.
+func (mp *Mempool) children(tx Transaction) []string {
+	var ids []string
+	for i := range tx.Vout {
+		if id, ok := mp.spends[OutPoint{tx.ID, i}.String()]; ok {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+// remove drops a transaction. mp.mu must be held.
+func (mp *Mempool) remove(e evictedTx) {
+	for _, vin := range e.tx.Vin {
+		delete(mp.spends, OutPoint{vin.Txid, vin.Vout}.String())
+	}
+	delete(mp.txs, e.id)
+	delete(mp.fees, e.id)
+}
+
+func (mp *Mempool) Remove(txID []byte, reason string) {
+	id := hex.EncodeToString(txID)
+
+	mp.mu.Lock()
+	tx, exists := mp.txs[id]
+	if exists {
+		mp.remove(evictedTx{id, tx})
+	}
+	mp.mu.Unlock()
+
+	if exists {
+		notifier.PublishTxRemoved(&tx, reason)
+	}
+}
+
+// RemoveBlockTransactions drops the transactions of b from the mempool, and
+// the ones spending the same outputs as them, which can no longer be mined.
+func (mp *Mempool) RemoveBlockTransactions(b *Block, reason string) {
+	for _, tx := range b.Transactions {
+		mp.Remove(tx.ID, reason)
+	}
+
+	for _, tx := range b.Transactions {
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, vin := range tx.Vin {
+			mp.mu.RLock()
+			id, ok := mp.spends[OutPoint{vin.Txid, vin.Vout}.String()]
+			mp.mu.RUnlock()
+			if ok {
+				mp.removeWithDescendants(id, RemovalReasonConflict)
+			}
+		}
+	}
+}
+
+// ResubmitBlockTransactions returns the transactions of blocks that left the
+// main chain of bc, given newest first, to the mempool. Those no longer valid
+// on the tip, such as the ones the new main chain confirms, are dropped.
+func (mp *Mempool) ResubmitBlockTransactions(bc *BlockChain, blocks []*Block) {
+	for i := len(blocks) - 1; i >= 0; i-- {
+		for _, tx := range blocks[i].Transactions {
+			if tx.IsCoinbase() {
+				continue
+			}
+			fee, err := bc.ValidateTransaction(tx, bc.GetBestHeight()+1, timeSource.AdjustedTime(), mp.Pending())
+			if err == nil {
+				mp.Add(*tx, fee)
+			}
+		}
+	}
+}
+
+// removeWithDescendants drops a transaction and the ones spending its
+// outputs, directly or not.
+func (mp *Mempool) removeWithDescendants(id, reason string) {
+	var removed []Transaction
+
+	mp.mu.Lock()
+	queue := []string{id}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		tx, ok := mp.txs[id]
+		if !ok {
+			continue
+		}
+		queue = append(queue, mp.children(tx)...)
+		mp.remove(evictedTx{id, tx})
+		removed = append(removed, tx)
+	}
+	mp.mu.Unlock()
+
+	for _, tx := range removed {
+		notifier.PublishTxRemoved(&tx, reason)
+	}
+}
+
+func (mp *Mempool) Get(txID []byte) (Transaction, bool) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	tx, ok := mp.txs[hex.EncodeToString(txID)]
+	return tx, ok
+}
+
+// IsSpent reports whether a mempool transaction spends the output vout of
+// txID.
+func (mp *Mempool) IsSpent(txID []byte, vout int) bool {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	_, ok := mp.spends[OutPoint{txID, vout}.String()]
+	return ok
+}
+
+// UnspentOutputs returns the outputs of mempool transactions locked to
+// address that no other mempool transaction spends.
+func (mp *Mempool) UnspentOutputs(address string) []UTXO {
+	var UTXOs []UTXO
+	for _, tx := range mp.Transactions() {
+		for outIdx, out := range tx.Vout {
+			if out.IsLockedToAddress(address) && !mp.IsSpent(tx.ID, outIdx) {
+				UTXOs = append(UTXOs, UTXO{tx.ID, outIdx, out})
+			}
+		}
+	}
+
+	return UTXOs
+}
+
+// Pending returns a snapshot of the mempool keyed by hex transaction ID, for
+// validating transactions that spend unconfirmed outputs.
+func (mp *Mempool) Pending() map[string]Transaction {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	pending := make(map[string]Transaction, len(mp.txs))
+	for id, tx := range mp.txs {
+		pending[id] = tx
+	}
+
+	return pending
+}
+
+// Fee returns the fee paid by a mempool transaction.
+func (mp *Mempool) Fee(txID []byte) (int, bool) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	fee, ok := mp.fees[hex.EncodeToString(txID)]
+	return fee, ok
+}
+
+func (mp *Mempool) Has(txID []byte) bool {
+	_, ok := mp.Get(txID)
+	return ok
+}
+
+func (mp *Mempool) Len() int {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	return len(mp.txs)
+}
+
+// Transactions returns a snapshot of the mempool ordered by transaction ID.
+func (mp *Mempool) Transactions() []Transaction {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	var ids []string
+	for id := range mp.txs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var txs []Transaction
+	for _, id := range ids {
+		txs = append(txs, mp.txs[id])
+	}
+
+	return txs
+}
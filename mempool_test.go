@@ -0,0 +1,80 @@
+package main
+
+import "testing"
+
+// TestMempoolFollowsReorg checks that a reorganization returns the
+// transactions of the disconnected blocks to the mempool, and that only
+// blocks joining the main chain take them out again.
+func TestMempoolFollowsReorg(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	genesis := genesisCoinbase(t, bc)
+	genesisBlock := tipBlock(t, bc)
+
+	spend := newTestTx(wallet, genesis, []int{0}, subsidy)
+	addTestBlock(t, bc, newTestBlock(genesisBlock, wallet, spend))
+
+	fork := newTestBlock(genesisBlock, wallet)
+	addTestBlock(t, bc, fork)
+	side := newTestBlock(fork, wallet)
+	addTestBlock(t, bc, side)
+	if !mempool.Has(spend.ID) {
+		t.Fatal("the transaction of the disconnected block isn't in the mempool")
+	}
+
+	addTestBlock(t, bc, newTestBlock(fork, wallet, spend))
+	if !mempool.Has(spend.ID) {
+		t.Error("a side chain block took the transaction out of the mempool")
+	}
+
+	addTestBlock(t, bc, newTestBlock(side, wallet, spend))
+	if mempool.Has(spend.ID) {
+		t.Error("the transaction is still in the mempool after it was confirmed")
+	}
+}
+
+func TestMempoolReplacement(t *testing.T) {
+	newTx := func(outputs int, prev ...OutPoint) Transaction {
+		tx := Transaction{}
+		for _, p := range prev {
+			tx.Vin = append(tx.Vin, TXInput{p.Txid, p.Vout, nil, 0})
+		}
+		for i := 0; i < outputs; i++ {
+			tx.Vout = append(tx.Vout, TXOutput{1, nil})
+		}
+		tx.ID = tx.Hash()
+		return tx
+	}
+	funding := OutPoint{[]byte{0x01}, 0}
+	parent := newTx(2, funding)
+	child := newTx(1, OutPoint{parent.ID, 0})
+
+	tests := []struct {
+		name   string
+		tx     Transaction
+		fee    int
+		errMsg string
+	}{
+		{"higher fee", newTx(1, funding), 100, ""},
+		{"fee too low", newTx(1, funding), 2, "doesn't exceed"},
+		{"spends an evicted output", newTx(1, funding, OutPoint{parent.ID, 1}), 100, "spends an output"},
+		{"spends an evicted descendant's output", newTx(1, funding, OutPoint{child.ID, 0}), 100, "spends an output"},
+		{"spends an output of its conflict's parent", newTx(1, OutPoint{parent.ID, 0}, OutPoint{parent.ID, 1}), 100, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mp := NewMempool()
+			if err := mp.Add(parent, 1); err != nil {
+				t.Fatal(err)
+			}
+			if err := mp.Add(child, 1); err != nil {
+				t.Fatal(err)
+			}
+
+			err := mp.Add(tt.tx, tt.fee)
+			checkError(t, err, tt.errMsg)
+			if replaced := !mp.Has(child.ID); replaced != (tt.errMsg == "") {
+				t.Errorf("replaced = %t", replaced)
+			}
+		})
+	}
+}
@@ -29,19 +29,22 @@ func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
 	return &node
 }
 
+// NewMerkleTree builds the tree over data. A level with an odd number of
+// nodes pairs its last node with itself; the leaves are always paired that
+// way, even a single one.
 func NewMerkleTree(data [][]byte) *MerkleTree {
 	var nodes []MerkleNode
 
-	if len(data)%2 != 0 {
-		data = append(data, data[len(data)-1])
-	}
-
 	for _, datum := range data {
 		node := NewMerkleNode(nil, nil, datum)
 		nodes = append(nodes, *node)
 	}
 
-	for i := 0; i < len(data)/2; i++ {
+	for {
+		if len(nodes)%2 != 0 {
+			nodes = append(nodes, nodes[len(nodes)-1])
+		}
+
 		var newLevel []MerkleNode
 
 		for j := 0; j < len(nodes); j += 2 {
@@ -50,9 +53,67 @@ func NewMerkleTree(data [][]byte) *MerkleTree {
 		}
 
 		nodes = newLevel
+		if len(nodes) <= 1 {
+			break
+		}
 	}
 
 	mTree := MerkleTree{&nodes[0]}
 
 	return &mTree
 }
+
+// MerkleBranch returns the hashes that combine with the leaf of data[index],
+// bottom up, into the root of the tree over data. A nil hash stands for the
+// node being paired with itself.
+func MerkleBranch(data [][]byte, index int) [][]byte {
+	var level [][]byte
+	for _, datum := range data {
+		level = append(level, NewMerkleNode(nil, nil, datum).Data)
+	}
+
+	var branch [][]byte
+	for len(level) > 1 || len(branch) == 0 {
+		if index^1 == len(level) {
+			branch = append(branch, nil)
+		} else {
+			branch = append(branch, level[index^1])
+		}
+
+		if len(level)%2 != 0 {
+			level = append(level, level[len(level)-1])
+		}
+		var newLevel [][]byte
+		for j := 0; j < len(level); j += 2 {
+			newLevel = append(newLevel, hashMerklePair(level[j], level[j+1]))
+		}
+		level = newLevel
+		index /= 2
+	}
+
+	return branch
+}
+
+// MerkleRootFromBranch folds branch, as returned by MerkleBranch, into the
+// leaf of datum at index, giving the merkle root. Miners use it to update the
+// root of a block template after changing the coinbase.
+func MerkleRootFromBranch(datum []byte, index int, branch [][]byte) []byte {
+	hash := NewMerkleNode(nil, nil, datum).Data
+	for _, sibling := range branch {
+		switch {
+		case sibling == nil:
+			hash = hashMerklePair(hash, hash)
+		case index%2 == 0:
+			hash = hashMerklePair(hash, sibling)
+		default:
+			hash = hashMerklePair(sibling, hash)
+		}
+		index /= 2
+	}
+
+	return hash
+}
+
+func hashMerklePair(left, right []byte) []byte {
+	return NewMerkleNode(&MerkleNode{Data: left}, &MerkleNode{Data: right}, nil).Data
+}
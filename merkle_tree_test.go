@@ -0,0 +1,27 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// TestMerkleTree builds trees whose levels have odd numbers of nodes, and
+// checks that each leaf's branch folds into the root.
+func TestMerkleTree(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		t.Run(fmt.Sprint(n, " leaves"), func(t *testing.T) {
+			var data [][]byte
+			for i := 0; i < n; i++ {
+				data = append(data, []byte{byte(i)})
+			}
+
+			root := NewMerkleTree(data).RootNode.Data
+			for i := range data {
+				if got := MerkleRootFromBranch(data[i], i, MerkleBranch(data, i)); !bytes.Equal(got, root) {
+					t.Errorf("leaf %d folds into %x, want %x", i, got, root)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Workers check for cancellation every cancelCheckInterval hashes.
+const cancelCheckInterval = 1 << 12
+
+// Miner searches for proof of work on several goroutines. Worker i takes
+// the extra nonces i, i+Workers, i+2*Workers, ... in the coinbase and tries
+// every nonce with each, so the workers never repeat each other's work.
+type Miner struct {
+	Workers int
+
+	// hashRate holds the float64 bits of the hashes per second of the
+	// last search.
+	hashRate atomic.Uint64
+}
+
+var miner = NewMiner(runtime.NumCPU())
+
+func NewMiner(workers int) *Miner {
+	if workers < 1 {
+		workers = 1
+	}
+
+	return &Miner{Workers: workers}
+}
+
+// HashRate returns the hashes per second of the last search.
+func (m *Miner) HashRate() float64 {
+	return math.Float64frombits(m.hashRate.Load())
+}
+
+// Solve finds a nonce and an extra nonce for the coinbase of block that give
+// it a hash below the target, and sets them along with the hash. It gives up
+// with ctx.Err() when ctx is done, leaving block unchanged.
+func (m *Miner) Solve(ctx context.Context, block *Block) error {
+	coinbase := -1
+	for i, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			coinbase = i
+		}
+	}
+	if coinbase < 0 {
+		return errors.New("block has no coinbase")
+	}
+
+	withExtraNonce := func(extraNonce uint64) *Block {
+		candidate := *block
+		candidate.Transactions = make([]*Transaction, len(block.Transactions))
+		copy(candidate.Transactions, block.Transactions)
+		candidate.Transactions[coinbase] = block.Transactions[coinbase].WithExtraNonce(extraNonce)
+		return &candidate
+	}
+
+	var solved *Block
+	fmt.Printf("Mining a new block on %d workers\n", m.Workers)
+	start := time.Now()
+	m.Search(ctx, NewProofOfWork(block).target,
+		func(extraNonce uint64) []byte {
+			return NewProofOfWork(withExtraNonce(extraNonce)).prepareHeader()
+		},
+		func(extraNonce uint64, nonce int, hash []byte) bool {
+			solved = withExtraNonce(extraNonce)
+			solved.Nonce = nonce
+			solved.Hash = hash
+			return false
+		})
+	elapsed := time.Since(start).Round(time.Millisecond)
+
+	if solved == nil {
+		fmt.Printf("Mining stopped after %s at %.0f hashes/s\n", elapsed, m.HashRate())
+		return ctx.Err()
+	}
+	fmt.Printf("Mined block %x in %s at %.0f hashes/s\n", solved.Hash, elapsed, m.HashRate())
+	*block = *solved
+
+	return nil
+}
+
+// Search hashes header(extraNonce) followed by each nonce, for every extra
+// nonce, and calls found with the hashes below target. found is called by one
+// worker at a time; the search ends when it returns false or ctx is done.
+func (m *Miner) Search(ctx context.Context, target *big.Int, header func(extraNonce uint64) []byte, found func(extraNonce uint64, nonce int, hash []byte) bool) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var hashes atomic.Uint64
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	start := time.Now()
+
+	for w := 0; w < m.Workers; w++ {
+		wg.Add(1)
+		go func(worker uint64) {
+			defer wg.Done()
+			m.work(ctx, worker, target, header, &hashes, func(extraNonce uint64, nonce int, hash []byte) bool {
+				mu.Lock()
+				defer mu.Unlock()
+				if ctx.Err() != nil {
+					return false
+				}
+				if !found(extraNonce, nonce, hash) {
+					cancel()
+					return false
+				}
+				return true
+			})
+		}(uint64(w))
+	}
+	wg.Wait()
+
+	rate := float64(hashes.Load()) / time.Since(start).Seconds()
+	m.hashRate.Store(math.Float64bits(rate))
+}
+
+func (m *Miner) work(ctx context.Context, worker uint64, target *big.Int, header func(uint64) []byte, hashes *atomic.Uint64, found func(uint64, int, []byte) bool) {
+	var hashInt big.Int
+	var tried uint64
+	defer func() { hashes.Add(tried % cancelCheckInterval) }()
+
+	for extraNonce := worker; ; extraNonce += uint64(m.Workers) {
+		prefix := header(extraNonce)
+
+		for nonce := 0; nonce < maxNonce; nonce++ {
+			tried++
+			if tried%cancelCheckInterval == 0 {
+				hashes.Add(cancelCheckInterval)
+				select {
+				case <-ctx.Done():
+					return
+				default:
+				}
+			}
+
+			hash := sha256.Sum256(append(prefix, IntToHex(int64(nonce))...))
+			hashInt.SetBytes(hash[:])
+			if hashInt.Cmp(target) == -1 && !found(extraNonce, nonce, hash[:]) {
+				return
+			}
+		}
+	}
+}
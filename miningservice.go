@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MiningPolicy decides when the mining service starts on a block.
+type MiningPolicy struct {
+	// MinTransactions is how many mempool transactions are worth a block.
+	MinTransactions int
+	// TargetInterval is how long after the tip a block is mined with
+	// whatever the mempool holds, even nothing. Zero always waits for
+	// MinTransactions.
+	TargetInterval time.Duration
+	// MaxBlockSize caps the blocks mined below the network limit. Zero
+	// means the network limit.
+	MaxBlockSize int
+}
+
+var DefaultMiningPolicy = MiningPolicy{
+	MinTransactions: 2,
+	TargetInterval:  10 * time.Minute,
+}
+
+func (p MiningPolicy) validate() error {
+	if p.MinTransactions < 0 {
+		return fmt.Errorf("minimum transactions %d is negative", p.MinTransactions)
+	}
+	if p.TargetInterval < 0 {
+		return fmt.Errorf("target interval %s is negative", p.TargetInterval)
+	}
+	if p.MaxBlockSize < 0 || p.MaxBlockSize > netParams.MaxBlockSize {
+		return fmt.Errorf("maximum block size %d is outside 0..%d", p.MaxBlockSize, netParams.MaxBlockSize)
+	}
+
+	return nil
+}
+
+func (p MiningPolicy) maxBlockSize() int {
+	if p.MaxBlockSize == 0 {
+		return netParams.MaxBlockSize
+	}
+
+	return p.MaxBlockSize
+}
+
+// MiningInfo describes the state of a mining service.
+type MiningInfo struct {
+	Running     bool
+	Address     string
+	Policy      MiningPolicy
+	BlocksMined int
+	HashRate    float64
+}
+
+// MiningService mines blocks from the mempool in the background, following
+// a MiningPolicy, and announces them to the network.
+type MiningService struct {
+	bc *BlockChain
+
+	mu      sync.Mutex
+	address string
+	policy  MiningPolicy
+	cancel  context.CancelFunc
+	done    chan struct{}
+	mined   int
+}
+
+func NewMiningService(bc *BlockChain) *MiningService {
+	return &MiningService{bc: bc}
+}
+
+// Start mines on the tip until Stop, paying the rewards to address.
+func (s *MiningService) Start(address string, policy MiningPolicy) error {
+	if !ValidateAddress(address) {
+		return fmt.Errorf("invalid address %s", address)
+	}
+	err := policy.validate()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		return errors.New("mining is already running")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.address, s.policy, s.cancel, s.done = address, policy, cancel, make(chan struct{})
+	go s.run(ctx, address, policy, s.done)
+	fmt.Printf("Mining is on. Address to receive rewards: %s\n", address)
+
+	return nil
+}
+
+// Stop abandons the block being mined and waits for the service to exit.
+func (s *MiningService) Stop() error {
+	s.mu.Lock()
+	cancel, done := s.cancel, s.done
+	s.cancel, s.done = nil, nil
+	s.mu.Unlock()
+
+	if cancel == nil {
+		return errors.New("mining is not running")
+	}
+	cancel()
+	<-done
+	fmt.Println("Mining is off")
+
+	return nil
+}
+
+func (s *MiningService) Info() MiningInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return MiningInfo{
+		Running:     s.cancel != nil,
+		Address:     s.address,
+		Policy:      s.policy,
+		BlocksMined: s.mined,
+		HashRate:    miner.HashRate(),
+	}
+}
+
+// run mines a block whenever the policy is met, and otherwise waits for the
+// mempool or the chain to change, or for the target interval to pass.
+func (s *MiningService) run(ctx context.Context, address string, policy MiningPolicy, done chan struct{}) {
+	defer close(done)
+
+	events := notifier.Subscribe()
+	defer notifier.Unsubscribe(events)
+
+	for {
+		tip, err := s.bc.GetBlock(s.bc.Tip())
+		if err != nil {
+			fmt.Println("Mining stopped:", err)
+			return
+		}
+		// Only the fees, whose size is fixed, change in the coinbase.
+		coinbaseSize := NewCoinbaseTX(address, "", tip.Height+1, 0).Size()
+		txs, fees := NewBlockTemplate(s.bc, mempool, policy.maxBlockSize(), coinbaseSize)
+
+		if len(txs) < policy.MinTransactions {
+			var due <-chan time.Time
+			if policy.TargetInterval > 0 {
+				wait := time.Until(time.Unix(tip.Timestamp, 0).Add(policy.TargetInterval))
+				due = time.After(wait)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-events:
+				continue
+			case <-due:
+			}
+
+			// Anything that arrived while waiting goes into the block.
+			tip, err = s.bc.GetBlock(s.bc.Tip())
+			if err != nil {
+				fmt.Println("Mining stopped:", err)
+				return
+			}
+			txs, fees = NewBlockTemplate(s.bc, mempool, policy.maxBlockSize(), coinbaseSize)
+		}
+
+		cbTx := NewCoinbaseTX(address, "", tip.Height+1, fees)
+		txs = append(txs, cbTx)
+
+		_, err = mineAndAnnounce(ctx, s.bc, txs)
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, ErrNotProducer) {
+			// Wait for the producer in turn.
+			select {
+			case <-ctx.Done():
+				return
+			case <-events:
+			}
+			continue
+		}
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+
+		s.mu.Lock()
+		s.mined++
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// PartialTransaction is a transaction spending multisig outputs, bare or
+// behind a script hash, while its signatures are being collected from the
+// key holders. It is passed between them as a JSON file.
+type PartialTransaction struct {
+	Tx      Transaction
+	PrevTXs map[string]Transaction
+	// RedeemScripts holds, per input, the script revealed to spend a
+	// pay-to-script-hash output, or nil for other outputs.
+	RedeemScripts [][]byte
+	// Signatures maps, per input, the hex public key of each signer to its
+	// signature.
+	Signatures []map[string][]byte
+}
+
+// NewPartialTransaction prepares tx for signing. redeemScript is required
+// when tx spends pay-to-script-hash outputs and must hash to their address.
+func NewPartialTransaction(tx *Transaction, prevTXs map[string]Transaction, redeemScript []byte) (*PartialTransaction, error) {
+	ptx := &PartialTransaction{
+		Tx:            *tx,
+		PrevTXs:       prevTXs,
+		RedeemScripts: make([][]byte, len(tx.Vin)),
+		Signatures:    make([]map[string][]byte, len(tx.Vin)),
+	}
+
+	for inID := range tx.Vin {
+		ptx.Signatures[inID] = make(map[string][]byte)
+
+		scriptHash := ExtractScriptHash(ptx.prevScript(inID))
+		if scriptHash == nil {
+			continue
+		}
+		if !bytes.Equal(HashPubKey(redeemScript), scriptHash) {
+			return nil, fmt.Errorf("input %d needs the redeem script hashing to %x", inID, scriptHash)
+		}
+		ptx.RedeemScripts[inID] = redeemScript
+	}
+
+	return ptx, nil
+}
+
+// NewMultiSigAddress returns the address of an m-of-n multisig output.
+func NewMultiSigAddress(m int, pubKeys [][]byte) (string, error) {
+	if len(pubKeys) == 0 || len(pubKeys) > 16 {
+		return "", fmt.Errorf("a multisig address needs 1 to 16 public keys, got %d", len(pubKeys))
+	}
+	if m < 1 || m > len(pubKeys) {
+		return "", fmt.Errorf("required signatures must be between 1 and %d, got %d", len(pubKeys), m)
+	}
+	for _, pubKey := range pubKeys {
+		if len(pubKey) != pubKeyLen {
+			return "", fmt.Errorf("public key %x is not %d bytes", pubKey, pubKeyLen)
+		}
+	}
+
+	return string(EncodeAddress(multiSigVersion, MultiSigScript(m, pubKeys))), nil
+}
+
+func (ptx *PartialTransaction) prevScript(inID int) []byte {
+	vin := ptx.Tx.Vin[inID]
+	prevTx := ptx.PrevTXs[hex.EncodeToString(vin.Txid)]
+
+	return prevTx.Vout[vin.Vout].ScriptPubKey
+}
+
+// signingScript returns the script whose conditions the signers of input
+// inID have to meet: the redeem script for pay-to-script-hash outputs and
+// the locking script otherwise.
+func (ptx *PartialTransaction) signingScript(inID int) []byte {
+	if ptx.RedeemScripts[inID] != nil {
+		return ptx.RedeemScripts[inID]
+	}
+
+	return ptx.prevScript(inID)
+}
+
+// Sign adds the wallet's signature to every multisig input it is a party to
+// and returns how many inputs it signed.
+func (ptx *PartialTransaction) Sign(wallet *Wallet) int {
+	signed := 0
+	pubKey := hex.EncodeToString(wallet.PublicKey)
+
+	for inID := range ptx.Tx.Vin {
+		_, pubKeys, ok := ExtractMultiSig(ptx.signingScript(inID))
+		if !ok {
+			continue
+		}
+
+		for _, key := range pubKeys {
+			if hex.EncodeToString(key) == pubKey {
+				hash := ptx.Tx.SignatureHash(inID, ptx.prevScript(inID))
+				ptx.Signatures[inID][pubKey] = SignHash(wallet.PrivateKey, hash)
+				signed++
+			}
+		}
+	}
+
+	return signed
+}
+
+// Finalize builds the unlocking scripts from the collected signatures, in
+// public key order, and checks that the result verifies.
+func (ptx *PartialTransaction) Finalize() (*Transaction, error) {
+	tx := ptx.Tx
+	tx.Vin = append([]TXInput{}, ptx.Tx.Vin...)
+
+	for inID := range tx.Vin {
+		m, pubKeys, ok := ExtractMultiSig(ptx.signingScript(inID))
+		if !ok {
+			return nil, fmt.Errorf("input %d doesn't spend a multisig output", inID)
+		}
+
+		sb := NewScriptBuilder()
+		count := 0
+		for _, pubKey := range pubKeys {
+			sig, ok := ptx.Signatures[inID][hex.EncodeToString(pubKey)]
+			if ok && count < m {
+				sb.AddData(sig)
+				count++
+			}
+		}
+		if count < m {
+			return nil, fmt.Errorf("input %d has %d of %d required signatures", inID, count, m)
+		}
+		if ptx.RedeemScripts[inID] != nil {
+			sb.AddData(ptx.RedeemScripts[inID])
+		}
+
+		tx.Vin[inID].ScriptSig = sb.Script()
+	}
+
+	if !tx.Verify(ptx.PrevTXs) {
+		return nil, fmt.Errorf("transaction %x doesn't verify", tx.ID)
+	}
+
+	return &tx, nil
+}
+
+func (ptx *PartialTransaction) SaveToFile(path string) error {
+	data, err := json.MarshalIndent(ptx, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
+
+func LoadPartialTransaction(path string) (*PartialTransaction, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var ptx PartialTransaction
+	err = json.Unmarshal(data, &ptx)
+	if err != nil {
+		return nil, err
+	}
+	if len(ptx.Signatures) != len(ptx.Tx.Vin) || len(ptx.RedeemScripts) != len(ptx.Tx.Vin) {
+		return nil, fmt.Errorf("%s doesn't match the %d inputs of its transaction", path, len(ptx.Tx.Vin))
+	}
+
+	return &ptx, nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestCheckMultisig(t *testing.T) {
+	keys := [][]byte{
+		bytes.Repeat([]byte{0x01}, pubKeyLen),
+		bytes.Repeat([]byte{0x02}, pubKeyLen),
+		bytes.Repeat([]byte{0x03}, pubKeyLen),
+	}
+	otherKey := bytes.Repeat([]byte{0x04}, pubKeyLen)
+	sig := func(i int) []byte { return testSig(keys[i]) }
+	twoOfThree := MultiSigScript(2, keys)
+	verify := append(append([]byte{}, twoOfThree[:len(twoOfThree)-1]...), OP_CHECKMULTISIGVERIFY, OP_1)
+
+	tests := []struct {
+		name         string
+		scriptSig    []byte
+		scriptPubKey []byte
+		errMsg       string
+	}{
+		{"first and second keys", pushes(sig(0), sig(1)), twoOfThree, ""},
+		{"first and third keys", pushes(sig(0), sig(2)), twoOfThree, ""},
+		{"second and third keys", pushes(sig(1), sig(2)), twoOfThree, ""},
+		{"out of key order", pushes(sig(2), sig(0)), twoOfThree, "evaluated to false"},
+		{"same signature twice", pushes(sig(0), sig(0)), twoOfThree, "evaluated to false"},
+		{"signature of another key", pushes(sig(0), testSig(otherKey)), twoOfThree, "evaluated to false"},
+		{"below the threshold", pushes(sig(0)), twoOfThree, "stack underflow"},
+		{"1 of 1", pushes(sig(0)), MultiSigScript(1, keys[:1]), ""},
+		{"3 of 3", pushes(sig(0), sig(1), sig(2)), MultiSigScript(3, keys), ""},
+		{"3 of 3 missing one", pushes(sig(0), sig(1), sig(1)), MultiSigScript(3, keys), "evaluated to false"},
+		{"OP_CHECKMULTISIGVERIFY", pushes(sig(0), sig(1)), verify, ""},
+		{"OP_CHECKMULTISIGVERIFY below the threshold", pushes(sig(0), testSig(otherKey)), verify, "threshold is not met"},
+		{"no keys", nil, []byte{OP_0, OP_0, OP_CHECKMULTISIG}, "invalid public key count 0"},
+		{"more signatures than keys", pushes(sig(0), sig(1), sig(1)), MultiSigScript(3, keys[:2]), "invalid signature count 3 of 2"},
+		{"keys missing", nil, []byte{OP_1, OP_1 + 1, OP_CHECKMULTISIG}, "stack underflow"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkError(t, VerifyScript(tt.scriptSig, tt.scriptPubKey, testChecker{}), tt.errMsg)
+		})
+	}
+}
+
+// newMultisigSpend returns a transaction spending an output locked by
+// scriptPubKey, and the transaction holding that output.
+func newMultisigSpend(scriptPubKey []byte, to *Wallet) (*Transaction, map[string]Transaction) {
+	prev := Transaction{Vout: []TXOutput{{10, scriptPubKey}}}
+	prev.ID = prev.Hash()
+
+	tx := &Transaction{nil, []TXInput{{prev.ID, 0, nil, SequenceFinal}}, []TXOutput{*NewTXOutput(10, string(to.GetAddress()))}, 0}
+	tx.ID = tx.Hash()
+
+	return tx, map[string]Transaction{hex.EncodeToString(prev.ID): prev}
+}
+
+// TestPartialTransactionFinalize spends a 2-of-3 multisig output with the
+// signatures of different key holders, collected in any order.
+func TestPartialTransactionFinalize(t *testing.T) {
+	wallets := []*Wallet{NewWallet(), NewWallet(), NewWallet()}
+	var pubKeys [][]byte
+	for _, wallet := range wallets {
+		pubKeys = append(pubKeys, wallet.PublicKey)
+	}
+	address, err := NewMultiSigAddress(2, pubKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scriptPubKey, err := PayToAddrScript(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		signers []int
+		errMsg  string
+	}{
+		{"first and second", []int{0, 1}, ""},
+		{"signed in reverse key order", []int{2, 0}, ""},
+		{"all three", []int{0, 1, 2}, ""},
+		{"one", []int{1}, "has 1 of 2 required signatures"},
+		{"none", nil, "has 0 of 2 required signatures"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, prevTXs := newMultisigSpend(scriptPubKey, wallets[0])
+			ptx, err := NewPartialTransaction(tx, prevTXs, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, i := range tt.signers {
+				if signed := ptx.Sign(wallets[i]); signed != 1 {
+					t.Fatalf("wallet %d signed %d inputs, want 1", i, signed)
+				}
+			}
+			if signed := ptx.Sign(NewWallet()); signed != 0 {
+				t.Errorf("another wallet signed %d inputs", signed)
+			}
+
+			_, err = ptx.Finalize()
+			checkError(t, err, tt.errMsg)
+		})
+	}
+}
+
+func TestNewMultiSigAddress(t *testing.T) {
+	key := bytes.Repeat([]byte{0x01}, pubKeyLen)
+
+	tests := []struct {
+		name    string
+		m       int
+		pubKeys [][]byte
+		errMsg  string
+	}{
+		{"1 of 1", 1, [][]byte{key}, ""},
+		{"16 of 16", 16, bytes.SplitAfter(bytes.Repeat(key, 16), key)[:16], ""},
+		{"no keys", 1, nil, "needs 1 to 16 public keys"},
+		{"17 keys", 1, bytes.SplitAfter(bytes.Repeat(key, 17), key)[:17], "needs 1 to 16 public keys"},
+		{"no signatures required", 0, [][]byte{key}, "between 1 and 1"},
+		{"more signatures than keys", 2, [][]byte{key}, "between 1 and 1"},
+		{"short key", 1, [][]byte{key[1:]}, "is not 64 bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			address, err := NewMultiSigAddress(tt.m, tt.pubKeys)
+			checkError(t, err, tt.errMsg)
+			if err != nil {
+				return
+			}
+			script, err := PayToAddrScript(address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m, pubKeys, ok := ExtractMultiSig(script); !ok || m != tt.m || len(pubKeys) != len(tt.pubKeys) {
+				t.Errorf("address holds %d of %d keys, want %d of %d", m, len(pubKeys), tt.m, len(tt.pubKeys))
+			}
+		})
+	}
+}
+
+// TestNewPartialTransactionRedeemScript spends a multisig redeem script
+// behind a script hash, which has to be the one the output commits to.
+func TestNewPartialTransactionRedeemScript(t *testing.T) {
+	signer, other := NewWallet(), NewWallet()
+	redeemScript := MultiSigScript(1, [][]byte{signer.PublicKey})
+	address, err := NewScriptHashAddress(redeemScript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scriptPubKey, err := PayToAddrScript(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		redeemScript []byte
+		errMsg       string
+	}{
+		{"matching redeem script", redeemScript, ""},
+		{"another redeem script", MultiSigScript(1, [][]byte{other.PublicKey}), "needs the redeem script hashing to"},
+		{"no redeem script", nil, "needs the redeem script hashing to"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, prevTXs := newMultisigSpend(scriptPubKey, signer)
+			ptx, err := NewPartialTransaction(tx, prevTXs, tt.redeemScript)
+			checkError(t, err, tt.errMsg)
+			if err != nil {
+				return
+			}
+
+			ptx.Sign(signer)
+			_, err = ptx.Finalize()
+			checkError(t, err, "")
+		})
+	}
+}
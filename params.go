@@ -0,0 +1,59 @@
+package main
+
+// NetParams holds the consensus rules the nodes of a network agree on.
+type NetParams struct {
+	Name string
+
+	// MaxBlockSize bounds the serialized size of a block, and so the
+	// memory a peer can make a node spend on one.
+	MaxBlockSize int
+	// MaxTxSize bounds the serialized size of a transaction.
+	MaxTxSize int
+	// MaxBlockSigOps bounds the signature checks the scripts of a block's
+	// transactions can ask for.
+	MaxBlockSigOps int
+
+	// A block's timestamp must be later than the median of those of the
+	// MedianTimeBlocks blocks before it, and at most MaxFutureBlockTime
+	// seconds ahead of the network-adjusted time.
+	MedianTimeBlocks   int
+	MaxFutureBlockTime int64
+
+	// UnbondingPeriod is how many blocks unstaked coins stay locked, and
+	// can still be slashed, before they can be spent.
+	UnbondingPeriod int
+	// StakeMaturity is how many blocks stake must be confirmed for before
+	// it counts towards drawing producers. The draw is seeded by the block
+	// that many blocks below the parent, which the parent's producer can't
+	// choose.
+	StakeMaturity int
+
+	// Checkpoints are blocks every node's chain must hold at their
+	// heights, so that no chain forking off below them is accepted.
+	Checkpoints []Checkpoint
+	// AssumeValid, when set, is a block whose ancestors are taken to have
+	// valid scripts, which speeds up syncing them. All their other rules
+	// are still checked. It isn't a checkpoint: a chain without it is
+	// accepted, with every script verified.
+	AssumeValid Checkpoint
+}
+
+var MainNetParams = NetParams{
+	Name:           "main",
+	MaxBlockSize:   1000000,
+	MaxTxSize:      100000,
+	MaxBlockSigOps: 20000,
+
+	MedianTimeBlocks:   11,
+	MaxFutureBlockTime: 2 * 60 * 60,
+
+	UnbondingPeriod: 100,
+	StakeMaturity:   10,
+
+	// Each node creates its own genesis block, so there is no chain every
+	// node shares to checkpoint.
+	Checkpoints: nil,
+}
+
+// netParams are the rules of the network the node runs on.
+var netParams = &MainNetParams
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// outOfTurnDelay is how many seconds a signer waits beyond Period for each
+// signer ahead of it in the turn.
+const outOfTurnDelay = 5
+
+// ProofOfAuthority lets a fixed list of signers produce blocks in turn, one
+// every Period seconds at most. The producer seals a block by signing its
+// hash; the genesis block is trusted as distributed and isn't signed. So that
+// the chain doesn't stall while the signer in turn is offline, the others may
+// seal the block too, each outOfTurnDelay seconds later than the one before.
+// So that no signer can take the chain over alone, one that sealed any of the
+// last len(Signers)/2 blocks may not seal the next.
+type ProofOfAuthority struct {
+	Signers []string
+	Period  int64
+
+	// nodeID names the wallets holding this node's signer keys.
+	nodeID string
+	// chain has the blocks before a parent, to find its recent signers.
+	chain blockSource
+}
+
+// blockSource reads blocks by hash. A NodeClient provides it.
+type blockSource interface {
+	GetBlock(hash []byte) (*Block, error)
+}
+
+func NewProofOfAuthority(signers []string, period int64, nodeID string, chain blockSource) (*ProofOfAuthority, error) {
+	if len(signers) == 0 {
+		return nil, errors.New("proof of authority needs at least one signer")
+	}
+	for _, signer := range signers {
+		_, err := DecodePubKeyHashAddress(signer)
+		if err != nil {
+			return nil, fmt.Errorf("signer %s: %w", signer, err)
+		}
+	}
+	if period < 0 {
+		return nil, fmt.Errorf("period %d is negative", period)
+	}
+
+	return &ProofOfAuthority{signers, period, nodeID, chain}, nil
+}
+
+func (e *ProofOfAuthority) Name() string {
+	return "proof of authority"
+}
+
+// NextProducer takes the signers round robin by height.
+func (e *ProofOfAuthority) NextProducer(parent *Block) string {
+	return e.Signers[(parent.Height+1)%len(e.Signers)]
+}
+
+// delay returns how many seconds after Period signer may seal the block after
+// parent, or false if it isn't a signer.
+func (e *ProofOfAuthority) delay(parent *Block, signer string) (int64, bool) {
+	for i, s := range e.Signers {
+		if s == signer {
+			turns := (i - (parent.Height+1)%len(e.Signers) + len(e.Signers)) % len(e.Signers)
+			return int64(turns) * outOfTurnDelay, true
+		}
+	}
+
+	return 0, false
+}
+
+// recentSigners returns the signers of parent and the blocks before it, up to
+// len(Signers)/2 blocks in all. The unsigned genesis block has none.
+func (e *ProofOfAuthority) recentSigners(parent *Block) (map[string]bool, error) {
+	recent := make(map[string]bool)
+	block := parent
+	for i := 0; i < len(e.Signers)/2; i++ {
+		if i > 0 {
+			if len(block.PrevBlockHash) == 0 {
+				break
+			}
+			var err error
+			block, err = e.chain.GetBlock(block.PrevBlockHash)
+			if err != nil {
+				return nil, fmt.Errorf("block %x: %w", parent.Hash, err)
+			}
+		}
+		if len(block.PubKey) > 0 {
+			recent[string(AddressFromPubKeyHash(HashPubKey(block.PubKey)))] = true
+		}
+	}
+
+	return recent, nil
+}
+
+// Seal signs block with the key of the earliest signer in turn this node's
+// wallets hold that didn't sign recently, once Period and that signer's delay
+// have passed since parent.
+func (e *ProofOfAuthority) Seal(ctx context.Context, parent, block *Block) error {
+	if parent == nil {
+		block.Hash = headerHash(block)
+		return nil
+	}
+
+	recent, err := e.recentSigners(parent)
+	if err != nil {
+		return err
+	}
+	signer := e.NextProducer(parent)
+	wallets, err := NewWallets(e.nodeID)
+	if err == nil {
+		for i := range e.Signers {
+			s := e.Signers[(parent.Height+1+i)%len(e.Signers)]
+			if wallets.Wallets[s] != nil && !recent[s] {
+				signer = s
+				break
+			}
+		}
+	}
+	if recent[signer] {
+		return fmt.Errorf("%w: %s signed one of the last %d blocks", ErrNotProducer, signer, len(e.Signers)/2)
+	}
+	delay, _ := e.delay(parent, signer)
+
+	return signSeal(ctx, e.nodeID, signer, e.Period+delay, parent, block)
+}
+
+func (e *ProofOfAuthority) VerifySeal(parent, block *Block) error {
+	if parent == nil {
+		return verifyHeaderHash(block)
+	}
+
+	signer := string(AddressFromPubKeyHash(HashPubKey(block.PubKey)))
+	delay, ok := e.delay(parent, signer)
+	if !ok {
+		return fmt.Errorf("%s isn't a signer", signer)
+	}
+	recent, err := e.recentSigners(parent)
+	if err != nil {
+		return err
+	}
+	if recent[signer] {
+		return fmt.Errorf("%s signed one of the last %d blocks", signer, len(e.Signers)/2)
+	}
+
+	return verifySignedSeal(signer, e.Period+delay, parent, block)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestProofOfAuthorityVerifySeal(t *testing.T) {
+	first, second, third, outsider := NewWallet(), NewWallet(), NewWallet(), NewWallet()
+	engine := &ProofOfAuthority{
+		Signers: []string{string(first.GetAddress()), string(second.GetAddress()), string(third.GetAddress())},
+		Period:  10,
+	}
+	// The second signer is in turn for the block after parent.
+	parent := &Block{Timestamp: 1000, Height: 0}
+
+	tests := []struct {
+		name   string
+		signer *Wallet
+		after  int64
+		errMsg string
+	}{
+		{"in turn", second, 10, ""},
+		{"in turn too early", second, 9, "less than 10 seconds"},
+		{"next in turn", third, 10 + outOfTurnDelay, ""},
+		{"next in turn too early", third, 10, "less than 15 seconds"},
+		{"last in turn", first, 10 + 2*outOfTurnDelay, ""},
+		{"last in turn too early", first, 10 + outOfTurnDelay, "less than 20 seconds"},
+		{"not a signer", outsider, 100, "isn't a signer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block := &Block{
+				Timestamp:    parent.Timestamp + tt.after,
+				Transactions: []*Transaction{NewCoinbaseTX(string(tt.signer.GetAddress()), "", 1, 0)},
+				Height:       parent.Height + 1,
+			}
+			block.Hash = headerHash(block)
+			block.PubKey = tt.signer.PublicKey
+			block.Signature = SignHash(tt.signer.PrivateKey, block.Hash)
+
+			checkError(t, engine.VerifySeal(parent, block), tt.errMsg)
+		})
+	}
+}
+
+// TestProofOfAuthoritySealOutOfTurn checks that a node holding only a signer
+// out of turn seals the block after that signer's delay.
+func TestProofOfAuthoritySealOutOfTurn(t *testing.T) {
+	_, wallet := newTestChain(t)
+	other := NewWallet()
+	engine, err := NewProofOfAuthority([]string{string(other.GetAddress()), string(wallet.GetAddress())}, 0, testNodeID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent := &Block{Timestamp: time.Now().Unix() - 100, Height: 1}
+	block := &Block{
+		Transactions: []*Transaction{NewCoinbaseTX(string(wallet.GetAddress()), "", 2, 0)},
+		Height:       parent.Height + 1,
+	}
+
+	err = engine.Seal(context.Background(), parent, block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer := string(AddressFromPubKeyHash(HashPubKey(block.PubKey))); signer != string(wallet.GetAddress()) {
+		t.Errorf("signed by %s", signer)
+	}
+	if want := parent.Timestamp + outOfTurnDelay; block.Timestamp != want {
+		t.Errorf("timestamp %d, want %d", block.Timestamp, want)
+	}
+	if err := engine.VerifySeal(parent, block); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestProofOfAuthorityRecentSigners rejects a block whose signer sealed one of
+// the last len(Signers)/2 blocks.
+func TestProofOfAuthorityRecentSigners(t *testing.T) {
+	wallets := []*Wallet{NewWallet(), NewWallet(), NewWallet(), NewWallet()}
+
+	tests := []struct {
+		name    string
+		signers int
+		// recent are the signers of the blocks after genesis, oldest first.
+		recent []int
+		signer int
+		errMsg string
+	}{
+		{"single signer again", 1, []int{0, 0}, 0, ""},
+		{"two signers after genesis", 2, nil, 0, ""},
+		{"two signers the same twice", 2, []int{0}, 0, "signed one of the last 1 blocks"},
+		{"two signers alternating", 2, []int{0}, 1, ""},
+		{"three signers the same twice", 3, []int{1, 0}, 0, "signed one of the last 1 blocks"},
+		{"four signers within the limit", 4, []int{0, 1}, 0, "signed one of the last 2 blocks"},
+		{"four signers beyond the limit", 4, []int{0, 1, 2}, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := &testStakeChain{blocks: make(map[string]*Block)}
+			parent := &Block{Hash: []byte("genesis"), Timestamp: 1000}
+			chain.blocks[hex.EncodeToString(parent.Hash)] = parent
+			for i, signer := range tt.recent {
+				parent = &Block{
+					Hash:          []byte(fmt.Sprintf("block %d", i+1)),
+					PrevBlockHash: parent.Hash,
+					Timestamp:     parent.Timestamp + 100,
+					Height:        i + 1,
+					PubKey:        wallets[signer].PublicKey,
+				}
+				chain.blocks[hex.EncodeToString(parent.Hash)] = parent
+			}
+
+			var signers []string
+			for _, wallet := range wallets[:tt.signers] {
+				signers = append(signers, string(wallet.GetAddress()))
+			}
+			engine, err := NewProofOfAuthority(signers, 0, testNodeID, chain)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			signer := wallets[tt.signer]
+			block := &Block{
+				Timestamp:     parent.Timestamp + 100,
+				Transactions:  []*Transaction{NewCoinbaseTX(string(signer.GetAddress()), "", parent.Height+1, 0)},
+				PrevBlockHash: parent.Hash,
+				Height:        parent.Height + 1,
+			}
+			block.Hash = headerHash(block)
+			block.PubKey = signer.PublicKey
+			block.Signature = SignHash(signer.PrivateKey, block.Hash)
+
+			checkError(t, engine.VerifySeal(parent, block), tt.errMsg)
+		})
+	}
+
+	t.Run("seal skips a recent signer", func(t *testing.T) {
+		_, wallet := newTestChain(t)
+		engine, err := NewProofOfAuthority([]string{string(wallet.GetAddress()), string(NewWallet().GetAddress())}, 0, testNodeID, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The node's signer is in turn but sealed the parent.
+		parent := &Block{Timestamp: time.Now().Unix() - 100, Height: 1, PubKey: wallet.PublicKey}
+		block := &Block{
+			Transactions: []*Transaction{NewCoinbaseTX(string(wallet.GetAddress()), "", 2, 0)},
+			Height:       parent.Height + 1,
+		}
+
+		err = engine.Seal(context.Background(), parent, block)
+		if !errors.Is(err, ErrNotProducer) {
+			t.Errorf("Seal() = %v, want ErrNotProducer", err)
+		}
+	})
+}
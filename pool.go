@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The pool speaks a stratum-like protocol: one JSON poolMessage per line.
+// Workers send mining.subscribe with their payout address and get their
+// extranonce1, then mining.submit for every share. The pool sends
+// mining.notify with a PoolJob whenever the work changes.
+const (
+	poolMethodSubscribe = "mining.subscribe"
+	poolMethodSubmit    = "mining.submit"
+	poolMethodNotify    = "mining.notify"
+
+	// Shares need 2^poolShareBits times less work than blocks.
+	poolShareBits = 8
+	// poolJobRefresh is how often jobs pick up new transactions and share
+	// counts.
+	poolJobRefresh = 30 * time.Second
+)
+
+type poolMessage struct {
+	ID     int             `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+type poolSubscribeParams struct {
+	Address string `json:"address"`
+}
+
+type poolSubscribeResult struct {
+	ExtraNonce1 uint32 `json:"extranonce1"`
+}
+
+type poolSubmitParams struct {
+	JobID       string `json:"job_id"`
+	ExtraNonce2 uint32 `json:"extranonce2"`
+	Nonce       int    `json:"nonce"`
+}
+
+// PoolJob is the work a worker hashes. The extra nonce of Coinbase is
+// extranonce1 in its upper 32 bits and the worker's extranonce2 in the lower.
+// MerkleBranch turns the coinbase into the merkle root, with "" for a node
+// paired with itself.
+type PoolJob struct {
+	JobID         string   `json:"job_id"`
+	PrevBlockHash string   `json:"prev_block_hash"`
+	Height        int      `json:"height"`
+	Timestamp     int64    `json:"timestamp"`
+	Coinbase      string   `json:"coinbase"`
+	MerkleBranch  []string `json:"merkle_branch"`
+	ShareTarget   string   `json:"share_target"`
+	Target        string   `json:"target"`
+}
+
+type poolJob struct {
+	PoolJob
+	template *BlockTemplate
+	coinbase *Transaction
+	// shares are what the coinbase pays for, per address.
+	shares    map[string]int
+	submitted map[string]bool
+}
+
+type poolConn struct {
+	conn        net.Conn
+	mu          sync.Mutex
+	extraNonce1 uint32
+	address     string
+}
+
+func (c *poolConn) send(msg poolMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err := json.NewEncoder(c.conn).Encode(msg)
+	if err != nil {
+		c.conn.Close()
+	}
+}
+
+// PoolServer lets workers mine together on the tip of bc. The coinbase of
+// each job pays the reward in proportion to the unpaid shares of each worker
+// address, and rewardAddress gets the rounding and the blocks found before
+// any share.
+type PoolServer struct {
+	bc            *BlockChain
+	rewardAddress string
+	shareTarget   *big.Int
+
+	mu             sync.Mutex
+	conns          map[*poolConn]bool
+	jobs           map[string]*poolJob
+	current        *poolJob
+	nextJobID      int
+	nextExtraNonce uint32
+	shares         map[string]int
+}
+
+func StartPoolServer(address, rewardAddress string, bc *BlockChain) {
+	if _, ok := bc.engine.(ProofOfWorkEngine); !ok {
+		log.Panicf("ERROR: A mining pool needs proof of work, not %s", bc.engine.Name())
+	}
+	shareBits := targetBits - poolShareBits
+	if shareBits < 1 {
+		shareBits = 1
+	}
+
+	p := &PoolServer{
+		bc:            bc,
+		rewardAddress: rewardAddress,
+		shareTarget:   new(big.Int).Lsh(big.NewInt(1), uint(256-shareBits)),
+		conns:         make(map[*poolConn]bool),
+		jobs:          make(map[string]*poolJob),
+		shares:        make(map[string]int),
+	}
+
+	ln, err := net.Listen(protocol, address)
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Mining pool listening on %s\n", ln.Addr())
+
+	p.mu.Lock()
+	p.newJob()
+	p.mu.Unlock()
+	go p.refreshJobs()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				log.Panic(err)
+			}
+			go p.handleConn(conn)
+		}
+	}()
+}
+
+// refreshJobs replaces the job when the tip moves, and every poolJobRefresh.
+func (p *PoolServer) refreshJobs() {
+	events := notifier.Subscribe()
+	ticker := time.NewTicker(poolJobRefresh)
+
+	for {
+		select {
+		case e := <-events:
+			if e.Type != EventBlockConnected {
+				continue
+			}
+		case <-ticker.C:
+		}
+
+		p.mu.Lock()
+		job := p.newJob()
+		conns := make([]*poolConn, 0, len(p.conns))
+		for c := range p.conns {
+			conns = append(conns, c)
+		}
+		p.mu.Unlock()
+
+		for _, c := range conns {
+			p.notify(c, job)
+		}
+	}
+}
+
+// newJob makes the job for the tip the current one. p.mu must be held.
+func (p *PoolServer) newJob() *poolJob {
+	shares := make(map[string]int, len(p.shares))
+	for address, n := range p.shares {
+		shares[address] = n
+	}
+	// The coinbase pays each address with shares and the reward address
+	// at most once, and the amounts don't change its size.
+	payees := []Payment{{p.rewardAddress, 0}}
+	for address := range shares {
+		payees = append(payees, Payment{address, 0})
+	}
+	coinbaseSize := NewCoinbaseTXPayments(payees, "Mining pool reward", 0).Size()
+	template := NewExternalBlockTemplate(p.bc, mempool, coinbaseSize)
+	coinbase := NewCoinbaseTXPayments(p.payouts(template.CoinbaseValue, shares), "Mining pool reward", template.Height)
+
+	var branch []string
+	for _, hash := range template.MerkleBranch {
+		branch = append(branch, hex.EncodeToString(hash))
+	}
+
+	p.nextJobID++
+	job := &poolJob{
+		PoolJob: PoolJob{
+			JobID:         strconv.Itoa(p.nextJobID),
+			PrevBlockHash: hex.EncodeToString(template.PrevBlockHash),
+			Height:        template.Height,
+			Timestamp:     template.Timestamp,
+			Coinbase:      hex.EncodeToString(coinbase.Serialize()),
+			MerkleBranch:  branch,
+			ShareTarget:   hex.EncodeToString(p.shareTarget.FillBytes(make([]byte, 32))),
+			Target:        hex.EncodeToString(template.Target),
+		},
+		template:  template,
+		coinbase:  coinbase,
+		shares:    shares,
+		submitted: make(map[string]bool),
+	}
+
+	// Shares for an older tip can't make a block any more.
+	if p.current != nil && !bytes.Equal(p.current.template.PrevBlockHash, template.PrevBlockHash) {
+		p.jobs = make(map[string]*poolJob)
+	}
+	p.jobs[job.JobID] = job
+	p.current = job
+
+	return job
+}
+
+// payouts splits reward in proportion to shares, sorted by address so that
+// every job with the same shares has the same coinbase.
+func (p *PoolServer) payouts(reward int, shares map[string]int) []Payment {
+	total := 0
+	addresses := make([]string, 0, len(shares))
+	for address, n := range shares {
+		total += n
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	var payments []Payment
+	paid := 0
+	for _, address := range addresses {
+		amount := reward * shares[address] / total
+		if amount > 0 {
+			payments = append(payments, Payment{address, amount})
+			paid += amount
+		}
+	}
+	if paid < reward {
+		payments = append(payments, Payment{p.rewardAddress, reward - paid})
+	}
+
+	return payments
+}
+
+func (p *PoolServer) handleConn(conn net.Conn) {
+	c := &poolConn{conn: conn}
+	defer func() {
+		p.mu.Lock()
+		delete(p.conns, c)
+		p.mu.Unlock()
+		conn.Close()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var msg poolMessage
+		err := json.Unmarshal(scanner.Bytes(), &msg)
+		if err != nil {
+			c.send(poolMessage{Error: fmt.Sprintf("invalid message: %s", err)})
+			return
+		}
+
+		var result any
+		switch msg.Method {
+		case poolMethodSubscribe:
+			var params poolSubscribeParams
+			err = json.Unmarshal(msg.Params, &params)
+			if err == nil {
+				result, err = p.subscribe(c, params.Address)
+			}
+		case poolMethodSubmit:
+			var params poolSubmitParams
+			err = json.Unmarshal(msg.Params, &params)
+			if err == nil {
+				err = p.submit(c, params)
+				result = err == nil
+			}
+		default:
+			err = fmt.Errorf("unknown method %q", msg.Method)
+		}
+
+		reply := poolMessage{ID: msg.ID}
+		if err != nil {
+			reply.Error = err.Error()
+		} else {
+			reply.Result, _ = json.Marshal(result)
+		}
+		c.send(reply)
+
+		if msg.Method == poolMethodSubscribe && err == nil {
+			p.mu.Lock()
+			job := p.current
+			p.mu.Unlock()
+			p.notify(c, job)
+		}
+	}
+}
+
+func (p *PoolServer) notify(c *poolConn, job *poolJob) {
+	params, err := json.Marshal(job.PoolJob)
+	if err != nil {
+		log.Panic(err)
+	}
+	c.send(poolMessage{Method: poolMethodNotify, Params: params})
+}
+
+func (p *PoolServer) subscribe(c *poolConn, address string) (*poolSubscribeResult, error) {
+	if !ValidateAddress(address) {
+		return nil, fmt.Errorf("invalid address %s", address)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c.address != "" {
+		return nil, errors.New("already subscribed")
+	}
+
+	p.nextExtraNonce++
+	c.address = address
+	c.extraNonce1 = p.nextExtraNonce
+	p.conns[c] = true
+	fmt.Printf("Pool worker %s subscribed with extranonce1 %d\n", address, c.extraNonce1)
+
+	return &poolSubscribeResult{c.extraNonce1}, nil
+}
+
+// submit credits a share to the worker's address, and connects the block
+// when the share meets the block target too.
+func (p *PoolServer) submit(c *poolConn, params poolSubmitParams) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if c.address == "" {
+		return errors.New("not subscribed")
+	}
+	job := p.jobs[params.JobID]
+	if job == nil {
+		return fmt.Errorf("job %s is stale", params.JobID)
+	}
+	key := fmt.Sprintf("%d:%d:%d", c.extraNonce1, params.ExtraNonce2, params.Nonce)
+	if job.submitted[key] {
+		return errors.New("duplicate share")
+	}
+
+	extraNonce := uint64(c.extraNonce1)<<32 | uint64(params.ExtraNonce2)
+	block := job.template.NewBlock(job.coinbase.WithExtraNonce(extraNonce))
+	block.Nonce = params.Nonce
+	pow := NewProofOfWork(block)
+	hash := sha256.Sum256(pow.prepareData(block.Nonce))
+	hashInt := new(big.Int).SetBytes(hash[:])
+	if hashInt.Cmp(p.shareTarget) != -1 {
+		return errors.New("share is above the target")
+	}
+
+	job.submitted[key] = true
+	p.shares[c.address]++
+
+	if hashInt.Cmp(pow.target) == -1 {
+		block.Hash = hash[:]
+		err := submitBlock(p.bc, block)
+		if err != nil {
+			fmt.Printf("Pool block rejected: %s\n", err)
+			return nil
+		}
+
+		for address, n := range job.shares {
+			p.shares[address] -= n
+			if p.shares[address] <= 0 {
+				delete(p.shares, address)
+			}
+		}
+		fmt.Printf("Pool found block %x, paying %d outputs\n", block.Hash, len(job.coinbase.Vout))
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPoolPayouts(t *testing.T) {
+	p := &PoolServer{rewardAddress: "pool"}
+
+	tests := []struct {
+		name   string
+		reward int
+		shares map[string]int
+		want   []Payment
+	}{
+		{"no shares", 10, nil, []Payment{{"pool", 10}}},
+		{"single worker", 10, map[string]int{"a": 7}, []Payment{{"a", 10}}},
+		{"even split", 10, map[string]int{"a": 1, "b": 1}, []Payment{{"a", 5}, {"b", 5}}},
+		{"remainder to the pool", 10, map[string]int{"a": 1, "b": 2}, []Payment{{"a", 3}, {"b", 6}, {"pool", 1}}},
+		{"share worth less than a unit", 10, map[string]int{"a": 1, "b": 99}, []Payment{{"b", 9}, {"pool", 1}}},
+		{"sorted by address", 12, map[string]int{"c": 1, "a": 1, "b": 1}, []Payment{{"a", 4}, {"b", 4}, {"c", 4}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.payouts(tt.reward, tt.shares); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("payouts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("pays out the whole reward", func(t *testing.T) {
+		for reward := 1; reward <= 200; reward += 7 {
+			shares := map[string]int{"a": reward%5 + 1, "b": 3, "c": reward%11 + 1, "d": 13}
+			total := 0
+			for _, n := range shares {
+				total += n
+			}
+
+			paid := 0
+			for _, payment := range p.payouts(reward, shares) {
+				if payment.Amount <= 0 {
+					t.Errorf("reward %d pays %d to %s", reward, payment.Amount, payment.Address)
+				}
+				if payment.Address != p.rewardAddress && payment.Amount > reward*shares[payment.Address]/total {
+					t.Errorf("reward %d pays %s %d, more than its %d of %d shares", reward, payment.Address, payment.Amount, shares[payment.Address], total)
+				}
+				paid += payment.Amount
+			}
+			if paid != reward {
+				t.Errorf("reward %d pays %d in total", reward, paid)
+			}
+		}
+	})
+}
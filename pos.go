@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/boltdb/bolt"
+)
+
+// ProofOfStake lets the owners of stake outputs produce blocks, each block
+// drawing its producer from the mature stake bonded as of its parent,
+// weighted by amount. The draw is seeded by the block StakeMaturity blocks
+// below the parent and the height, so that no producer can grind the block it
+// seals to pick the next one. The producer seals a block by signing its hash,
+// as with proof of authority. A producer signing two blocks on the same
+// parent can be slashed with the two as evidence. A chain with no stake left
+// bonded can't grow.
+type ProofOfStake struct {
+	Period int64
+
+	nodeID string
+	chain  stakeChain
+	// db, if not nil, keeps signed across restarts.
+	db *bolt.DB
+
+	mu sync.Mutex
+	// producers caches the producer drawn for the last parents asked about.
+	producers map[string]string
+	// seals holds the last seal seen on each parent, to catch double
+	// signing.
+	seals map[string]*Block
+
+	// signMu is held while signing, which waits for Period; signed is the
+	// parent of the last block this node signed.
+	signMu sync.Mutex
+	signed []byte
+}
+
+// sealHistory is how many parents ProofOfStake remembers the producers and
+// seals of.
+const sealHistory = 100
+
+// signedKey in the consensus bucket holds the parent of the last block this
+// node signed with proof of stake.
+var signedKey = []byte("signed")
+
+func NewProofOfStake(period int64, nodeID string, chain stakeChain, db *bolt.DB) (*ProofOfStake, error) {
+	if period < 0 {
+		return nil, fmt.Errorf("period %d is negative", period)
+	}
+
+	e := &ProofOfStake{
+		Period:    period,
+		nodeID:    nodeID,
+		chain:     chain,
+		db:        db,
+		producers: make(map[string]string),
+		seals:     make(map[string]*Block),
+	}
+	if db != nil {
+		err := db.View(func(tx *bolt.Tx) error {
+			if b := tx.Bucket([]byte(consensusBucket)); b != nil {
+				e.signed = append([]byte{}, b.Get(signedKey)...)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return e, nil
+}
+
+func (e *ProofOfStake) Name() string {
+	return "proof of stake"
+}
+
+// NextProducer returns "" when no stake is bonded as of parent, or when the
+// stakes of parent can't be read.
+func (e *ProofOfStake) NextProducer(parent *Block) string {
+	key := hex.EncodeToString(parent.Hash)
+
+	e.mu.Lock()
+	producer, ok := e.producers[key]
+	e.mu.Unlock()
+	if ok {
+		return producer
+	}
+
+	stakes, err := e.chain.ListStakes(parent.Hash)
+	if err != nil {
+		return ""
+	}
+	seed, err := e.seed(parent)
+	if err != nil {
+		return ""
+	}
+	producer = selectProducer(Validators(matureStakes(stakes, parent.Height+1)), seed)
+
+	e.mu.Lock()
+	if len(e.producers) >= sealHistory {
+		e.producers = make(map[string]string)
+	}
+	e.producers[key] = producer
+	e.mu.Unlock()
+
+	return producer
+}
+
+// seed returns what the producer of the block after parent is drawn with:
+// the hash of the block StakeMaturity blocks below parent, or of the genesis
+// block, and the height of the block drawn for.
+func (e *ProofOfStake) seed(parent *Block) ([]byte, error) {
+	block := parent
+	for i := 0; i < netParams.StakeMaturity && len(block.PrevBlockHash) > 0; i++ {
+		var err error
+		block, err = e.chain.GetBlock(block.PrevBlockHash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return append(append([]byte{}, block.Hash...), IntToHex(int64(parent.Height+1))...), nil
+}
+
+// Seal signs block with the key of the producer drawn for parent, if this
+// node's wallets hold it, once Period has passed since parent. It signs a
+// single block on each parent, even across restarts, so that it is never
+// slashed.
+func (e *ProofOfStake) Seal(ctx context.Context, parent, block *Block) error {
+	if parent == nil {
+		block.Hash = headerHash(block)
+		return nil
+	}
+
+	producer := e.NextProducer(parent)
+	if producer == "" {
+		return fmt.Errorf("%w: no stake is bonded", ErrNotProducer)
+	}
+
+	e.signMu.Lock()
+	defer e.signMu.Unlock()
+	if bytes.Equal(e.signed, parent.Hash) {
+		return fmt.Errorf("a block on %x is already signed", parent.Hash)
+	}
+	err := signSeal(ctx, e.nodeID, producer, e.Period, parent, block)
+	if err != nil {
+		return err
+	}
+	e.signed = parent.Hash
+	if e.db == nil {
+		return nil
+	}
+
+	return e.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(consensusBucket))
+		if err != nil {
+			return err
+		}
+		return b.Put(signedKey, parent.Hash)
+	})
+}
+
+func (e *ProofOfStake) VerifySeal(parent, block *Block) error {
+	if parent == nil {
+		return verifyHeaderHash(block)
+	}
+
+	producer := e.NextProducer(parent)
+	if producer == "" {
+		return errors.New("no stake is bonded")
+	}
+
+	return verifySignedSeal(producer, e.Period, parent, block)
+}
+
+// DoubleSign remembers the seal of block, which must be valid, and returns
+// the evidence against its producer if it has sealed another block on the
+// same parent.
+func (e *ProofOfStake) DoubleSign(block *Block) *DoubleSign {
+	key := hex.EncodeToString(block.PrevBlockHash)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	other := e.seals[key]
+	if other != nil && !bytes.Equal(other.Hash, block.Hash) {
+		return NewDoubleSign(other, block)
+	}
+
+	if len(e.seals) >= sealHistory {
+		for k, b := range e.seals {
+			if b.Height < block.Height-sealHistory {
+				delete(e.seals, k)
+			}
+		}
+	}
+	e.seals[key] = block
+
+	return nil
+}
+
+// reportDoubleSign sends a transaction slashing the producer of block, which
+// must be valid, if it has signed another block on the same parent.
+func reportDoubleSign(bc *BlockChain, block *Block) {
+	pos, ok := bc.engine.(*ProofOfStake)
+	if !ok {
+		return
+	}
+	evidence := pos.DoubleSign(block)
+	if evidence == nil {
+		return
+	}
+
+	producer := string(AddressFromPubKeyHash(HashPubKey(evidence.PubKey)))
+	fmt.Printf("%s signed two blocks on %x\n", producer, block.PrevBlockHash)
+
+	stakes, err := bc.FindStakes(bc.Tip())
+	if err != nil {
+		log.Panic(err)
+	}
+	var slashed []Stake
+	for _, stake := range stakes {
+		if stake.Address == producer && evidenceCovers(stake.Height, stake.Unbonding, block.Height-1) &&
+			!mempool.IsSpent(stake.TxID, stake.Index) {
+			slashed = append(slashed, stake)
+		}
+	}
+	if len(slashed) == 0 {
+		fmt.Printf("%s has no stake left to slash\n", producer)
+		return
+	}
+
+	tx := NewSlashTransaction(evidence, slashed)
+	err = processTx(bc, *tx, nodeAddress)
+	if err != nil {
+		return
+	}
+	if nodeAddress != knownNodes[0] {
+		sendTx(knownNodes[0], tx)
+	}
+	fmt.Printf("Slashing %d stake outputs of %s in transaction %x\n", len(slashed), producer, tx.ID)
+}
@@ -3,14 +3,14 @@ package main
 import (
 	"bytes"
 	"crypto/sha256"
-	"fmt"
 	"math"
 	"math/big"
 )
 
 const (
 	targetBits = 24
-	maxNonce   = math.MaxInt64
+	// maxNonce bounds the nonces tried for each extra nonce.
+	maxNonce = math.MaxUint32
 )
 
 type ProofOfWork struct {
@@ -27,40 +27,29 @@ func NewProofOfWork(b *Block) *ProofOfWork {
 	return pow
 }
 
-func (pow *ProofOfWork) prepareData(nonce int) []byte {
-	data := bytes.Join([][]byte{
-		pow.block.PrevBlockHash,
-		pow.block.HashTransactions(),
-		IntToHex(pow.block.Timestamp),
+// prepareHeader returns the part of the hashed data that doesn't depend on
+// the nonce, so that miners compute the merkle root once.
+func (pow *ProofOfWork) prepareHeader() []byte {
+	return blockHeader(pow.block.PrevBlockHash, pow.block.HashTransactions(), pow.block.Timestamp)
+}
+
+// blockHeader is prepareHeader for a miner that knows only the merkle root of
+// the block's transactions.
+func blockHeader(prevBlockHash, merkleRoot []byte, timestamp int64) []byte {
+	return bytes.Join([][]byte{
+		prevBlockHash,
+		merkleRoot,
+		IntToHex(timestamp),
 		IntToHex(int64(targetBits)),
-		IntToHex(int64(nonce)),
 	}, []byte{})
-
-	return data
 }
 
-func (pow *ProofOfWork) Run() (int, []byte) {
-	var hashInt big.Int
-	var hash [32]byte
-	nonce := 0
-
-	fmt.Println("Mining a new block")
-	for nonce < maxNonce {
-		data := pow.prepareData(nonce)
-		hash = sha256.Sum256(data)
-		fmt.Printf("\r%x", hash)
-		hashInt.SetBytes(hash[:])
-
-		if hashInt.Cmp(pow.target) == -1 {
-			break
-		}
-		nonce++
-	}
-	fmt.Print("\n\n")
-
-	return nonce, hash[:]
+func (pow *ProofOfWork) prepareData(nonce int) []byte {
+	return append(pow.prepareHeader(), IntToHex(int64(nonce))...)
 }
 
+// Validate checks that the block hashes to its Hash and that the hash is
+// below the target.
 func (pow *ProofOfWork) Validate() bool {
 	var hashInt big.Int
 
@@ -68,7 +57,7 @@ func (pow *ProofOfWork) Validate() bool {
 	hash := sha256.Sum256(data)
 	hashInt.SetBytes(hash[:])
 
-	isValid := hashInt.Cmp(pow.target) == -1
+	isValid := hashInt.Cmp(pow.target) == -1 && bytes.Equal(hash[:], pow.block.Hash)
 
 	return isValid
 }
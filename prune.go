@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+
+	"github.com/boltdb/bolt"
+)
+
+// minPruneDepth is how many blocks below the tip always keep their bodies and
+// undo data, so that reorganizations that deep can still be undone.
+const minPruneDepth = 288
+
+// prunedHeightKey in the block status bucket holds the height up to which the
+// main chain's blocks have been pruned.
+var prunedHeightKey = []byte("p")
+
+// PrunePolicy says which old blocks a pruning node deletes the bodies and undo
+// data of. Their headers, the UTXO set and the transaction index are kept.
+type PrunePolicy struct {
+	// Depth prunes the blocks this many or more below the tip; zero
+	// doesn't.
+	Depth int
+	// Budget prunes the oldest blocks until the bodies and undo data kept
+	// take at most this many bytes; zero doesn't.
+	Budget int
+}
+
+func (p PrunePolicy) Enabled() bool {
+	return p.Depth > 0 || p.Budget > 0
+}
+
+// prunePolicy is the policy of the running node.
+var prunePolicy PrunePolicy
+
+// Pruned reports whether the body of block was pruned, leaving its header.
+// Every block has a coinbase, so only a pruned block has no transactions.
+func (b *Block) Pruned() bool {
+	return len(b.Transactions) == 0
+}
+
+// PrunedHeight returns the height up to which the main chain's blocks are
+// pruned, zero when none are. The genesis block is never pruned.
+func (bc *BlockChain) PrunedHeight() int {
+	height := 0
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		height = prunedHeight(tx)
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return height
+}
+
+func prunedHeight(tx *bolt.Tx) int {
+	b := tx.Bucket([]byte(blockStatusBucket))
+	if b == nil {
+		return 0
+	}
+	height := b.Get(prunedHeightKey)
+	if height == nil {
+		return 0
+	}
+
+	return int(binary.BigEndian.Uint64(height))
+}
+
+// Prune deletes the bodies and undo data of the main chain's blocks that
+// policy no longer keeps, oldest first, and returns how many it pruned. The
+// minPruneDepth blocks below the tip and blocks off the main chain are left
+// alone.
+func (bc *BlockChain) Prune(policy PrunePolicy) (int, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	pruned := 0
+
+	err := bc.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		undos := tx.Bucket([]byte(undoBucket))
+		if undos == nil {
+			return nil
+		}
+		height := prunedHeight(tx)
+
+		// Collect the blocks not pruned yet, newest first, and what
+		// they take.
+		var blocks []*Block
+		var sizes []int
+		total := 0
+		for hash := b.Get([]byte("l")); ; {
+			data := b.Get(hash)
+			block := DeserializeBlock(data)
+			if block.Height <= height || len(block.PrevBlockHash) == 0 {
+				break
+			}
+			size := len(data) + len(undos.Get(hash))
+			blocks = append(blocks, block)
+			sizes = append(sizes, size)
+			total += size
+			hash = block.PrevBlockHash
+		}
+		if len(blocks) == 0 {
+			return nil
+		}
+		tipHeight := blocks[0].Height
+
+		for i := len(blocks) - 1; i >= 0; i-- {
+			block := blocks[i]
+			depth := tipHeight - block.Height
+			if depth < minPruneDepth {
+				break
+			}
+			beyondDepth := policy.Depth > 0 && depth >= policy.Depth
+			overBudget := policy.Budget > 0 && total > policy.Budget
+			if !beyondDepth && !overBudget {
+				break
+			}
+
+			header := *block
+			header.Transactions = nil
+			err := b.Put(block.Hash, header.Serialize())
+			if err != nil {
+				return err
+			}
+			err = undos.Delete(block.Hash)
+			if err != nil {
+				return err
+			}
+			total -= sizes[i]
+			height = block.Height
+			pruned++
+		}
+		if pruned == 0 {
+			return nil
+		}
+
+		status, err := tx.CreateBucketIfNotExists([]byte(blockStatusBucket))
+		if err != nil {
+			return err
+		}
+		return status.Put(prunedHeightKey, IntToHex(int64(height)))
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return pruned, nil
+}
+
+// forkHeight returns the height of the last main chain block block descends
+// from.
+func (bc *BlockChain) forkHeight(block *Block) int {
+	var connected []*Block
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		connected, _ = findReorg(b, DeserializeBlock(b.Get(bc.Tip())), block)
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	if len(connected) == 0 {
+		return block.Height
+	}
+
+	return connected[0].Height - 1
+}
+
+// pruneBlocks prunes the chain by the policy of the running node, if it has
+// one.
+func pruneBlocks(bc *BlockChain) {
+	if !prunePolicy.Enabled() {
+		return
+	}
+
+	pruned, err := bc.Prune(prunePolicy)
+	if err != nil {
+		log.Panic(err)
+	}
+	if pruned > 0 {
+		fmt.Printf("Pruned %d blocks up to height %d\n", pruned, bc.PrunedHeight())
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// NewFeeBumpTransaction rebuilds tx from the same inputs and outputs to pay
+// fee instead, taking the difference from its change output, the last one
+// locked to the key of wallet. tx must signal replaceability and prevTXs must
+// hold the transactions it spends. The result is signed by wallet.
+func NewFeeBumpTransaction(tx *Transaction, prevTXs map[string]Transaction, fee int, wallet *Wallet) (*Transaction, error) {
+	if !tx.SignalsReplacement() {
+		return nil, errors.New("transaction doesn't signal replaceability")
+	}
+
+	oldFee := tx.Fee(prevTXs)
+	if fee <= oldFee {
+		return nil, fmt.Errorf("new fee %d doesn't exceed the current fee %d", fee, oldFee)
+	}
+
+	pubKeyHash := HashPubKey(wallet.PublicKey)
+	change := -1
+	for i, out := range tx.Vout {
+		if out.IsLockedWithKey(pubKeyHash) {
+			change = i
+		}
+	}
+	if change < 0 {
+		return nil, errors.New("transaction has no change output to pay the higher fee from")
+	}
+
+	bumped := Transaction{nil, make([]TXInput, len(tx.Vin)), make([]TXOutput, len(tx.Vout)), tx.LockTime}
+	for i, vin := range tx.Vin {
+		bumped.Vin[i] = TXInput{vin.Txid, vin.Vout, nil, vin.Sequence}
+	}
+	copy(bumped.Vout, tx.Vout)
+
+	bumped.Vout[change].Value -= fee - oldFee
+	if bumped.Vout[change].Value < 0 {
+		return nil, fmt.Errorf("change of %d can't pay %d more in fees", tx.Vout[change].Value, fee-oldFee)
+	}
+	if bumped.Vout[change].Value == 0 {
+		bumped.Vout = append(bumped.Vout[:change], bumped.Vout[change+1:]...)
+	}
+
+	bumped.ID = bumped.Hash()
+	bumped.Sign(wallet.PrivateKey, prevTXs)
+
+	return &bumped, nil
+}
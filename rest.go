@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+type BlockJSON struct {
+	Hash          string            `json:"hash"`
+	PrevBlockHash string            `json:"prev_block_hash"`
+	Height        int               `json:"height"`
+	Timestamp     int64             `json:"timestamp"`
+	Nonce         int               `json:"nonce"`
+	TxCount       int               `json:"tx_count"`
+	Transactions  []TransactionJSON `json:"transactions"`
+	Page          Page              `json:"page"`
+}
+
+type TransactionJSON struct {
+	ID        string       `json:"id"`
+	Coinbase  bool         `json:"coinbase"`
+	BlockHash string       `json:"block_hash,omitempty"`
+	Height    *int         `json:"height,omitempty"`
+	LockTime  int64        `json:"lock_time"`
+	Inputs    []InputJSON  `json:"inputs"`
+	Outputs   []OutputJSON `json:"outputs"`
+}
+
+type InputJSON struct {
+	TxID         string `json:"txid"`
+	Vout         int    `json:"vout"`
+	ScriptSig    string `json:"script_sig"`
+	ScriptSigAsm string `json:"script_sig_asm,omitempty"`
+	Sequence     uint32 `json:"sequence"`
+}
+
+type OutputJSON struct {
+	Index        int    `json:"index"`
+	Value        int    `json:"value"`
+	ScriptPubKey string `json:"script_pubkey"`
+	ScriptAsm    string `json:"script_asm"`
+	ScriptType   string `json:"script_type"`
+	Address      string `json:"address,omitempty"`
+}
+
+type UTXOJSON struct {
+	TxID         string `json:"txid"`
+	Vout         int    `json:"vout"`
+	Value        int    `json:"value"`
+	ScriptPubKey string `json:"script_pubkey"`
+	Address      string `json:"address,omitempty"`
+}
+
+type ChainInfoJSON struct {
+	BestHeight    int    `json:"best_height"`
+	BestBlockHash string `json:"best_block_hash"`
+	Consensus     string `json:"consensus"`
+	// TargetBits is only set under proof of work.
+	TargetBits  int `json:"target_bits,omitempty"`
+	MempoolSize int `json:"mempool_size"`
+}
+
+type Page struct {
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+	Total  int `json:"total"`
+}
+
+type PagedJSON struct {
+	Items any  `json:"items"`
+	Page  Page `json:"page"`
+}
+
+type ErrorJSON struct {
+	Error string `json:"error"`
+}
+
+func NewBlockJSON(b *Block, page Page) BlockJSON {
+	page.Total = len(b.Transactions)
+	txs := []TransactionJSON{}
+	start, end := page.bounds()
+	for _, tx := range b.Transactions[start:end] {
+		txs = append(txs, NewTransactionJSON(tx, b))
+	}
+
+	return BlockJSON{
+		Hash:          hex.EncodeToString(b.Hash),
+		PrevBlockHash: hex.EncodeToString(b.PrevBlockHash),
+		Height:        b.Height,
+		Timestamp:     b.Timestamp,
+		Nonce:         b.Nonce,
+		TxCount:       len(b.Transactions),
+		Transactions:  txs,
+		Page:          page,
+	}
+}
+
+// NewTransactionJSON renders tx; b is the block containing it, or nil for
+// unconfirmed transactions.
+func NewTransactionJSON(tx *Transaction, b *Block) TransactionJSON {
+	txJSON := TransactionJSON{
+		ID:       hex.EncodeToString(tx.ID),
+		Coinbase: tx.IsCoinbase(),
+		LockTime: tx.LockTime,
+		Inputs:   []InputJSON{},
+		Outputs:  []OutputJSON{},
+	}
+	if b != nil {
+		height := b.Height
+		txJSON.BlockHash = hex.EncodeToString(b.Hash)
+		txJSON.Height = &height
+	}
+
+	for _, in := range tx.Vin {
+		inJSON := InputJSON{
+			TxID:      hex.EncodeToString(in.Txid),
+			Vout:      in.Vout,
+			ScriptSig: hex.EncodeToString(in.ScriptSig),
+			Sequence:  in.Sequence,
+		}
+		if !tx.IsCoinbase() {
+			inJSON.ScriptSigAsm = DisasmScript(in.ScriptSig)
+		}
+		txJSON.Inputs = append(txJSON.Inputs, inJSON)
+	}
+
+	for i, out := range tx.Vout {
+		txJSON.Outputs = append(txJSON.Outputs, OutputJSON{
+			Index:        i,
+			Value:        out.Value,
+			ScriptPubKey: hex.EncodeToString(out.ScriptPubKey),
+			ScriptAsm:    DisasmScript(out.ScriptPubKey),
+			ScriptType:   ClassifyScript(out.ScriptPubKey).String(),
+			Address:      out.Address(),
+		})
+	}
+
+	return txJSON
+}
+
+func NewUTXOJSON(utxo UTXO) UTXOJSON {
+	return UTXOJSON{
+		TxID:         hex.EncodeToString(utxo.TxID),
+		Vout:         utxo.Index,
+		Value:        utxo.Output.Value,
+		ScriptPubKey: hex.EncodeToString(utxo.Output.ScriptPubKey),
+		Address:      utxo.Output.Address(),
+	}
+}
+
+// parsePage reads the offset and limit query parameters.
+func parsePage(r *http.Request) (Page, error) {
+	page := Page{0, defaultPageLimit, 0}
+
+	if v := r.URL.Query().Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return page, fmt.Errorf("invalid offset %q", v)
+		}
+		page.Offset = offset
+	}
+
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxPageLimit {
+			return page, fmt.Errorf("invalid limit %q (1-%d)", v, maxPageLimit)
+		}
+		page.Limit = limit
+	}
+
+	return page, nil
+}
+
+// bounds returns the slice indices of the page within Total items.
+func (p Page) bounds() (int, int) {
+	start := min(p.Offset, p.Total)
+	end := min(start+p.Limit, p.Total)
+
+	return start, end
+}
+
+type restServer struct {
+	bc *BlockChain
+}
+
+func StartRESTServer(address string, bc *BlockChain) {
+	s := &restServer{bc}
+	mux := http.NewServeMux()
+	s.register(mux)
+
+	fmt.Printf("REST API listening on %s\n", address)
+	go func() {
+		err := http.ListenAndServe(address, mux)
+		if err != nil {
+			log.Panic(err)
+		}
+	}()
+}
+
+func (s *restServer) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /block/{hash}", s.handleBlock)
+	mux.HandleFunc("GET /block/height/{n}", s.handleBlockByHeight)
+	mux.HandleFunc("GET /tx/{id}", s.handleTransaction)
+	mux.HandleFunc("GET /address/{addr}/utxos", s.handleAddressUTXOs)
+	mux.HandleFunc("GET /mempool", s.handleMempool)
+	mux.HandleFunc("GET /chaininfo", s.handleChainInfo)
+	mux.HandleFunc("GET /events", s.handleEvents)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Println("Failed to write response: ", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, ErrorJSON{err.Error()})
+}
+
+func (s *restServer) handleBlock(w http.ResponseWriter, r *http.Request) {
+	page, err := parsePage(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	hash, err := hex.DecodeString(r.PathValue("hash"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	block, err := s.bc.GetBlock(hash)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, NewBlockJSON(&block, page))
+}
+
+func (s *restServer) handleBlockByHeight(w http.ResponseWriter, r *http.Request) {
+	page, err := parsePage(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	height, err := strconv.Atoi(r.PathValue("n"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	block, err := s.bc.GetBlockByHeight(height)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, NewBlockJSON(&block, page))
+}
+
+func (s *restServer) handleTransaction(w http.ResponseWriter, r *http.Request) {
+	id, err := hex.DecodeString(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if tx, ok := mempool.Get(id); ok {
+		writeJSON(w, http.StatusOK, NewTransactionJSON(&tx, nil))
+		return
+	}
+
+	block, err := s.bc.FindTransactionBlock(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	if block.Pruned() {
+		writeError(w, http.StatusGone, fmt.Errorf("block %x is pruned", block.Hash))
+		return
+	}
+	for _, tx := range block.Transactions {
+		if bytes.Equal(tx.ID, id) {
+			writeJSON(w, http.StatusOK, NewTransactionJSON(tx, block))
+			return
+		}
+	}
+
+	writeError(w, http.StatusNotFound, errors.New("Transaction is not found"))
+}
+
+func (s *restServer) handleAddressUTXOs(w http.ResponseWriter, r *http.Request) {
+	page, err := parsePage(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	address := r.PathValue("addr")
+	if !ValidateAddress(address) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid address %s", address))
+		return
+	}
+
+	UTXOSet := UTXOSet{s.bc}
+	UTXOs := UTXOSet.FindUnspentOutputs(address)
+
+	page.Total = len(UTXOs)
+	start, end := page.bounds()
+	items := []UTXOJSON{}
+	for _, utxo := range UTXOs[start:end] {
+		items = append(items, NewUTXOJSON(utxo))
+	}
+
+	writeJSON(w, http.StatusOK, PagedJSON{items, page})
+}
+
+func (s *restServer) handleMempool(w http.ResponseWriter, r *http.Request) {
+	page, err := parsePage(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	txs := mempool.Transactions()
+
+	page.Total = len(txs)
+	start, end := page.bounds()
+	items := []TransactionJSON{}
+	for _, tx := range txs[start:end] {
+		items = append(items, NewTransactionJSON(&tx, nil))
+	}
+
+	writeJSON(w, http.StatusOK, PagedJSON{items, page})
+}
+
+func (s *restServer) handleChainInfo(w http.ResponseWriter, r *http.Request) {
+	tip, err := s.bc.GetBlock(s.bc.Tip())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	info := ChainInfoJSON{
+		BestHeight:    tip.Height,
+		BestBlockHash: hex.EncodeToString(tip.Hash),
+		Consensus:     s.bc.engine.Name(),
+		MempoolSize:   mempool.Len(),
+	}
+	if _, ok := s.bc.engine.(ProofOfWorkEngine); ok {
+		info.TargetBits = targetBits
+	}
+
+	writeJSON(w, http.StatusOK, info)
+}
+
+// handleEvents streams chain and mempool events as server-sent events.
+// Repeated `type` parameters restrict the event types; repeated `address`
+// parameters restrict address_received events to the watched addresses.
+func (s *restServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+
+	types := make(map[string]bool)
+	for _, t := range r.URL.Query()["type"] {
+		types[t] = true
+	}
+	addresses := make(map[string]bool)
+	for _, address := range r.URL.Query()["address"] {
+		if !ValidateAddress(address) {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid address %s", address))
+			return
+		}
+		addresses[address] = true
+	}
+
+	events := notifier.Subscribe()
+	defer notifier.Unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-events:
+			if len(types) > 0 && !types[e.Type] {
+				continue
+			}
+			if e.Type == EventAddressReceived && len(addresses) > 0 && !addresses[e.Address] {
+				continue
+			}
+
+			data, err := json.Marshal(e)
+			if err != nil {
+				log.Panic(err)
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+			flusher.Flush()
+		}
+	}
+}
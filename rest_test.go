@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRESTTransaction(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	mux := http.NewServeMux()
+	(&restServer{bc}).register(mux)
+
+	confirmed := newTestBlock(tipBlock(t, bc), wallet)
+	addTestBlock(t, bc, confirmed)
+	pruned := newTestBlock(confirmed, wallet)
+	addTestBlock(t, bc, pruned)
+	pruneTestBlock(t, bc, pruned)
+
+	tests := []struct {
+		name   string
+		id     []byte
+		status int
+	}{
+		{"confirmed", confirmed.Transactions[0].ID, http.StatusOK},
+		{"in a pruned block", pruned.Transactions[0].ID, http.StatusGone},
+		{"unknown", make([]byte, 32), http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest("GET", "/tx/"+hex.EncodeToString(tt.id), nil))
+			if rec.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+}
+
+func TestRESTChainInfo(t *testing.T) {
+	bc, _ := newTestChain(t)
+	mux := http.NewServeMux()
+	(&restServer{bc}).register(mux)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/chaininfo", nil))
+	var info map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatalf("%v: %s", err, rec.Body)
+	}
+	if info["consensus"] != bc.engine.Name() {
+		t.Errorf("consensus %v, want %s", info["consensus"], bc.engine.Name())
+	}
+	if _, ok := info["target_bits"]; ok {
+		t.Error("target bits reported under proof of authority")
+	}
+}
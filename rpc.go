@@ -0,0 +1,500 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/rpc"
+	"strconv"
+	"time"
+)
+
+const (
+	rpcPortOffset  = 10000
+	rpcDialTimeout = 500 * time.Millisecond
+	rpcServiceName = "Node"
+)
+
+func rpcAddress(nodeID string) string {
+	port, err := strconv.Atoi(nodeID)
+	if err != nil {
+		log.Panic("NODE_ID must be a port number: ", err)
+	}
+
+	return fmt.Sprintf("localhost:%d", port+rpcPortOffset)
+}
+
+type GetBalanceArgs struct {
+	Address string
+}
+
+type GetBalanceReply struct {
+	Balance int
+}
+
+type GetBestBlockHashArgs struct{}
+
+type GetBestBlockHashReply struct {
+	Hash []byte
+}
+
+type GetBlockArgs struct {
+	Hash []byte
+}
+
+type GetBlockReply struct {
+	Block []byte
+}
+
+type GetTransactionArgs struct {
+	ID []byte
+}
+
+type GetTransactionReply struct {
+	Transaction []byte
+}
+
+type FindDataCarrierArgs struct {
+	Data []byte
+}
+
+type FindDataCarrierReply struct {
+	Block []byte
+	TxID  []byte
+}
+
+type ListUnspentArgs struct {
+	Address string
+}
+
+type ListUnspentReply struct {
+	UTXOs []UTXO
+}
+
+type ListStakesArgs struct {
+	BlockHash []byte
+}
+
+type ListStakesReply struct {
+	Stakes []Stake
+}
+
+type GetConsensusArgs struct{}
+
+type GetConsensusReply struct {
+	Config ConsensusConfig
+}
+
+type InvalidateBlockArgs struct {
+	Hash []byte
+}
+
+type InvalidateBlockReply struct{}
+
+type ReconsiderBlockArgs struct {
+	Hash []byte
+}
+
+type ReconsiderBlockReply struct{}
+
+type CreateTransactionArgs struct {
+	Request TransactionRequest
+}
+
+type CreateTransactionReply struct {
+	Transaction []byte
+	PrevTXs     map[string]Transaction
+}
+
+type SubmitTransactionArgs struct {
+	Transaction   []byte
+	Mine          bool
+	RewardAddress string
+}
+
+type SubmitTransactionReply struct{}
+
+// StartMiningArgs, StopMiningArgs and GetMiningInfoArgs control the mining
+// service of a running node; they have no NodeClient counterpart.
+type StartMiningArgs struct {
+	Address string
+	Policy  MiningPolicy
+}
+
+type StartMiningReply struct{}
+
+type StopMiningArgs struct{}
+
+type StopMiningReply struct{}
+
+type GetMiningInfoArgs struct{}
+
+type GetMiningInfoReply struct {
+	Info MiningInfo
+}
+
+// GetBlockTemplateArgs and SubmitBlockArgs let an external miner do the
+// hashing for a running node.
+type GetBlockTemplateArgs struct{}
+
+type GetBlockTemplateReply struct {
+	Template BlockTemplate
+}
+
+type SubmitBlockArgs struct {
+	Block []byte
+}
+
+type SubmitBlockReply struct{}
+
+// NodeService exposes the running node's blockchain to the CLI.
+type NodeService struct {
+	bc     *BlockChain
+	chain  *localClient
+	mining *MiningService
+}
+
+// StartRPCServer serves NodeService on localhost, NODE_ID+rpcPortOffset. It
+// has no authentication: any process on this host may call it, including to
+// invalidate blocks and to start mining, so don't forward the port.
+func StartRPCServer(nodeID string, bc *BlockChain, mining *MiningService) {
+	server := rpc.NewServer()
+	err := server.RegisterName(rpcServiceName, &NodeService{bc, &localClient{bc}, mining})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	ln, err := net.Listen(protocol, rpcAddress(nodeID))
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("RPC server listening on %s\n", ln.Addr())
+
+	go server.Accept(ln)
+}
+
+func (s *NodeService) GetBalance(args *GetBalanceArgs, reply *GetBalanceReply) error {
+	balance, err := s.chain.GetBalance(args.Address)
+	if err != nil {
+		return err
+	}
+	reply.Balance = balance
+
+	return nil
+}
+
+func (s *NodeService) GetBestBlockHash(args *GetBestBlockHashArgs, reply *GetBestBlockHashReply) error {
+	hash, err := s.chain.GetBestBlockHash()
+	if err != nil {
+		return err
+	}
+	reply.Hash = hash
+
+	return nil
+}
+
+func (s *NodeService) GetBlock(args *GetBlockArgs, reply *GetBlockReply) error {
+	block, err := s.chain.GetBlock(args.Hash)
+	if err != nil {
+		return err
+	}
+	reply.Block = block.Serialize()
+
+	return nil
+}
+
+// GetTransaction also finds transactions still waiting in the mempool.
+func (s *NodeService) GetTransaction(args *GetTransactionArgs, reply *GetTransactionReply) error {
+	if tx, ok := mempool.Get(args.ID); ok {
+		reply.Transaction = tx.Serialize()
+		return nil
+	}
+
+	tx, err := s.chain.GetTransaction(args.ID)
+	if err != nil {
+		return err
+	}
+	reply.Transaction = tx.Serialize()
+
+	return nil
+}
+
+func (s *NodeService) FindDataCarrier(args *FindDataCarrierArgs, reply *FindDataCarrierReply) error {
+	block, tx, err := s.chain.FindDataCarrier(args.Data)
+	if err != nil {
+		return err
+	}
+	reply.Block = block.Serialize()
+	reply.TxID = tx.ID
+
+	return nil
+}
+
+func (s *NodeService) ListUnspent(args *ListUnspentArgs, reply *ListUnspentReply) error {
+	UTXOs, err := s.chain.ListUnspent(args.Address)
+	if err != nil {
+		return err
+	}
+	reply.UTXOs = UTXOs
+
+	return nil
+}
+
+func (s *NodeService) ListStakes(args *ListStakesArgs, reply *ListStakesReply) error {
+	stakes, err := s.chain.ListStakes(args.BlockHash)
+	if err != nil {
+		return err
+	}
+	reply.Stakes = stakes
+
+	return nil
+}
+
+func (s *NodeService) GetConsensus(args *GetConsensusArgs, reply *GetConsensusReply) error {
+	config, err := s.chain.GetConsensus()
+	if err != nil {
+		return err
+	}
+	reply.Config = config
+
+	return nil
+}
+
+func (s *NodeService) InvalidateBlock(args *InvalidateBlockArgs, reply *InvalidateBlockReply) error {
+	return s.chain.InvalidateBlock(args.Hash)
+}
+
+func (s *NodeService) ReconsiderBlock(args *ReconsiderBlockArgs, reply *ReconsiderBlockReply) error {
+	return s.chain.ReconsiderBlock(args.Hash)
+}
+
+func (s *NodeService) CreateTransaction(args *CreateTransactionArgs, reply *CreateTransactionReply) error {
+	tx, prevTXs, err := s.chain.CreateTransaction(args.Request)
+	if err != nil {
+		return err
+	}
+	reply.Transaction = tx.Serialize()
+	reply.PrevTXs = prevTXs
+
+	return nil
+}
+
+func (s *NodeService) SubmitTransaction(args *SubmitTransactionArgs, reply *SubmitTransactionReply) error {
+	tx := DeserializeTransaction(args.Transaction)
+
+	if args.Mine {
+		// The block holds only tx, so it can't spend unconfirmed outputs.
+		fee, err := s.bc.ValidateTransaction(&tx, s.bc.GetBestHeight()+1, timeSource.AdjustedTime(), nil)
+		if err != nil {
+			return fmt.Errorf("transaction %x is invalid: %w", tx.ID, err)
+		}
+
+		cbTx := NewCoinbaseTX(args.RewardAddress, "", s.bc.GetBestHeight()+1, fee)
+		_, err = mineAndAnnounce(context.Background(), s.bc, []*Transaction{cbTx, &tx})
+		return err
+	}
+
+	err := processTx(s.bc, tx, nodeAddress)
+	if err != nil {
+		return fmt.Errorf("transaction %x is rejected: %w", tx.ID, err)
+	}
+	if nodeAddress != knownNodes[0] {
+		sendTx(knownNodes[0], &tx)
+	}
+
+	return nil
+}
+
+func (s *NodeService) StartMining(args *StartMiningArgs, reply *StartMiningReply) error {
+	return s.mining.Start(args.Address, args.Policy)
+}
+
+func (s *NodeService) StopMining(args *StopMiningArgs, reply *StopMiningReply) error {
+	return s.mining.Stop()
+}
+
+func (s *NodeService) GetMiningInfo(args *GetMiningInfoArgs, reply *GetMiningInfoReply) error {
+	reply.Info = s.mining.Info()
+
+	return nil
+}
+
+func (s *NodeService) GetBlockTemplate(args *GetBlockTemplateArgs, reply *GetBlockTemplateReply) error {
+	if _, ok := s.bc.engine.(ProofOfWorkEngine); !ok {
+		return fmt.Errorf("block templates are for proof of work, not %s", s.bc.engine.Name())
+	}
+	reply.Template = *NewExternalBlockTemplate(s.bc, mempool, externalCoinbaseSize)
+
+	return nil
+}
+
+func (s *NodeService) SubmitBlock(args *SubmitBlockArgs, reply *SubmitBlockReply) error {
+	block, err := DecodeBlock(args.Block)
+	if err != nil {
+		return fmt.Errorf("can't decode the block: %w", err)
+	}
+
+	return submitBlock(s.bc, block)
+}
+
+type rpcClient struct {
+	client *rpc.Client
+}
+
+// DialRPC connects to the node started with `startnode` under nodeID. It
+// fails quickly when no node is running.
+func DialRPC(nodeID string) (*rpcClient, error) {
+	conn, err := net.DialTimeout(protocol, rpcAddress(nodeID), rpcDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rpcClient{rpc.NewClient(conn)}, nil
+}
+
+func (c *rpcClient) call(method string, args, reply any) error {
+	return c.client.Call(rpcServiceName+"."+method, args, reply)
+}
+
+func (c *rpcClient) GetBalance(address string) (int, error) {
+	var reply GetBalanceReply
+	err := c.call("GetBalance", &GetBalanceArgs{address}, &reply)
+
+	return reply.Balance, err
+}
+
+func (c *rpcClient) GetBestBlockHash() ([]byte, error) {
+	var reply GetBestBlockHashReply
+	err := c.call("GetBestBlockHash", &GetBestBlockHashArgs{}, &reply)
+
+	return reply.Hash, err
+}
+
+func (c *rpcClient) GetBlock(hash []byte) (*Block, error) {
+	var reply GetBlockReply
+	err := c.call("GetBlock", &GetBlockArgs{hash}, &reply)
+	if err != nil {
+		return nil, err
+	}
+
+	return DeserializeBlock(reply.Block), nil
+}
+
+func (c *rpcClient) GetTransaction(id []byte) (*Transaction, error) {
+	var reply GetTransactionReply
+	err := c.call("GetTransaction", &GetTransactionArgs{id}, &reply)
+	if err != nil {
+		return nil, err
+	}
+	tx := DeserializeTransaction(reply.Transaction)
+
+	return &tx, nil
+}
+
+func (c *rpcClient) FindDataCarrier(data []byte) (*Block, *Transaction, error) {
+	var reply FindDataCarrierReply
+	err := c.call("FindDataCarrier", &FindDataCarrierArgs{data}, &reply)
+	if err != nil {
+		return nil, nil, err
+	}
+	block := DeserializeBlock(reply.Block)
+
+	for _, tx := range block.Transactions {
+		if bytes.Equal(tx.ID, reply.TxID) {
+			return block, tx, nil
+		}
+	}
+
+	return nil, nil, fmt.Errorf("block %x doesn't contain transaction %x", block.Hash, reply.TxID)
+}
+
+func (c *rpcClient) ListUnspent(address string) ([]UTXO, error) {
+	var reply ListUnspentReply
+	err := c.call("ListUnspent", &ListUnspentArgs{address}, &reply)
+
+	return reply.UTXOs, err
+}
+
+func (c *rpcClient) ListStakes(blockHash []byte) ([]Stake, error) {
+	var reply ListStakesReply
+	err := c.call("ListStakes", &ListStakesArgs{blockHash}, &reply)
+
+	return reply.Stakes, err
+}
+
+func (c *rpcClient) GetConsensus() (ConsensusConfig, error) {
+	var reply GetConsensusReply
+	err := c.call("GetConsensus", &GetConsensusArgs{}, &reply)
+
+	return reply.Config, err
+}
+
+func (c *rpcClient) InvalidateBlock(hash []byte) error {
+	var reply InvalidateBlockReply
+
+	return c.call("InvalidateBlock", &InvalidateBlockArgs{hash}, &reply)
+}
+
+func (c *rpcClient) ReconsiderBlock(hash []byte) error {
+	var reply ReconsiderBlockReply
+
+	return c.call("ReconsiderBlock", &ReconsiderBlockArgs{hash}, &reply)
+}
+
+func (c *rpcClient) CreateTransaction(req TransactionRequest) (*Transaction, map[string]Transaction, error) {
+	var reply CreateTransactionReply
+	err := c.call("CreateTransaction", &CreateTransactionArgs{req}, &reply)
+	if err != nil {
+		return nil, nil, err
+	}
+	tx := DeserializeTransaction(reply.Transaction)
+
+	return &tx, reply.PrevTXs, nil
+}
+
+func (c *rpcClient) SubmitTransaction(tx *Transaction, mine bool, rewardAddress string) error {
+	var reply SubmitTransactionReply
+
+	return c.call("SubmitTransaction", &SubmitTransactionArgs{tx.Serialize(), mine, rewardAddress}, &reply)
+}
+
+func (c *rpcClient) Close() error {
+	return c.client.Close()
+}
+
+func (c *rpcClient) StartMining(address string, policy MiningPolicy) error {
+	var reply StartMiningReply
+
+	return c.call("StartMining", &StartMiningArgs{address, policy}, &reply)
+}
+
+func (c *rpcClient) StopMining() error {
+	var reply StopMiningReply
+
+	return c.call("StopMining", &StopMiningArgs{}, &reply)
+}
+
+func (c *rpcClient) GetMiningInfo() (MiningInfo, error) {
+	var reply GetMiningInfoReply
+	err := c.call("GetMiningInfo", &GetMiningInfoArgs{}, &reply)
+
+	return reply.Info, err
+}
+
+func (c *rpcClient) GetBlockTemplate() (*BlockTemplate, error) {
+	var reply GetBlockTemplateReply
+	err := c.call("GetBlockTemplate", &GetBlockTemplateArgs{}, &reply)
+	if err != nil {
+		return nil, err
+	}
+
+	return &reply.Template, nil
+}
+
+func (c *rpcClient) SubmitBlock(block *Block) error {
+	var reply SubmitBlockReply
+
+	return c.call("SubmitBlock", &SubmitBlockArgs{block.Serialize()}, &reply)
+}
@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestSubmitBlockMalformed(t *testing.T) {
+	bc, _ := newTestChain(t)
+	service := &NodeService{bc, &localClient{bc}, nil}
+
+	empty := &Block{
+		Timestamp:     tipBlock(t, bc).Timestamp + 1,
+		PrevBlockHash: bc.Tip(),
+		Height:        1,
+	}
+
+	tests := []struct {
+		name  string
+		block []byte
+		err   string
+	}{
+		{"not a block", []byte("garbage"), "can't decode"},
+		{"no transactions", empty.Serialize(), "no transactions"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := service.SubmitBlock(&SubmitBlockArgs{tt.block}, &SubmitBlockReply{})
+			checkError(t, err, tt.err)
+		})
+	}
+}
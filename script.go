@@ -0,0 +1,679 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	OP_0                   = 0x00
+	OP_DATA_1              = 0x01
+	OP_DATA_75             = 0x4b
+	OP_PUSHDATA1           = 0x4c
+	OP_PUSHDATA2           = 0x4d
+	OP_1                   = 0x51
+	OP_16                  = 0x60
+	OP_NOP                 = 0x61
+	OP_IF                  = 0x63
+	OP_NOTIF               = 0x64
+	OP_ELSE                = 0x67
+	OP_ENDIF               = 0x68
+	OP_VERIFY              = 0x69
+	OP_RETURN              = 0x6a
+	OP_DROP                = 0x75
+	OP_DUP                 = 0x76
+	OP_SWAP                = 0x7c
+	OP_SIZE                = 0x82
+	OP_EQUAL               = 0x87
+	OP_EQUALVERIFY         = 0x88
+	OP_SHA256              = 0xa8
+	OP_HASH160             = 0xa9
+	OP_CHECKSIG            = 0xac
+	OP_CHECKSIGVERIFY      = 0xad
+	OP_CHECKMULTISIG       = 0xae
+	OP_CHECKMULTISIGVERIFY = 0xaf
+	OP_CHECKLOCKTIMEVERIFY = 0xb1
+	OP_CHECKSEQUENCEVERIFY = 0xb2
+	// OP_STAKE tags stake and unbonding outputs and otherwise does nothing.
+	OP_STAKE = 0xb3
+)
+
+const (
+	maxScriptSize         = 10000
+	maxScriptElementSize  = 520
+	maxOpsPerScript       = 201
+	maxStackSize          = 1000
+	maxPubKeysPerMultisig = 20
+	maxScriptNumLen       = 4
+	maxLockTimeNumLen     = 5
+)
+
+var opcodeNames = map[byte]string{
+	OP_0:                   "OP_0",
+	OP_PUSHDATA1:           "OP_PUSHDATA1",
+	OP_PUSHDATA2:           "OP_PUSHDATA2",
+	OP_NOP:                 "OP_NOP",
+	OP_IF:                  "OP_IF",
+	OP_NOTIF:               "OP_NOTIF",
+	OP_ELSE:                "OP_ELSE",
+	OP_ENDIF:               "OP_ENDIF",
+	OP_VERIFY:              "OP_VERIFY",
+	OP_RETURN:              "OP_RETURN",
+	OP_DROP:                "OP_DROP",
+	OP_DUP:                 "OP_DUP",
+	OP_SWAP:                "OP_SWAP",
+	OP_SIZE:                "OP_SIZE",
+	OP_EQUAL:               "OP_EQUAL",
+	OP_EQUALVERIFY:         "OP_EQUALVERIFY",
+	OP_SHA256:              "OP_SHA256",
+	OP_HASH160:             "OP_HASH160",
+	OP_CHECKSIG:            "OP_CHECKSIG",
+	OP_CHECKSIGVERIFY:      "OP_CHECKSIGVERIFY",
+	OP_CHECKMULTISIG:       "OP_CHECKMULTISIG",
+	OP_CHECKMULTISIGVERIFY: "OP_CHECKMULTISIGVERIFY",
+	OP_CHECKLOCKTIMEVERIFY: "OP_CHECKLOCKTIMEVERIFY",
+	OP_CHECKSEQUENCEVERIFY: "OP_CHECKSEQUENCEVERIFY",
+	OP_STAKE:               "OP_STAKE",
+}
+
+// ScriptOp is a single parsed instruction. Data is set for push operations.
+type ScriptOp struct {
+	Opcode byte
+	Data   []byte
+}
+
+func (op ScriptOp) isPush() bool {
+	return op.Opcode <= OP_16 && op.Opcode != 0x50
+}
+
+func ParseScript(script []byte) ([]ScriptOp, error) {
+	var ops []ScriptOp
+
+	if len(script) > maxScriptSize {
+		return nil, fmt.Errorf("script size %d exceeds %d", len(script), maxScriptSize)
+	}
+
+	for i := 0; i < len(script); {
+		opcode := script[i]
+		i++
+
+		var size int
+		switch {
+		case opcode >= OP_DATA_1 && opcode <= OP_DATA_75:
+			size = int(opcode)
+		case opcode == OP_PUSHDATA1:
+			if i+1 > len(script) {
+				return nil, errors.New("truncated OP_PUSHDATA1")
+			}
+			size = int(script[i])
+			i++
+		case opcode == OP_PUSHDATA2:
+			if i+2 > len(script) {
+				return nil, errors.New("truncated OP_PUSHDATA2")
+			}
+			size = int(binary.LittleEndian.Uint16(script[i:]))
+			i += 2
+		default:
+			ops = append(ops, ScriptOp{Opcode: opcode})
+			continue
+		}
+
+		if i+size > len(script) {
+			return nil, errors.New("push past the end of the script")
+		}
+		ops = append(ops, ScriptOp{opcode, script[i : i+size]})
+		i += size
+	}
+
+	return ops, nil
+}
+
+// DisasmScript renders script in the usual "OP_DUP OP_HASH160 <hex> ..." form.
+func DisasmScript(script []byte) string {
+	ops, err := ParseScript(script)
+	if err != nil {
+		return fmt.Sprintf("[error: %s] %x", err, script)
+	}
+
+	var parts []string
+	for _, op := range ops {
+		if op.Data != nil {
+			parts = append(parts, hex.EncodeToString(op.Data))
+		} else {
+			parts = append(parts, opcodeName(op.Opcode))
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func opcodeName(opcode byte) string {
+	switch {
+	case opcode >= OP_1 && opcode <= OP_16:
+		return fmt.Sprintf("OP_%d", opcode-OP_1+1)
+	case opcodeNames[opcode] != "":
+		return opcodeNames[opcode]
+	default:
+		return fmt.Sprintf("OP_UNKNOWN_%#x", opcode)
+	}
+}
+
+// ScriptBuilder assembles scripts with minimal push encodings.
+type ScriptBuilder struct {
+	script []byte
+}
+
+func NewScriptBuilder() *ScriptBuilder {
+	return &ScriptBuilder{}
+}
+
+func (sb *ScriptBuilder) AddOp(opcode byte) *ScriptBuilder {
+	sb.script = append(sb.script, opcode)
+	return sb
+}
+
+func (sb *ScriptBuilder) AddData(data []byte) *ScriptBuilder {
+	size := len(data)
+
+	switch {
+	case size == 0:
+		sb.script = append(sb.script, OP_0)
+	case size <= OP_DATA_75:
+		sb.script = append(sb.script, byte(size))
+	case size <= 0xff:
+		sb.script = append(sb.script, OP_PUSHDATA1, byte(size))
+	default:
+		sb.script = append(sb.script, OP_PUSHDATA2, byte(size), byte(size>>8))
+	}
+	sb.script = append(sb.script, data...)
+
+	return sb
+}
+
+func (sb *ScriptBuilder) AddInt64(n int64) *ScriptBuilder {
+	if n == 0 {
+		return sb.AddOp(OP_0)
+	}
+	if n >= 1 && n <= 16 {
+		return sb.AddOp(byte(OP_1 + n - 1))
+	}
+
+	return sb.AddData(scriptNumBytes(n))
+}
+
+func (sb *ScriptBuilder) Script() []byte {
+	return sb.script
+}
+
+// scriptNumBytes encodes n as a minimal little-endian sign-magnitude number.
+func scriptNumBytes(n int64) []byte {
+	if n == 0 {
+		return nil
+	}
+
+	negative := n < 0
+	abs := n
+	if negative {
+		abs = -n
+	}
+
+	var result []byte
+	for abs > 0 {
+		result = append(result, byte(abs&0xff))
+		abs >>= 8
+	}
+
+	if result[len(result)-1]&0x80 != 0 {
+		extra := byte(0x00)
+		if negative {
+			extra = 0x80
+		}
+		result = append(result, extra)
+	} else if negative {
+		result[len(result)-1] |= 0x80
+	}
+
+	return result
+}
+
+func parseScriptNum(data []byte, maxLen int) (int64, error) {
+	if len(data) > maxLen {
+		return 0, fmt.Errorf("number of %d bytes exceeds %d", len(data), maxLen)
+	}
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	var n int64
+	for i, b := range data {
+		n |= int64(b) << uint(8*i)
+	}
+
+	if data[len(data)-1]&0x80 != 0 {
+		n &= ^(int64(0x80) << uint(8*(len(data)-1)))
+		return -n, nil
+	}
+
+	return n, nil
+}
+
+func castToBool(data []byte) bool {
+	for i, b := range data {
+		if b != 0 {
+			// Negative zero is false.
+			return !(i == len(data)-1 && b == 0x80)
+		}
+	}
+
+	return false
+}
+
+// SignatureChecker gives the interpreter access to the spending transaction.
+type SignatureChecker interface {
+	CheckSignature(sig, pubKey []byte) bool
+	CheckLockTime(lockTime int64) bool
+	CheckSequence(sequence int64) bool
+}
+
+type scriptVM struct {
+	stack     [][]byte
+	condStack []bool
+	opCount   int
+	checker   SignatureChecker
+}
+
+// VerifyScript runs the unlocking script followed by the locking script it
+// spends and reports why the spend is invalid, if it is. When the locking
+// script is pay-to-script-hash, the last item pushed by the unlocking script
+// is the redeem script, which then runs against the rest of the stack.
+func VerifyScript(scriptSig, scriptPubKey []byte, checker SignatureChecker) error {
+	sigOps, err := ParseScript(scriptSig)
+	if err != nil {
+		return err
+	}
+	for _, op := range sigOps {
+		if !op.isPush() {
+			return errors.New("unlocking script is not push-only")
+		}
+	}
+
+	vm := &scriptVM{checker: checker}
+
+	err = vm.execute(scriptSig)
+	if err != nil {
+		return err
+	}
+	sigStack := append([][]byte{}, vm.stack...)
+
+	err = vm.execute(scriptPubKey)
+	if err != nil {
+		return err
+	}
+
+	if !vm.succeeded() {
+		return errors.New("script evaluated to false")
+	}
+
+	if ClassifyScript(scriptPubKey) != ScriptHashTy {
+		return nil
+	}
+
+	redeemScript := sigStack[len(sigStack)-1]
+	vm.stack = sigStack[:len(sigStack)-1]
+
+	err = vm.execute(redeemScript)
+	if err != nil {
+		return fmt.Errorf("redeem script: %w", err)
+	}
+
+	if !vm.succeeded() {
+		return errors.New("redeem script evaluated to false")
+	}
+
+	return nil
+}
+
+func (vm *scriptVM) succeeded() bool {
+	return len(vm.stack) != 0 && castToBool(vm.stack[len(vm.stack)-1])
+}
+
+func (vm *scriptVM) executing() bool {
+	for _, cond := range vm.condStack {
+		if !cond {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (vm *scriptVM) push(data []byte) {
+	vm.stack = append(vm.stack, data)
+}
+
+func (vm *scriptVM) pop() ([]byte, error) {
+	if len(vm.stack) == 0 {
+		return nil, errors.New("stack underflow")
+	}
+
+	top := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+
+	return top, nil
+}
+
+func (vm *scriptVM) peek() ([]byte, error) {
+	if len(vm.stack) == 0 {
+		return nil, errors.New("stack underflow")
+	}
+
+	return vm.stack[len(vm.stack)-1], nil
+}
+
+func (vm *scriptVM) popNum(maxLen int) (int64, error) {
+	data, err := vm.pop()
+	if err != nil {
+		return 0, err
+	}
+
+	return parseScriptNum(data, maxLen)
+}
+
+func (vm *scriptVM) popBool() (bool, error) {
+	data, err := vm.pop()
+	if err != nil {
+		return false, err
+	}
+
+	return castToBool(data), nil
+}
+
+func boolBytes(b bool) []byte {
+	if b {
+		return []byte{1}
+	}
+
+	return nil
+}
+
+func (vm *scriptVM) execute(script []byte) error {
+	ops, err := ParseScript(script)
+	if err != nil {
+		return err
+	}
+
+	vm.condStack = nil
+	vm.opCount = 0
+
+	for _, op := range ops {
+		if len(op.Data) > maxScriptElementSize {
+			return fmt.Errorf("push of %d bytes exceeds %d", len(op.Data), maxScriptElementSize)
+		}
+
+		if op.Opcode > OP_16 {
+			vm.opCount++
+			if vm.opCount > maxOpsPerScript {
+				return fmt.Errorf("script exceeds %d operations", maxOpsPerScript)
+			}
+		}
+
+		isConditional := op.Opcode >= OP_IF && op.Opcode <= OP_ENDIF
+		if !vm.executing() && !isConditional {
+			continue
+		}
+
+		err = vm.step(op)
+		if err != nil {
+			return fmt.Errorf("%s: %w", opcodeName(op.Opcode), err)
+		}
+
+		if len(vm.stack) > maxStackSize {
+			return fmt.Errorf("stack size exceeds %d", maxStackSize)
+		}
+	}
+
+	if len(vm.condStack) != 0 {
+		return errors.New("unbalanced conditional")
+	}
+
+	return nil
+}
+
+func (vm *scriptVM) step(op ScriptOp) error {
+	switch {
+	case op.Data != nil || op.Opcode == OP_0:
+		vm.push(op.Data)
+		return nil
+	case op.Opcode >= OP_1 && op.Opcode <= OP_16:
+		vm.push(scriptNumBytes(int64(op.Opcode - OP_1 + 1)))
+		return nil
+	}
+
+	switch op.Opcode {
+	case OP_NOP, OP_STAKE:
+
+	case OP_IF, OP_NOTIF:
+		cond := false
+		if vm.executing() {
+			value, err := vm.popBool()
+			if err != nil {
+				return err
+			}
+			cond = value == (op.Opcode == OP_IF)
+		}
+		vm.condStack = append(vm.condStack, cond)
+
+	case OP_ELSE:
+		if len(vm.condStack) == 0 {
+			return errors.New("OP_ELSE without OP_IF")
+		}
+		vm.condStack[len(vm.condStack)-1] = !vm.condStack[len(vm.condStack)-1]
+
+	case OP_ENDIF:
+		if len(vm.condStack) == 0 {
+			return errors.New("OP_ENDIF without OP_IF")
+		}
+		vm.condStack = vm.condStack[:len(vm.condStack)-1]
+
+	case OP_VERIFY:
+		ok, err := vm.popBool()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("verify failed")
+		}
+
+	case OP_RETURN:
+		return errors.New("output is unspendable")
+
+	case OP_DROP:
+		_, err := vm.pop()
+		return err
+
+	case OP_DUP:
+		top, err := vm.peek()
+		if err != nil {
+			return err
+		}
+		vm.push(top)
+
+	case OP_SWAP:
+		a, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		b, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		vm.push(a)
+		vm.push(b)
+
+	case OP_SIZE:
+		top, err := vm.peek()
+		if err != nil {
+			return err
+		}
+		vm.push(scriptNumBytes(int64(len(top))))
+
+	case OP_EQUAL, OP_EQUALVERIFY:
+		a, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		b, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		equal := bytes.Equal(a, b)
+		if op.Opcode == OP_EQUALVERIFY {
+			if !equal {
+				return errors.New("values are not equal")
+			}
+			return nil
+		}
+		vm.push(boolBytes(equal))
+
+	case OP_SHA256:
+		data, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(data)
+		vm.push(hash[:])
+
+	case OP_HASH160:
+		data, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		vm.push(HashPubKey(data))
+
+	case OP_CHECKSIG, OP_CHECKSIGVERIFY:
+		pubKey, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		sig, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		valid := vm.checker.CheckSignature(sig, pubKey)
+		if op.Opcode == OP_CHECKSIGVERIFY {
+			if !valid {
+				return errors.New("signature is invalid")
+			}
+			return nil
+		}
+		vm.push(boolBytes(valid))
+
+	case OP_CHECKMULTISIG, OP_CHECKMULTISIGVERIFY:
+		valid, err := vm.checkMultisig()
+		if err != nil {
+			return err
+		}
+		if op.Opcode == OP_CHECKMULTISIGVERIFY {
+			if !valid {
+				return errors.New("multisig threshold is not met")
+			}
+			return nil
+		}
+		vm.push(boolBytes(valid))
+
+	case OP_CHECKLOCKTIMEVERIFY:
+		top, err := vm.peek()
+		if err != nil {
+			return err
+		}
+		lockTime, err := parseScriptNum(top, maxLockTimeNumLen)
+		if err != nil {
+			return err
+		}
+		if lockTime < 0 {
+			return errors.New("negative lock time")
+		}
+		if !vm.checker.CheckLockTime(lockTime) {
+			return errors.New("lock time is not satisfied")
+		}
+
+	case OP_CHECKSEQUENCEVERIFY:
+		top, err := vm.peek()
+		if err != nil {
+			return err
+		}
+		sequence, err := parseScriptNum(top, maxLockTimeNumLen)
+		if err != nil {
+			return err
+		}
+		if sequence < 0 {
+			return errors.New("negative sequence")
+		}
+		if sequence&SequenceLockTimeDisabled == 0 && !vm.checker.CheckSequence(sequence) {
+			return errors.New("relative lock time is not satisfied")
+		}
+
+	default:
+		return fmt.Errorf("unknown opcode %#x", op.Opcode)
+	}
+
+	return nil
+}
+
+// checkMultisig pops <sig...> <m> <pubkey...> <n>. Signatures must appear in
+// the same order as the public keys they belong to.
+func (vm *scriptVM) checkMultisig() (bool, error) {
+	n, err := vm.popNum(maxScriptNumLen)
+	if err != nil {
+		return false, err
+	}
+	if n < 1 || n > maxPubKeysPerMultisig {
+		return false, fmt.Errorf("invalid public key count %d", n)
+	}
+	vm.opCount += int(n)
+	if vm.opCount > maxOpsPerScript {
+		return false, fmt.Errorf("script exceeds %d operations", maxOpsPerScript)
+	}
+
+	pubKeys := make([][]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		pubKeys[i], err = vm.pop()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	m, err := vm.popNum(maxScriptNumLen)
+	if err != nil {
+		return false, err
+	}
+	if m < 1 || m > n {
+		return false, fmt.Errorf("invalid signature count %d of %d", m, n)
+	}
+
+	sigs := make([][]byte, m)
+	for i := m - 1; i >= 0; i-- {
+		sigs[i], err = vm.pop()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	keyIdx := 0
+	for _, sig := range sigs {
+		for keyIdx < len(pubKeys) && !vm.checker.CheckSignature(sig, pubKeys[keyIdx]) {
+			keyIdx++
+		}
+		if keyIdx == len(pubKeys) {
+			return false, nil
+		}
+		keyIdx++
+	}
+
+	return true, nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+// testChecker accepts the signatures testSig makes, lock times up to
+// lockTime and relative locks up to sequence.
+type testChecker struct {
+	lockTime int64
+	sequence int64
+}
+
+func testSig(pubKey []byte) []byte {
+	return append([]byte("sig"), pubKey...)
+}
+
+func (c testChecker) CheckSignature(sig, pubKey []byte) bool {
+	return bytes.Equal(sig, testSig(pubKey))
+}
+
+func (c testChecker) CheckLockTime(lockTime int64) bool {
+	return lockTime <= c.lockTime
+}
+
+func (c testChecker) CheckSequence(sequence int64) bool {
+	return sequence <= c.sequence
+}
+
+// pushes returns a script pushing each of data.
+func pushes(data ...[]byte) []byte {
+	sb := NewScriptBuilder()
+	for _, d := range data {
+		sb.AddData(d)
+	}
+
+	return sb.Script()
+}
+
+func TestVerifyScript(t *testing.T) {
+	pubKey := bytes.Repeat([]byte{0x01}, pubKeyLen)
+	otherKey := bytes.Repeat([]byte{0x02}, pubKeyLen)
+	secret := []byte("abc")
+	secretHash := sha256.Sum256(secret)
+	checker := testChecker{lockTime: 10, sequence: 10}
+
+	repeat := func(opcode byte, n int) []byte { return bytes.Repeat([]byte{opcode}, n) }
+	lockScript := func(n int64, opcode byte) []byte {
+		return NewScriptBuilder().AddInt64(n).AddOp(opcode).Script()
+	}
+
+	tests := []struct {
+		name         string
+		scriptSig    []byte
+		scriptPubKey []byte
+		errMsg       string
+	}{
+		{"empty scripts", nil, nil, "evaluated to false"},
+		{"true", nil, []byte{OP_1}, ""},
+		{"false", nil, []byte{OP_0}, "evaluated to false"},
+		{"negative zero is false", pushes([]byte{0x80}), nil, "evaluated to false"},
+		{"unlocking script not push-only", []byte{OP_1, OP_DUP}, []byte{OP_EQUAL}, "not push-only"},
+
+		{"OP_NOP", nil, []byte{OP_1, OP_NOP}, ""},
+		{"OP_STAKE", nil, []byte{OP_STAKE, OP_1}, ""},
+		{"unknown opcode", nil, []byte{OP_1, 0xff}, "unknown opcode 0xff"},
+		{"unknown opcode not executed", nil, []byte{OP_0, OP_IF, 0xff, OP_ENDIF, OP_1}, ""},
+
+		{"OP_IF takes the true branch", nil, []byte{OP_1, OP_IF, OP_1, OP_ELSE, OP_0, OP_ENDIF}, ""},
+		{"OP_IF takes the false branch", nil, []byte{OP_0, OP_IF, OP_1, OP_ELSE, OP_0, OP_ENDIF}, "evaluated to false"},
+		{"OP_NOTIF", nil, []byte{OP_0, OP_NOTIF, OP_1, OP_ENDIF}, ""},
+		{"nested OP_IF", nil, []byte{OP_1, OP_0, OP_IF, OP_0, OP_IF, OP_ENDIF, OP_ELSE, OP_1, OP_ENDIF}, ""},
+		{"OP_IF underflow", nil, []byte{OP_IF, OP_1, OP_ENDIF}, "OP_IF: stack underflow"},
+		{"OP_NOTIF underflow", nil, []byte{OP_NOTIF, OP_1, OP_ENDIF}, "OP_NOTIF: stack underflow"},
+		{"OP_ELSE without OP_IF", nil, []byte{OP_1, OP_ELSE}, "OP_ELSE without OP_IF"},
+		{"OP_ENDIF without OP_IF", nil, []byte{OP_1, OP_ENDIF}, "OP_ENDIF without OP_IF"},
+		{"unbalanced conditional", nil, []byte{OP_1, OP_IF, OP_1}, "unbalanced conditional"},
+
+		{"OP_VERIFY", nil, []byte{OP_1, OP_1, OP_VERIFY}, ""},
+		{"OP_VERIFY fails", nil, []byte{OP_1, OP_0, OP_VERIFY}, "verify failed"},
+		{"OP_VERIFY underflow", nil, []byte{OP_VERIFY}, "OP_VERIFY: stack underflow"},
+		{"OP_RETURN", nil, []byte{OP_1, OP_RETURN}, "unspendable"},
+		{"OP_RETURN not executed", nil, []byte{OP_0, OP_IF, OP_RETURN, OP_ENDIF, OP_1}, ""},
+
+		{"OP_DROP", nil, []byte{OP_1, OP_0, OP_DROP}, ""},
+		{"OP_DROP underflow", nil, []byte{OP_DROP}, "OP_DROP: stack underflow"},
+		{"OP_DUP", nil, []byte{OP_1, OP_DUP, OP_EQUAL}, ""},
+		{"OP_DUP underflow", nil, []byte{OP_DUP}, "OP_DUP: stack underflow"},
+		{"OP_SWAP", nil, []byte{OP_1, OP_0, OP_SWAP}, ""},
+		{"OP_SWAP puts the second on top", nil, []byte{OP_0, OP_1, OP_SWAP}, "evaluated to false"},
+		{"OP_SWAP underflow", nil, []byte{OP_1, OP_SWAP}, "OP_SWAP: stack underflow"},
+		{"OP_SIZE", pushes(secret), []byte{OP_SIZE, OP_1 + 2, OP_EQUALVERIFY}, ""},
+		{"OP_SIZE mismatch", pushes(secret), []byte{OP_SIZE, OP_1 + 1, OP_EQUALVERIFY}, "values are not equal"},
+		{"OP_SIZE underflow", nil, []byte{OP_SIZE}, "OP_SIZE: stack underflow"},
+
+		{"OP_EQUAL", nil, []byte{OP_1 + 1, OP_1 + 1, OP_EQUAL}, ""},
+		{"OP_EQUAL unequal", nil, []byte{OP_1, OP_1 + 1, OP_EQUAL}, "evaluated to false"},
+		{"OP_EQUAL underflow", nil, []byte{OP_1, OP_EQUAL}, "OP_EQUAL: stack underflow"},
+		{"OP_EQUALVERIFY", nil, []byte{OP_1, OP_1, OP_1, OP_EQUALVERIFY}, ""},
+		{"OP_EQUALVERIFY unequal", nil, []byte{OP_1, OP_1, OP_0, OP_EQUALVERIFY}, "values are not equal"},
+		{"OP_EQUALVERIFY underflow", nil, []byte{OP_EQUALVERIFY}, "OP_EQUALVERIFY: stack underflow"},
+
+		{"OP_SHA256", pushes(secret), append(append([]byte{OP_SHA256}, pushes(secretHash[:])...), OP_EQUAL), ""},
+		{"OP_SHA256 wrong preimage", pushes([]byte("abd")), append(append([]byte{OP_SHA256}, pushes(secretHash[:])...), OP_EQUAL), "evaluated to false"},
+		{"OP_SHA256 underflow", nil, []byte{OP_SHA256}, "OP_SHA256: stack underflow"},
+		{"OP_HASH160", pushes(pubKey), append(append([]byte{OP_HASH160}, pushes(HashPubKey(pubKey))...), OP_EQUAL), ""},
+		{"OP_HASH160 wrong preimage", pushes(otherKey), append(append([]byte{OP_HASH160}, pushes(HashPubKey(pubKey))...), OP_EQUAL), "evaluated to false"},
+		{"OP_HASH160 underflow", nil, []byte{OP_HASH160}, "OP_HASH160: stack underflow"},
+
+		{"OP_CHECKSIG", pushes(testSig(pubKey), pubKey), []byte{OP_CHECKSIG}, ""},
+		{"OP_CHECKSIG invalid", pushes(testSig(otherKey), pubKey), []byte{OP_CHECKSIG}, "evaluated to false"},
+		{"OP_CHECKSIG underflow", pushes(pubKey), []byte{OP_CHECKSIG}, "OP_CHECKSIG: stack underflow"},
+		{"OP_CHECKSIGVERIFY", pushes(testSig(pubKey), pubKey), []byte{OP_CHECKSIGVERIFY, OP_1}, ""},
+		{"OP_CHECKSIGVERIFY invalid", pushes(testSig(otherKey), pubKey), []byte{OP_CHECKSIGVERIFY, OP_1}, "signature is invalid"},
+		{"OP_CHECKSIGVERIFY underflow", nil, []byte{OP_CHECKSIGVERIFY}, "OP_CHECKSIGVERIFY: stack underflow"},
+
+		{"OP_CHECKLOCKTIMEVERIFY", nil, lockScript(10, OP_CHECKLOCKTIMEVERIFY), ""},
+		{"OP_CHECKLOCKTIMEVERIFY too early", nil, lockScript(11, OP_CHECKLOCKTIMEVERIFY), "lock time is not satisfied"},
+		{"OP_CHECKLOCKTIMEVERIFY negative", nil, lockScript(-1, OP_CHECKLOCKTIMEVERIFY), "negative lock time"},
+		{"OP_CHECKLOCKTIMEVERIFY number too long", pushes(make([]byte, maxLockTimeNumLen+1)), []byte{OP_CHECKLOCKTIMEVERIFY}, "exceeds"},
+		{"OP_CHECKLOCKTIMEVERIFY underflow", nil, []byte{OP_CHECKLOCKTIMEVERIFY}, "OP_CHECKLOCKTIMEVERIFY: stack underflow"},
+		{"OP_CHECKSEQUENCEVERIFY", nil, lockScript(10, OP_CHECKSEQUENCEVERIFY), ""},
+		{"OP_CHECKSEQUENCEVERIFY too early", nil, lockScript(11, OP_CHECKSEQUENCEVERIFY), "relative lock time is not satisfied"},
+		{"OP_CHECKSEQUENCEVERIFY disabled", nil, lockScript(SequenceLockTimeDisabled|11, OP_CHECKSEQUENCEVERIFY), ""},
+		{"OP_CHECKSEQUENCEVERIFY negative", nil, lockScript(-1, OP_CHECKSEQUENCEVERIFY), "negative sequence"},
+		{"OP_CHECKSEQUENCEVERIFY underflow", nil, []byte{OP_CHECKSEQUENCEVERIFY}, "OP_CHECKSEQUENCEVERIFY: stack underflow"},
+
+		{"truncated OP_PUSHDATA1", nil, []byte{OP_1, OP_PUSHDATA1}, "truncated OP_PUSHDATA1"},
+		{"truncated OP_PUSHDATA2", nil, []byte{OP_1, OP_PUSHDATA2, 0x01}, "truncated OP_PUSHDATA2"},
+		{"push past the end", nil, []byte{OP_1, 0x02, 0x01}, "push past the end"},
+		{"malformed unlocking script", []byte{OP_PUSHDATA1}, []byte{OP_1}, "truncated OP_PUSHDATA1"},
+		{"oversized push", pushes(make([]byte, maxScriptElementSize+1)), []byte{OP_DROP, OP_1}, "exceeds 520"},
+		{"oversized script", nil, append([]byte{OP_1}, repeat(OP_NOP, maxScriptSize)...), "script size"},
+		{"too many operations", nil, append([]byte{OP_1}, repeat(OP_NOP, maxOpsPerScript+1)...), "operations"},
+		{"stack too large", nil, repeat(OP_1, maxStackSize+1), "stack size exceeds"},
+
+		{"P2PKH", pushes(testSig(pubKey), pubKey), PayToPubKeyHashScript(HashPubKey(pubKey)), ""},
+		{"P2PKH with another key", pushes(testSig(otherKey), otherKey), PayToPubKeyHashScript(HashPubKey(pubKey)), "values are not equal"},
+		{"P2PKH with a bad signature", pushes(testSig(otherKey), pubKey), PayToPubKeyHashScript(HashPubKey(pubKey)), "evaluated to false"},
+		{"P2PKH without a signature", nil, PayToPubKeyHashScript(HashPubKey(pubKey)), "OP_DUP: stack underflow"},
+		{"P2PK", pushes(testSig(pubKey)), PayToPubKeyScript(pubKey), ""},
+		{"P2PK with a bad signature", pushes(testSig(otherKey)), PayToPubKeyScript(pubKey), "evaluated to false"},
+		{"P2PK without a signature", nil, PayToPubKeyScript(pubKey), "OP_CHECKSIG: stack underflow"},
+		{"null data", nil, NullDataScript([]byte("data")), "unspendable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkError(t, VerifyScript(tt.scriptSig, tt.scriptPubKey, checker), tt.errMsg)
+		})
+	}
+}
+
+func TestScriptNum(t *testing.T) {
+	for _, n := range []int64{0, 1, -1, 16, 127, 128, -128, 255, 256, -256, 32767, 1 << 31, -(1 << 31)} {
+		data := scriptNumBytes(n)
+		got, err := parseScriptNum(data, maxLockTimeNumLen)
+		if err != nil || got != n {
+			t.Errorf("%d encodes to %x, which parses to %d, %v", n, data, got, err)
+		}
+	}
+}
+
+func TestClassifyScript(t *testing.T) {
+	pubKey := bytes.Repeat([]byte{0x01}, pubKeyLen)
+	pubKeyHash := HashPubKey(pubKey)
+	hash := sha256.Sum256([]byte("secret"))
+
+	tests := []struct {
+		name   string
+		script []byte
+		want   ScriptClass
+	}{
+		{"P2PKH", PayToPubKeyHashScript(pubKeyHash), PubKeyHashTy},
+		{"P2PK", PayToPubKeyScript(pubKey), PubKeyTy},
+		{"multisig", MultiSigScript(1, [][]byte{pubKey, pubKey}), MultiSigTy},
+		{"hash lock", HashLockScript(hash[:], pubKeyHash), HashLockTy},
+		{"time lock", TimeLockScript(500, pubKeyHash), TimeLockTy},
+		{"P2SH", PayToScriptHashScript(pubKeyHash), ScriptHashTy},
+		{"HTLC", (&HTLC{hash[:], pubKeyHash, pubKeyHash, 500}).Script(), HTLCTy},
+		{"null data", NullDataScript([]byte("data")), NullDataTy},
+		{"stake", StakeScript(pubKeyHash), StakeTy},
+		{"unbonding", UnbondingScript(100, pubKeyHash), UnbondingTy},
+		{"P2PKH with a short hash", PayToPubKeyHashScript(pubKeyHash[1:]), NonStandardTy},
+		{"P2PK with a short key", PayToPubKeyScript(pubKey[1:]), NonStandardTy},
+		{"multisig needing more keys than it has", MultiSigScript(3, [][]byte{pubKey, pubKey}), NonStandardTy},
+		{"oversized null data", NullDataScript(make([]byte, maxDataCarrierSize+1)), NonStandardTy},
+		{"malformed", []byte{OP_PUSHDATA1}, NonStandardTy},
+		{"empty", nil, NonStandardTy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClassifyScript(tt.script); got != tt.want {
+				t.Errorf("ClassifyScript() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyScriptHash(t *testing.T) {
+	pubKey := bytes.Repeat([]byte{0x01}, pubKeyLen)
+	otherKey := bytes.Repeat([]byte{0x02}, pubKeyLen)
+	redeemScript := MultiSigScript(1, [][]byte{pubKey})
+	scriptPubKey := PayToScriptHashScript(HashPubKey(redeemScript))
+
+	tests := []struct {
+		name      string
+		scriptSig []byte
+		errMsg    string
+	}{
+		{"redeem script satisfied", pushes(testSig(pubKey), redeemScript), ""},
+		{"redeem script of another hash", pushes(testSig(otherKey), MultiSigScript(1, [][]byte{otherKey})), "evaluated to false"},
+		{"redeem script not satisfied", pushes(testSig(otherKey), redeemScript), "redeem script evaluated to false"},
+		{"redeem script failing", pushes(redeemScript), "redeem script: OP_CHECKMULTISIG: stack underflow"},
+		{"no redeem script", nil, "OP_HASH160: stack underflow"},
+		{"malformed redeem script", pushes([]byte{OP_PUSHDATA1}), "evaluated to false"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkError(t, VerifyScript(tt.scriptSig, scriptPubKey, testChecker{}), tt.errMsg)
+		})
+	}
+
+	t.Run("malformed redeem script matching the hash", func(t *testing.T) {
+		malformed := []byte{OP_PUSHDATA1}
+		scriptPubKey := PayToScriptHashScript(HashPubKey(malformed))
+		checkError(t, VerifyScript(pushes(malformed), scriptPubKey, testChecker{}), "redeem script: truncated OP_PUSHDATA1")
+	})
+}
@@ -2,26 +2,34 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
-	"encoding/hex"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"sync"
+	"time"
 )
 
 const (
-	protocol      = "tcp"
-	nodeVersion   = 1
-	commandLength = 12
+	protocol        = "tcp"
+	nodeVersion     = 1
+	commandLength   = 12
+	messageOverhead = 64 * 1024
 )
 
 var (
 	nodeAddress     string
-	miningAddress   string
 	knownNodes      = []string{"localhost:3000"}
 	blocksInTransit = [][]byte{}
-	mempool         = make(map[string]Transaction)
+	mempool         = NewMempool()
+	timeSource      = NewMedianTimeSource()
+
+	// peerPrunedHeights holds the heights up to which peers have pruned
+	// their blocks, as their versions say.
+	peerPrunedHeights   = make(map[string]int)
+	peerPrunedHeightsMu sync.Mutex
 )
 
 type addr struct {
@@ -32,6 +40,10 @@ type verzion struct {
 	Version    int
 	BestHeight int
 	AddrFrom   string
+	Timestamp  int64
+	// PrunedHeight is the height up to which the node has pruned its
+	// blocks, so it can't send them.
+	PrunedHeight int
 }
 
 type getblocks struct {
@@ -60,9 +72,31 @@ type tx struct {
 	Transaction []byte
 }
 
-func StartServer(nodeID, minerAddress string) {
+type ServerOptions struct {
+	MinerAddress string
+	MinerWorkers int
+	MiningPolicy MiningPolicy
+	// PoolAddress is where a mining pool listens, paying what shares don't
+	// cover to PoolRewardAddress.
+	PoolAddress       string
+	PoolRewardAddress string
+	RESTAddress       string
+	ExplorerAddress   string
+	// AssumeValid overrides the assume-valid block of the network.
+	AssumeValid *Checkpoint
+	Prune       PrunePolicy
+}
+
+func StartServer(nodeID string, opts ServerOptions) {
 	nodeAddress = fmt.Sprintf("localhost:%s", nodeID)
-	miningAddress = minerAddress
+	if opts.AssumeValid != nil {
+		params := *netParams
+		params.AssumeValid = *opts.AssumeValid
+		netParams = &params
+	}
+	if opts.MinerWorkers > 0 {
+		miner = NewMiner(opts.MinerWorkers)
+	}
 	ln, err := net.Listen(protocol, nodeAddress)
 	if err != nil {
 		log.Panic(err)
@@ -70,6 +104,27 @@ func StartServer(nodeID, minerAddress string) {
 	defer ln.Close()
 
 	bc := NewBlockChain(nodeID)
+	if opts.Prune.Enabled() {
+		prunePolicy = opts.Prune
+		pruneBlocks(bc)
+	}
+	mining := NewMiningService(bc)
+	if len(opts.MinerAddress) > 0 {
+		err = mining.Start(opts.MinerAddress, opts.MiningPolicy)
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+	StartRPCServer(nodeID, bc, mining)
+	if len(opts.PoolAddress) > 0 {
+		StartPoolServer(opts.PoolAddress, opts.PoolRewardAddress, bc)
+	}
+	if len(opts.RESTAddress) > 0 {
+		StartRESTServer(opts.RESTAddress, bc)
+	}
+	if len(opts.ExplorerAddress) > 0 {
+		StartExplorer(opts.ExplorerAddress, bc)
+	}
 
 	if nodeAddress != knownNodes[0] {
 		sendVersion(knownNodes[0], bc)
@@ -94,8 +149,8 @@ func sendAddr(address string) {
 }
 
 func sendVersion(addr string, bc *BlockChain) {
-	bestHeight := bc.GetBestHeight()
-	payload := gobEncode(verzion{nodeVersion, bestHeight, nodeAddress})
+	bestHeight := bc.BestKnownHeight()
+	payload := gobEncode(verzion{nodeVersion, bestHeight, nodeAddress, time.Now().Unix(), bc.PrunedHeight()})
 
 	request := append(commandToBytes("version"), payload...)
 
@@ -179,11 +234,23 @@ func requestBlocks() {
 	}
 }
 
+// maxMessageSize bounds what a peer can send in one message: a block of the
+// maximum size with room for the rest of the message.
+func maxMessageSize() int {
+	return netParams.MaxBlockSize + messageOverhead
+}
+
 func handleConnection(conn net.Conn, bc *BlockChain) {
-	request, err := io.ReadAll(conn)
+	// Read one byte past the limit to tell messages that are too large.
+	request, err := io.ReadAll(io.LimitReader(conn, int64(maxMessageSize())+1))
 	if err != nil {
 		log.Panic(err)
 	}
+	if len(request) < commandLength || len(request) > maxMessageSize() {
+		fmt.Printf("Dropped message of %d bytes\n", len(request))
+		conn.Close()
+		return
+	}
 	command := bytesToCommand(request[:commandLength])
 	fmt.Printf("Received %s command\n", command)
 
@@ -191,7 +258,7 @@ func handleConnection(conn net.Conn, bc *BlockChain) {
 	case "addr":
 		handleAddr(request, bc)
 	case "version":
-		handleVersion(request, bc)
+		handleVersion(request, bc, conn.RemoteAddr())
 	case "inv":
 		handleInv(request, bc)
 	case "getblocks":
@@ -225,7 +292,10 @@ func handleAddr(request []byte, bc *BlockChain) {
 	requestBlocks()
 }
 
-func handleVersion(request []byte, bc *BlockChain) {
+// handleVersion takes the clock sample of a version message from the host
+// the connection comes from, since AddrFrom is only what the peer claims and
+// could be varied to count its clock many times.
+func handleVersion(request []byte, bc *BlockChain, remote net.Addr) {
 	var buff bytes.Buffer
 	var payload verzion
 
@@ -236,7 +306,14 @@ func handleVersion(request []byte, bc *BlockChain) {
 		log.Panic(err)
 	}
 
-	myBestHeight := bc.GetBestHeight()
+	if host, _, err := net.SplitHostPort(remote.String()); err == nil && payload.Timestamp != 0 {
+		timeSource.AddTimeSample(host, payload.Timestamp)
+	}
+	peerPrunedHeightsMu.Lock()
+	peerPrunedHeights[payload.AddrFrom] = payload.PrunedHeight
+	peerPrunedHeightsMu.Unlock()
+
+	myBestHeight := bc.BestKnownHeight()
 	foreignerBestHeight := payload.BestHeight
 
 	if myBestHeight < foreignerBestHeight {
@@ -280,23 +357,41 @@ func handleInv(request []byte, bc *BlockChain) {
 	fmt.Printf("Received inventory with %d %s\n", len(payload.Items), payload.Type)
 
 	if payload.Type == "block" {
-		blocksInTransit = payload.Items
-		blockHash := payload.Items[0]
-		sendGetData(payload.AddrFrom, "block", blockHash)
-
-		newInTransit := [][]byte{}
-		for _, b := range blocksInTransit {
-			if !bytes.Equal(b, blockHash) {
-				newInTransit = append(newInTransit, b)
+		// Inventories list the newest block first. Blocks are validated
+		// against their parent, so request the missing ones oldest first.
+		// Those answering getblocks run down to the genesis block, so a
+		// block's position gives its height, and a pruned peer isn't
+		// asked for the blocks it no longer has.
+		peerPrunedHeightsMu.Lock()
+		prunedHeight := peerPrunedHeights[payload.AddrFrom]
+		peerPrunedHeightsMu.Unlock()
+		last, err := bc.GetBlock(payload.Items[len(payload.Items)-1])
+		fromGenesis := err == nil && last.Height == 0
+
+		blocksInTransit = [][]byte{}
+		for i := len(payload.Items) - 1; i >= 0; i-- {
+			if _, err := bc.GetBlock(payload.Items[i]); err == nil {
+				continue
+			}
+			if height := len(payload.Items) - 1 - i; fromGenesis && height <= prunedHeight {
+				fmt.Printf("%s has pruned block %x at height %d\n", payload.AddrFrom, payload.Items[i], height)
+				blocksInTransit = [][]byte{}
+				return
 			}
+			blocksInTransit = append(blocksInTransit, payload.Items[i])
 		}
-		blocksInTransit = newInTransit
+		if len(blocksInTransit) == 0 {
+			return
+		}
+
+		sendGetData(payload.AddrFrom, "block", blocksInTransit[0])
+		blocksInTransit = blocksInTransit[1:]
 	}
 
 	if payload.Type == "tx" {
 		txID := payload.Items[0]
 
-		if mempool[hex.EncodeToString(txID)].ID == nil {
+		if !mempool.Has(txID) {
 			sendGetData(payload.AddrFrom, "tx", txID)
 		}
 	}
@@ -318,13 +413,16 @@ func handleGetData(request []byte, bc *BlockChain) {
 		if err != nil {
 			log.Panic(err)
 		}
+		if block.Pruned() {
+			fmt.Printf("Can't send pruned block %x\n", block.Hash)
+			return
+		}
 
 		sendBlock(payload.AddrFrom, &block)
 	}
 
 	if payload.Type == "tx" {
-		txID := hex.EncodeToString(payload.ID)
-		tx := mempool[txID]
+		tx, _ := mempool.Get(payload.ID)
 
 		sendTx(payload.AddrFrom, &tx)
 	}
@@ -345,9 +443,17 @@ func handleBlock(request []byte, bc *BlockChain) {
 	block := DeserializeBlock(blockData)
 
 	fmt.Println("Received a new block!")
-	bc.AddBlock(block)
+	err = bc.ProcessBlock(block)
+	if err != nil {
+		fmt.Printf("Rejected block %x: %s\n", block.Hash, err)
+		blocksInTransit = [][]byte{}
+		activateHeldBlocks(bc)
+		return
+	}
+	pruneBlocks(bc)
 
 	fmt.Printf("Added block %x\n", block.Hash)
+	reportDoubleSign(bc, block)
 
 	if len(blocksInTransit) > 0 {
 		blockHash := blocksInTransit[0]
@@ -355,11 +461,22 @@ func handleBlock(request []byte, bc *BlockChain) {
 
 		blocksInTransit = blocksInTransit[1:]
 	} else {
-		UTXOSet := UTXOSet{bc}
-		UTXOSet.Reindex()
+		activateHeldBlocks(bc)
 	}
 }
 
+// activateHeldBlocks connects the blocks held back for the assume-valid
+// block once no more are in transit, verifying their scripts if it didn't
+// come.
+func activateHeldBlocks(bc *BlockChain) {
+	err := bc.ActivateHeldBlocks()
+	if err != nil {
+		fmt.Printf("Rejected held blocks: %s\n", err)
+		return
+	}
+	pruneBlocks(bc)
+}
+
 func handleTx(request []byte, bc *BlockChain) {
 	var buff bytes.Buffer
 	var payload tx
@@ -373,55 +490,69 @@ func handleTx(request []byte, bc *BlockChain) {
 
 	txData := payload.Transaction
 	tx := DeserializeTransaction(txData)
-	mempool[hex.EncodeToString(tx.ID)] = tx
+	processTx(bc, tx, payload.AddrFrom)
+}
+
+// processTx adds tx to the mempool and relays it. It returns why tx was
+// rejected, if it was.
+func processTx(bc *BlockChain, tx Transaction, addrFrom string) error {
+	fee, err := bc.ValidateTransaction(&tx, bc.GetBestHeight()+1, timeSource.AdjustedTime(), mempool.Pending())
+	if err == nil {
+		err = mempool.Add(tx, fee)
+	}
+	if err != nil {
+		fmt.Printf("Rejected transaction %x: %s\n", tx.ID, err)
+		return err
+	}
 
 	if nodeAddress == knownNodes[0] {
 		for _, node := range knownNodes {
-			if node != nodeAddress && node != payload.AddrFrom {
+			if node != nodeAddress && node != addrFrom {
 				sendInv(node, "tx", [][]byte{tx.ID})
 			}
 		}
-	} else {
-		if len(mempool) >= 2 && len(miningAddress) > 0 {
-		MineTransactions:
-			var txs []*Transaction
-
-			for id := range mempool {
-				tx := mempool[id]
-
-				if bc.VerifyTransaction(&tx) {
-					txs = append(txs, &tx)
-				}
-			}
+	}
 
-			if len(txs) == 0 {
-				fmt.Println("All transactions are invalid! Waiting for new ones...")
-				return
-			}
+	return nil
+}
 
-			cbTx := NewCoinbaseTX(miningAddress, "")
-			txs = append(txs, cbTx)
+func mineAndAnnounce(ctx context.Context, bc *BlockChain, txs []*Transaction) (*Block, error) {
+	newBlock, err := bc.MineBlock(ctx, txs)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("New block is mined!")
+	announceBlock(bc, newBlock)
 
-			newBlock := bc.MineBlock(txs)
-			UTXOSet := UTXOSet{bc}
-			UTXOSet.Reindex()
+	return newBlock, nil
+}
 
-			fmt.Println("New block is mined!")
+// submitBlock connects a block solved by an external miner on the tip.
+func submitBlock(bc *BlockChain, block *Block) error {
+	if tip := bc.Tip(); !bytes.Equal(block.PrevBlockHash, tip) {
+		return fmt.Errorf("block %x doesn't extend the tip %x", block.Hash, tip)
+	}
+	err := bc.ProcessBlock(block)
+	if err != nil {
+		return fmt.Errorf("block %x is invalid: %w", block.Hash, err)
+	}
+	if tip := bc.Tip(); !bytes.Equal(tip, block.Hash) {
+		return fmt.Errorf("block %x was overtaken by %x", block.Hash, tip)
+	}
+	fmt.Printf("Added submitted block %x\n", block.Hash)
+	announceBlock(bc, block)
 
-			for _, tx := range txs {
-				txID := hex.EncodeToString(tx.ID)
-				delete(mempool, txID)
-			}
+	return nil
+}
 
-			for _, node := range knownNodes {
-				if node != nodeAddress {
-					sendInv(node, "block", [][]byte{newBlock.Hash})
-				}
-			}
+// announceBlock prunes old blocks once a block became the tip, and tells the
+// other nodes about it.
+func announceBlock(bc *BlockChain, newBlock *Block) {
+	pruneBlocks(bc)
 
-			if len(mempool) > 0 {
-				goto MineTransactions
-			}
+	for _, node := range knownNodes {
+		if node != nodeAddress {
+			sendInv(node, "block", [][]byte{newBlock.Hash})
 		}
 	}
 }
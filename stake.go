@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+
+	"github.com/boltdb/bolt"
+)
+
+// blockHeaderLen is the size of the header data a block hash is computed
+// over: the parent hash, the merkle root, the timestamp, the target bits and
+// the nonce.
+const blockHeaderLen = 32 + 32 + 8 + 8 + 8
+
+// Stake is an unspent stake or unbonding output.
+type Stake struct {
+	UTXO
+	Address string
+	// Height is that of the block confirming the output.
+	Height int
+	// Unbonding is the number of blocks an unbonding output is locked for,
+	// and zero for bonded stake.
+	Unbonding int64
+}
+
+// stakeChain is what proof of stake reads from a chain: the stakes unspent as
+// of a block, and blocks. A NodeClient provides it.
+type stakeChain interface {
+	ListStakes(blockHash []byte) ([]Stake, error)
+	GetBlock(hash []byte) (*Block, error)
+}
+
+// FindStakes returns the stake and unbonding outputs unspent as of the block
+// with blockHash, which doesn't need to be on the main chain. They are read
+// from the stake index, then taken from the block the UTXO set is at to the
+// one requested with the undo data and bodies of the blocks between them, so
+// none of those may be pruned.
+func (bc *BlockChain) FindStakes(blockHash []byte) ([]Stake, error) {
+	byOutPoint := make(map[string]Stake)
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		blockData := b.Get(blockHash)
+		if blockData == nil {
+			return errors.New("Block is not found.")
+		}
+		undos := tx.Bucket([]byte(undoBucket))
+		index := tx.Bucket([]byte(stakeBucket))
+		if undos == nil || index == nil {
+			return errors.New("the stake index isn't built")
+		}
+
+		err := index.ForEach(func(k, v []byte) error {
+			byOutPoint[string(k)] = deserializeStake(v)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		at := DeserializeBlock(b.Get(undos.Get(utxoTipKey)))
+		connected, disconnected := findReorg(b, at, DeserializeBlock(blockData))
+		for _, block := range disconnected {
+			undoData := undos.Get(block.Hash)
+			if block.Pruned() || undoData == nil {
+				return fmt.Errorf("block %x is pruned", block.Hash)
+			}
+			for _, stake := range deserializeBlockUndo(undoData).Stakes {
+				byOutPoint[OutPoint{stake.TxID, stake.Index}.String()] = stake
+			}
+			for _, tx := range block.Transactions {
+				for outIdx := range tx.Vout {
+					delete(byOutPoint, OutPoint{tx.ID, outIdx}.String())
+				}
+			}
+		}
+		for _, block := range connected {
+			if block.Pruned() {
+				return fmt.Errorf("block %x is pruned", block.Hash)
+			}
+			for _, tx := range block.Transactions {
+				if !tx.IsCoinbase() {
+					for _, vin := range tx.Vin {
+						delete(byOutPoint, OutPoint{vin.Txid, vin.Vout}.String())
+					}
+				}
+				for outIdx := range tx.Vout {
+					if stake, ok := outputStake(tx, outIdx, block.Height); ok {
+						byOutPoint[OutPoint{tx.ID, outIdx}.String()] = stake
+					}
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var stakes []Stake
+	for _, stake := range byOutPoint {
+		stakes = append(stakes, stake)
+	}
+	sort.Slice(stakes, func(i, j int) bool {
+		if stakes[i].Height != stakes[j].Height {
+			return stakes[i].Height < stakes[j].Height
+		}
+		return OutPoint{stakes[i].TxID, stakes[i].Index}.String() < OutPoint{stakes[j].TxID, stakes[j].Index}.String()
+	})
+
+	return stakes, nil
+}
+
+// outputStake returns the stake that output outIdx of tx, confirmed at
+// height, is, if it is a stake or unbonding output.
+func outputStake(tx *Transaction, outIdx, height int) (Stake, bool) {
+	out := tx.Vout[outIdx]
+	pubKeyHash, unbonding, ok := ExtractStake(out.ScriptPubKey)
+	if !ok {
+		return Stake{}, false
+	}
+	address := string(AddressFromPubKeyHash(pubKeyHash))
+
+	return Stake{UTXO{tx.ID, outIdx, out}, address, height, unbonding}, true
+}
+
+func (s Stake) Serialize() []byte {
+	var buf bytes.Buffer
+
+	err := gob.NewEncoder(&buf).Encode(s)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return buf.Bytes()
+}
+
+func deserializeStake(data []byte) Stake {
+	var stake Stake
+
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&stake)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return stake
+}
+
+// Validator sums up the stakes of an address.
+type Validator struct {
+	Address   string
+	Stake     int
+	Unbonding int
+}
+
+// matureStakes returns the stakes that count towards drawing the producer of
+// the block at height: those confirmed StakeMaturity blocks before it, and
+// those of the genesis block, which no stake could be confirmed before.
+func matureStakes(stakes []Stake, height int) []Stake {
+	var mature []Stake
+	for _, stake := range stakes {
+		if stake.Height == 0 || stake.Height+netParams.StakeMaturity <= height {
+			mature = append(mature, stake)
+		}
+	}
+
+	return mature
+}
+
+// Validators groups stakes by address, sorted by address.
+func Validators(stakes []Stake) []Validator {
+	byAddress := make(map[string]*Validator)
+	var addresses []string
+	for _, stake := range stakes {
+		v := byAddress[stake.Address]
+		if v == nil {
+			v = &Validator{Address: stake.Address}
+			byAddress[stake.Address] = v
+			addresses = append(addresses, stake.Address)
+		}
+		if stake.Unbonding == 0 {
+			v.Stake += stake.Output.Value
+		} else {
+			v.Unbonding += stake.Output.Value
+		}
+	}
+	sort.Strings(addresses)
+
+	validators := make([]Validator, 0, len(addresses))
+	for _, address := range addresses {
+		validators = append(validators, *byAddress[address])
+	}
+
+	return validators
+}
+
+// selectProducer picks one of validators with a probability proportional to
+// its bonded stake, drawing from seed. It returns "" when nothing is bonded.
+func selectProducer(validators []Validator, seed []byte) string {
+	total := 0
+	for _, v := range validators {
+		total += v.Stake
+	}
+	if total == 0 {
+		return ""
+	}
+
+	hash := sha256.Sum256(seed)
+	draw := new(big.Int).Mod(new(big.Int).SetBytes(hash[:]), big.NewInt(int64(total))).Int64()
+	for _, v := range validators {
+		draw -= int64(v.Stake)
+		if draw < 0 {
+			return v.Address
+		}
+	}
+
+	return ""
+}
+
+// NewStakeTXOutput bonds value to address.
+func NewStakeTXOutput(value int, address string) (*TXOutput, error) {
+	pubKeyHash, err := DecodePubKeyHashAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TXOutput{value, StakeScript(pubKeyHash)}, nil
+}
+
+// DoubleSign is evidence that the owner of PubKey signed two different blocks
+// on the same parent. Headers hold the data each block hash is computed over.
+type DoubleSign struct {
+	PubKey     []byte
+	Headers    [2][]byte
+	Signatures [2][]byte
+}
+
+// NewDoubleSign returns the evidence two sealed blocks make against their
+// signer.
+func NewDoubleSign(a, b *Block) *DoubleSign {
+	return &DoubleSign{
+		PubKey:     a.PubKey,
+		Headers:    [2][]byte{NewProofOfWork(a).prepareData(a.Nonce), NewProofOfWork(b).prepareData(b.Nonce)},
+		Signatures: [2][]byte{a.Signature, b.Signature},
+	}
+}
+
+// Verify checks that both headers extend the same parent, differ, and are
+// signed by PubKey.
+func (e *DoubleSign) Verify() error {
+	for i, header := range e.Headers {
+		if len(header) != blockHeaderLen {
+			return fmt.Errorf("header %d is %d bytes long", i, len(header))
+		}
+		hash := sha256.Sum256(header)
+		if !VerifySignature(e.PubKey, hash[:], e.Signatures[i]) {
+			return fmt.Errorf("signature of header %d is invalid", i)
+		}
+	}
+	if !bytes.Equal(e.Headers[0][:32], e.Headers[1][:32]) {
+		return errors.New("headers extend different blocks")
+	}
+	if bytes.Equal(e.Headers[0], e.Headers[1]) {
+		return errors.New("headers are the same")
+	}
+
+	return nil
+}
+
+// ScriptSig returns the unlocking script spending the stake of the signer
+// with the evidence: <header> <signature> <header> <signature> <pubKey>
+func (e *DoubleSign) ScriptSig() []byte {
+	return NewScriptBuilder().
+		AddData(e.Headers[0]).AddData(e.Signatures[0]).
+		AddData(e.Headers[1]).AddData(e.Signatures[1]).
+		AddData(e.PubKey).
+		Script()
+}
+
+// ExtractDoubleSign parses the evidence in the unlocking script of a slashing
+// transaction.
+func ExtractDoubleSign(scriptSig []byte) (*DoubleSign, bool) {
+	pushes := pushedData(scriptSig)
+	if len(pushes) != 5 {
+		return nil, false
+	}
+
+	return &DoubleSign{
+		PubKey:     pushes[4],
+		Headers:    [2][]byte{pushes[0], pushes[2]},
+		Signatures: [2][]byte{pushes[1], pushes[3]},
+	}, true
+}
+
+// slashesOutput reports whether scriptSig holds valid evidence against the
+// owner of a stake or unbonding output locked by scriptPubKey.
+func slashesOutput(scriptSig, scriptPubKey []byte) bool {
+	pubKeyHash, _, ok := ExtractStake(scriptPubKey)
+	if !ok {
+		return false
+	}
+	evidence, ok := ExtractDoubleSign(scriptSig)
+	if !ok || !bytes.Equal(HashPubKey(evidence.PubKey), pubKeyHash) {
+		return false
+	}
+
+	return evidence.Verify() == nil
+}
+
+// evidenceCovers reports whether evidence of double signing on a parent at
+// parentHeight can slash an output confirmed at stakeHeight, unbonding for
+// unbonding blocks: stake that existed when its owner signed, and what that
+// stake was unbonded to for the unbonding period after. So evidence doesn't
+// slash stake bonded after it.
+func evidenceCovers(stakeHeight int, unbonding int64, parentHeight int) bool {
+	if stakeHeight <= parentHeight {
+		return true
+	}
+
+	return unbonding != 0 && stakeHeight <= parentHeight+netParams.UnbondingPeriod
+}
+
+// NewSlashTransaction burns stakes, the outputs of the signer of evidence, in
+// a null data output naming its key hash.
+func NewSlashTransaction(evidence *DoubleSign, stakes []Stake) *Transaction {
+	scriptSig := evidence.ScriptSig()
+
+	var inputs []TXInput
+	total := 0
+	for _, stake := range stakes {
+		inputs = append(inputs, TXInput{stake.TxID, stake.Index, scriptSig, SequenceFinal})
+		total += stake.Output.Value
+	}
+	burn := TXOutput{total, NullDataScript(HashPubKey(evidence.PubKey))}
+
+	tx := Transaction{nil, inputs, []TXOutput{burn}, 0}
+	tx.ID = tx.Hash()
+
+	return &tx
+}
+
+// checkStakeSpends enforces what stake can be spent on. Bonded stake can only
+// be moved to stake or unbonding outputs of the same keys, unbonding for at
+// least the unbonding period, so that it stays slashable until then. A
+// transaction slashing stake may spend nothing else, only stake its evidence
+// covers, and must burn all of it. prevTXs must hold the transactions tx
+// spends, and prevHeights the heights of their blocks, keyed alike;
+// blockHeight returns the height of a block by hash.
+func checkStakeSpends(tx *Transaction, prevTXs map[string]Transaction, prevHeights map[string]int, blockHeight func(hash []byte) (int, bool)) error {
+	staked := make(map[string]bool)
+	slashed := 0
+	for inID, vin := range tx.Vin {
+		prevID := hex.EncodeToString(vin.Txid)
+		prevOut := prevTXs[prevID].Vout[vin.Vout]
+		pubKeyHash, unbonding, ok := ExtractStake(prevOut.ScriptPubKey)
+		switch {
+		case !ok:
+		case slashesOutput(vin.ScriptSig, prevOut.ScriptPubKey):
+			evidence, _ := ExtractDoubleSign(vin.ScriptSig)
+			parentHeight, ok := blockHeight(evidence.Headers[0][:32])
+			if !ok {
+				return fmt.Errorf("input %d slashes with evidence on unknown block %x", inID, evidence.Headers[0][:32])
+			}
+			if !evidenceCovers(prevHeights[prevID], unbonding, parentHeight) {
+				return fmt.Errorf("input %d slashes stake confirmed at height %d with evidence from height %d", inID, prevHeights[prevID], parentHeight+1)
+			}
+			slashed++
+		case unbonding == 0:
+			staked[hex.EncodeToString(pubKeyHash)] = true
+		}
+	}
+
+	if slashed > 0 {
+		if slashed != len(tx.Vin) {
+			return errors.New("slashing transaction spends outputs that aren't slashed")
+		}
+		if len(tx.Vout) != 1 || !tx.Vout[0].IsUnspendable() || tx.Fee(prevTXs) != 0 {
+			return errors.New("slashing transaction doesn't burn the slashed stake")
+		}
+		return nil
+	}
+
+	if len(staked) == 0 {
+		return nil
+	}
+	for i, out := range tx.Vout {
+		pubKeyHash, unbonding, ok := ExtractStake(out.ScriptPubKey)
+		if !ok || !staked[hex.EncodeToString(pubKeyHash)] {
+			return fmt.Errorf("output %d moves stake to another key", i)
+		}
+		if unbonding != 0 && unbonding < int64(netParams.UnbondingPeriod) {
+			return fmt.Errorf("output %d unbonds stake for %d blocks, less than %d", i, unbonding, netParams.UnbondingPeriod)
+		}
+	}
+
+	return nil
+}
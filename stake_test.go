@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// TestFindStakes reads the stakes of blocks on and off the main chain from
+// the stake index, before and after the chain switches branches.
+func TestFindStakes(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	genesis := genesisCoinbase(t, bc)
+	genesisBlock := tipBlock(t, bc)
+
+	stake, err := NewStakeTXOutput(subsidy, string(wallet.GetAddress()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bond := Transaction{nil, []TXInput{{genesis.ID, 0, nil, SequenceFinal}}, []TXOutput{*stake}, 0}
+	bond.ID = bond.Hash()
+	bond.Sign(wallet.PrivateKey, map[string]Transaction{hex.EncodeToString(genesis.ID): *genesis})
+	bonded := newTestBlock(genesisBlock, wallet, &bond)
+	addTestBlock(t, bc, bonded)
+
+	side := newTestBlock(genesisBlock, wallet)
+	addTestBlock(t, bc, side)
+
+	checkStakes := func(block *Block, want int) {
+		t.Helper()
+
+		stakes, err := bc.FindStakes(block.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(stakes) != want {
+			t.Fatalf("%d stakes as of block %x, want %d", len(stakes), block.Hash, want)
+		}
+		if want > 0 && (stakes[0].Height != 1 || stakes[0].Output.Value != subsidy) {
+			t.Errorf("stake is %d at height %d, want %d at height 1", stakes[0].Output.Value, stakes[0].Height, subsidy)
+		}
+	}
+	checkStakes(genesisBlock, 0)
+	checkStakes(bonded, 1)
+	checkStakes(side, 0)
+
+	// The side chain takes over, and the stake leaves the index.
+	side = newTestBlock(side, wallet)
+	addTestBlock(t, bc, side)
+	checkStakes(bonded, 1)
+	checkStakes(side, 0)
+
+	UTXOSet := UTXOSet{bc}
+	UTXOSet.Reindex()
+	checkStakes(bonded, 1)
+	checkStakes(side, 0)
+}
+
+func TestSelectProducer(t *testing.T) {
+	seed := []byte("seed")
+	tests := []struct {
+		name       string
+		validators []Validator
+		want       string
+	}{
+		{"nothing bonded", nil, ""},
+		{"only unbonding", []Validator{{"a", 0, 10}}, ""},
+		{"single validator", []Validator{{"a", 10, 0}}, "a"},
+		{"skips validators without stake", []Validator{{"a", 0, 10}, {"b", 5, 0}, {"c", 0, 0}}, "b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := selectProducer(tt.validators, seed); got != tt.want {
+				t.Errorf("selectProducer() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("proportional to stake", func(t *testing.T) {
+		validators := []Validator{{"a", 30, 0}, {"b", 10, 0}}
+		picks := make(map[string]int)
+		for i := 0; i < 1000; i++ {
+			picks[selectProducer(validators, IntToHex(int64(i)))]++
+		}
+		if picks["a"] < 700 || picks["a"] > 800 || picks["a"]+picks["b"] != 1000 {
+			t.Errorf("picked %v of 1000, want about 750 a and 250 b", picks)
+		}
+	})
+}
+
+func TestMatureStakes(t *testing.T) {
+	stakes := []Stake{{Height: 0}, {Height: 5}, {Height: 20 - netParams.StakeMaturity}, {Height: 20 - netParams.StakeMaturity + 1}}
+	tests := []struct {
+		name   string
+		height int
+		want   int
+	}{
+		{"genesis stake only", 1, 1},
+		{"matures at StakeMaturity", 20, 3},
+		{"all mature", 20 + 1, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := len(matureStakes(stakes, tt.height)); got != tt.want {
+				t.Errorf("%d stakes mature at height %d, want %d", got, tt.height, tt.want)
+			}
+		})
+	}
+}
+
+// testStakeChain holds stakes, unspent as of every block, and blocks.
+type testStakeChain struct {
+	stakes []Stake
+	blocks map[string]*Block
+}
+
+func (c *testStakeChain) ListStakes(blockHash []byte) ([]Stake, error) {
+	return c.stakes, nil
+}
+
+func (c *testStakeChain) GetBlock(hash []byte) (*Block, error) {
+	if block, ok := c.blocks[hex.EncodeToString(hash)]; ok {
+		return block, nil
+	}
+	return nil, errors.New("Block is not found.")
+}
+
+// TestProofOfStakeSeed draws producers with the block StakeMaturity blocks
+// below the parent, so the parent's own hash doesn't change the draw.
+func TestProofOfStakeSeed(t *testing.T) {
+	chain := &testStakeChain{blocks: make(map[string]*Block)}
+	genesis := &Block{Hash: []byte("genesis")}
+	chain.blocks[hex.EncodeToString(genesis.Hash)] = genesis
+	parent := genesis
+	for i := 1; i <= netParams.StakeMaturity+1; i++ {
+		parent = &Block{Hash: []byte(fmt.Sprintf("block %d", i)), PrevBlockHash: parent.Hash, Height: i}
+		chain.blocks[hex.EncodeToString(parent.Hash)] = parent
+	}
+	e, err := NewProofOfStake(0, testNodeID, chain, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seed, err := e.seed(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append([]byte("block 1"), IntToHex(int64(parent.Height+1))...)
+	if !bytes.Equal(seed, want) {
+		t.Errorf("seed is %q, want %q", seed, want)
+	}
+
+	// A sibling of parent, sealed by the same producer, draws the same.
+	sibling := *parent
+	sibling.Hash = []byte("sibling")
+	siblingSeed, err := e.seed(&sibling)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(siblingSeed, seed) {
+		t.Errorf("sibling seed is %q, want %q", siblingSeed, seed)
+	}
+
+	// Near the genesis block, the draw is seeded by it.
+	seed, err = e.seed(chain.blocks[hex.EncodeToString([]byte("block 1"))])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append([]byte("genesis"), IntToHex(2)...); !bytes.Equal(seed, want) {
+		t.Errorf("seed is %q, want %q", seed, want)
+	}
+}
+
+// TestProofOfStakeSealOnce refuses to sign a second block on a parent, even
+// after the engine is recreated over the same database.
+func TestProofOfStakeSealOnce(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	parent := tipBlock(t, bc)
+	chain := &testStakeChain{
+		stakes: []Stake{{UTXO: UTXO{Output: TXOutput{Value: subsidy}}, Address: string(wallet.GetAddress())}},
+		blocks: map[string]*Block{hex.EncodeToString(parent.Hash): parent},
+	}
+
+	seal := func() error {
+		t.Helper()
+
+		e, err := NewProofOfStake(0, testNodeID, chain, bc.db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block := newTestBlock(parent, wallet)
+		block.Timestamp = parent.Timestamp
+		return e.Seal(context.Background(), parent, block)
+	}
+	checkError(t, seal(), "")
+	checkError(t, seal(), "is already signed")
+}
+
+func TestDoubleSignVerify(t *testing.T) {
+	signer, other := NewWallet(), NewWallet()
+	parentHash := sha256.Sum256([]byte("parent"))
+	parent := &Block{Timestamp: 1000, Hash: parentHash[:]}
+	otherHash := sha256.Sum256([]byte("other parent"))
+	otherParent := &Block{Timestamp: 1000, Hash: otherHash[:]}
+
+	sealed := func(parent *Block, wallet *Wallet, offset int64) *Block {
+		block := newTestBlock(parent, signer)
+		block.Timestamp += offset
+		block.Hash = headerHash(block)
+		block.PubKey = wallet.PublicKey
+		block.Signature = SignHash(wallet.PrivateKey, block.Hash)
+		return block
+	}
+	a := sealed(parent, signer, 0)
+
+	tests := []struct {
+		name     string
+		evidence *DoubleSign
+		errMsg   string
+	}{
+		{"two blocks on one parent", NewDoubleSign(a, sealed(parent, signer, 1)), ""},
+		{"the same block twice", NewDoubleSign(a, a), "headers are the same"},
+		{"different parents", NewDoubleSign(a, sealed(otherParent, signer, 0)), "different blocks"},
+		{"signed by another key", NewDoubleSign(a, sealed(parent, other, 1)), "signature of header 1 is invalid"},
+		{"truncated header", &DoubleSign{signer.PublicKey, [2][]byte{NewDoubleSign(a, a).Headers[0][:10], nil}, [2][]byte{a.Signature, a.Signature}}, "header 0 is 10 bytes long"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkError(t, tt.evidence.Verify(), tt.errMsg)
+		})
+	}
+}
+
+func TestCheckStakeSpends(t *testing.T) {
+	signer, other := NewWallet(), NewWallet()
+	signerHash, otherHash := HashPubKey(signer.PublicKey), HashPubKey(other.PublicKey)
+
+	// doubleSign returns evidence of signer sealing two blocks on parent.
+	doubleSign := func(parent *Block) []byte {
+		a := newTestBlock(parent, signer)
+		b := newTestBlock(parent, signer)
+		b.Timestamp++
+		b.Hash = headerHash(b)
+		b.Signature = SignHash(signer.PrivateKey, b.Hash)
+		return NewDoubleSign(a, b).ScriptSig()
+	}
+	parentHash := sha256.Sum256([]byte("parent"))
+	parent := &Block{Timestamp: 1000, Hash: parentHash[:], Height: 50}
+	unknownHash := sha256.Sum256([]byte("unknown parent"))
+	evidence := doubleSign(parent)
+	unknownEvidence := doubleSign(&Block{Timestamp: 1000, Hash: unknownHash[:]})
+	blockHeight := func(hash []byte) (int, bool) {
+		return parent.Height, bytes.Equal(hash, parent.Hash)
+	}
+
+	prevTXs := make(map[string]Transaction)
+	prevHeights := make(map[string]int)
+	// confirm returns a transaction with outputs confirmed at height.
+	confirm := func(height int, outputs ...TXOutput) Transaction {
+		tx := Transaction{Vout: outputs, LockTime: int64(height)}
+		tx.ID = tx.Hash()
+		prevTXs[hex.EncodeToString(tx.ID)] = tx
+		prevHeights[hex.EncodeToString(tx.ID)] = height
+		return tx
+	}
+	prev := confirm(40,
+		TXOutput{10, StakeScript(signerHash)},
+		TXOutput{10, UnbondingScript(int64(netParams.UnbondingPeriod), signerHash)},
+		TXOutput{10, PayToPubKeyHashScript(signerHash)},
+		TXOutput{10, StakeScript(otherHash)},
+	)
+	later := confirm(parent.Height+1,
+		TXOutput{10, StakeScript(signerHash)},
+		TXOutput{10, UnbondingScript(int64(netParams.UnbondingPeriod), signerHash)},
+	)
+	muchLater := confirm(parent.Height+netParams.UnbondingPeriod+1,
+		TXOutput{10, UnbondingScript(int64(netParams.UnbondingPeriod), signerHash)},
+	)
+
+	spend := func(vout int) TXInput { return TXInput{prev.ID, vout, nil, SequenceFinal} }
+	slash := func(tx Transaction, vout int) TXInput { return TXInput{tx.ID, vout, evidence, SequenceFinal} }
+	burn := func(value int) TXOutput { return TXOutput{value, NullDataScript(signerHash)} }
+
+	tests := []struct {
+		name    string
+		inputs  []TXInput
+		outputs []TXOutput
+		errMsg  string
+	}{
+		{"spends no stake", []TXInput{spend(2)}, []TXOutput{{10, PayToPubKeyHashScript(otherHash)}}, ""},
+		{"restakes", []TXInput{spend(0), spend(2)}, []TXOutput{{20, StakeScript(signerHash)}}, ""},
+		{"moves stake to another key", []TXInput{spend(0)}, []TXOutput{{10, StakeScript(otherHash)}}, "output 0 moves stake to another key"},
+		{"pays stake out", []TXInput{spend(0)}, []TXOutput{{10, PayToPubKeyHashScript(signerHash)}}, "output 0 moves stake"},
+		{"unbonds", []TXInput{spend(0)}, []TXOutput{{10, UnbondingScript(int64(netParams.UnbondingPeriod), signerHash)}}, ""},
+		{"unbonds too briefly", []TXInput{spend(0)}, []TXOutput{{10, UnbondingScript(int64(netParams.UnbondingPeriod-1), signerHash)}}, "less than"},
+		{"withdraws unbonded stake", []TXInput{spend(1)}, []TXOutput{{10, PayToPubKeyHashScript(signerHash)}}, ""},
+		{"slashes", []TXInput{slash(prev, 0), slash(prev, 1)}, []TXOutput{burn(20)}, ""},
+		{"slashes with another input", []TXInput{slash(prev, 0), spend(2)}, []TXOutput{burn(20)}, "aren't slashed"},
+		{"slashes paying out", []TXInput{slash(prev, 0)}, []TXOutput{{10, PayToPubKeyHashScript(otherHash)}}, "doesn't burn"},
+		{"slashes paying a fee", []TXInput{slash(prev, 0)}, []TXOutput{burn(9)}, "doesn't burn"},
+		{"slashes another key's stake", []TXInput{slash(prev, 3)}, []TXOutput{burn(10)}, "moves stake to another key"},
+		{"slashes stake bonded after signing", []TXInput{slash(later, 0)}, []TXOutput{burn(10)}, "with evidence from height 51"},
+		{"slashes stake unbonded after signing", []TXInput{slash(later, 1)}, []TXOutput{burn(10)}, ""},
+		{"slashes stake unbonded after the unbonding period", []TXInput{slash(muchLater, 0)}, []TXOutput{burn(10)}, "with evidence from height 51"},
+		{"slashes with evidence on an unknown block", []TXInput{{prev.ID, 0, unknownEvidence, SequenceFinal}}, []TXOutput{burn(10)}, "unknown block"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := &Transaction{nil, tt.inputs, tt.outputs, 0}
+			checkError(t, checkStakeSpends(tx, prevTXs, prevHeights, blockHeight), tt.errMsg)
+		})
+	}
+}
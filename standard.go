@@ -0,0 +1,459 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+)
+
+type ScriptClass int
+
+const (
+	NonStandardTy ScriptClass = iota
+	PubKeyHashTy
+	PubKeyTy
+	MultiSigTy
+	HashLockTy
+	TimeLockTy
+	ScriptHashTy
+	HTLCTy
+	NullDataTy
+	StakeTy
+	UnbondingTy
+)
+
+var scriptClassNames = map[ScriptClass]string{
+	NonStandardTy: "nonstandard",
+	PubKeyHashTy:  "pubkeyhash",
+	PubKeyTy:      "pubkey",
+	MultiSigTy:    "multisig",
+	HashLockTy:    "hashlock",
+	TimeLockTy:    "timelock",
+	ScriptHashTy:  "scripthash",
+	HTLCTy:        "htlc",
+	NullDataTy:    "nulldata",
+	StakeTy:       "stake",
+	UnbondingTy:   "unbonding",
+}
+
+func (c ScriptClass) String() string {
+	return scriptClassNames[c]
+}
+
+const (
+	pubKeyLen     = 64
+	pubKeyHashLen = 20
+	htlcSecretLen = 32
+
+	// maxDataCarrierSize is the most data a null data output may carry.
+	maxDataCarrierSize = 80
+)
+
+// PayToPubKeyHashScript locks to the owner of the key hashing to pubKeyHash:
+// OP_DUP OP_HASH160 <pubKeyHash> OP_EQUALVERIFY OP_CHECKSIG
+func PayToPubKeyHashScript(pubKeyHash []byte) []byte {
+	return NewScriptBuilder().AddOp(OP_DUP).AddOp(OP_HASH160).AddData(pubKeyHash).
+		AddOp(OP_EQUALVERIFY).AddOp(OP_CHECKSIG).Script()
+}
+
+// PayToPubKeyScript locks directly to a public key: <pubKey> OP_CHECKSIG
+func PayToPubKeyScript(pubKey []byte) []byte {
+	return NewScriptBuilder().AddData(pubKey).AddOp(OP_CHECKSIG).Script()
+}
+
+// MultiSigScript requires m signatures from pubKeys:
+// <m> <pubKey>... <n> OP_CHECKMULTISIG
+func MultiSigScript(m int, pubKeys [][]byte) []byte {
+	sb := NewScriptBuilder().AddInt64(int64(m))
+	for _, pubKey := range pubKeys {
+		sb.AddData(pubKey)
+	}
+
+	return sb.AddInt64(int64(len(pubKeys))).AddOp(OP_CHECKMULTISIG).Script()
+}
+
+// HashLockScript requires the SHA-256 preimage of hash and a signature from
+// pubKeyHash: OP_SHA256 <hash> OP_EQUALVERIFY <P2PKH>
+func HashLockScript(hash, pubKeyHash []byte) []byte {
+	prefix := NewScriptBuilder().AddOp(OP_SHA256).AddData(hash).AddOp(OP_EQUALVERIFY).Script()
+
+	return append(prefix, PayToPubKeyHashScript(pubKeyHash)...)
+}
+
+// TimeLockScript makes a pay-to-pubkey-hash output unspendable before
+// lockTime: <lockTime> OP_CHECKLOCKTIMEVERIFY OP_DROP <P2PKH>
+func TimeLockScript(lockTime int64, pubKeyHash []byte) []byte {
+	prefix := NewScriptBuilder().AddInt64(lockTime).AddOp(OP_CHECKLOCKTIMEVERIFY).AddOp(OP_DROP).Script()
+
+	return append(prefix, PayToPubKeyHashScript(pubKeyHash)...)
+}
+
+// HTLC holds the terms of a hash time-locked contract: the recipient can
+// claim the funds by revealing the preimage of SecretHash, and once LockTime
+// has passed the refund key can take them back.
+type HTLC struct {
+	SecretHash    []byte
+	RecipientHash []byte
+	RefundHash    []byte
+	LockTime      int64
+}
+
+// Script returns the contract as a script:
+//
+//	OP_IF
+//	    OP_SIZE 32 OP_EQUALVERIFY OP_SHA256 <secretHash> OP_EQUALVERIFY
+//	    OP_DUP OP_HASH160 <recipientHash>
+//	OP_ELSE
+//	    <lockTime> OP_CHECKLOCKTIMEVERIFY OP_DROP
+//	    OP_DUP OP_HASH160 <refundHash>
+//	OP_ENDIF
+//	OP_EQUALVERIFY OP_CHECKSIG
+//
+// The secret size is fixed so that a secret accepted on one chain is accepted
+// by the matching contract on the other.
+func (h *HTLC) Script() []byte {
+	return NewScriptBuilder().
+		AddOp(OP_IF).
+		AddOp(OP_SIZE).AddInt64(htlcSecretLen).AddOp(OP_EQUALVERIFY).
+		AddOp(OP_SHA256).AddData(h.SecretHash).AddOp(OP_EQUALVERIFY).
+		AddOp(OP_DUP).AddOp(OP_HASH160).AddData(h.RecipientHash).
+		AddOp(OP_ELSE).
+		AddInt64(h.LockTime).AddOp(OP_CHECKLOCKTIMEVERIFY).AddOp(OP_DROP).
+		AddOp(OP_DUP).AddOp(OP_HASH160).AddData(h.RefundHash).
+		AddOp(OP_ENDIF).
+		AddOp(OP_EQUALVERIFY).AddOp(OP_CHECKSIG).
+		Script()
+}
+
+// NullDataScript makes a provably unspendable output carrying data:
+// OP_RETURN <data>
+func NullDataScript(data []byte) []byte {
+	return NewScriptBuilder().AddOp(OP_RETURN).AddData(data).Script()
+}
+
+// StakeScript bonds coins to the owner of pubKeyHash for proof of stake:
+// OP_STAKE <P2PKH>
+func StakeScript(pubKeyHash []byte) []byte {
+	return append([]byte{OP_STAKE}, PayToPubKeyHashScript(pubKeyHash)...)
+}
+
+// UnbondingScript holds unstaked coins for blocks blocks after they are
+// unstaked, while they can still be slashed:
+// OP_STAKE <blocks> OP_CHECKSEQUENCEVERIFY OP_DROP <P2PKH>
+func UnbondingScript(blocks int64, pubKeyHash []byte) []byte {
+	prefix := NewScriptBuilder().AddOp(OP_STAKE).AddInt64(blocks).AddOp(OP_CHECKSEQUENCEVERIFY).AddOp(OP_DROP).Script()
+
+	return append(prefix, PayToPubKeyHashScript(pubKeyHash)...)
+}
+
+// PayToScriptHashScript locks to whoever reveals a redeem script hashing to
+// scriptHash and satisfies it: OP_HASH160 <scriptHash> OP_EQUAL
+func PayToScriptHashScript(scriptHash []byte) []byte {
+	return NewScriptBuilder().AddOp(OP_HASH160).AddData(scriptHash).AddOp(OP_EQUAL).Script()
+}
+
+func isPubKeyHashOps(ops []ScriptOp) bool {
+	return len(ops) == 5 &&
+		ops[0].Opcode == OP_DUP &&
+		ops[1].Opcode == OP_HASH160 &&
+		len(ops[2].Data) == pubKeyHashLen &&
+		ops[3].Opcode == OP_EQUALVERIFY &&
+		ops[4].Opcode == OP_CHECKSIG
+}
+
+func isPubKeyOps(ops []ScriptOp) bool {
+	return len(ops) == 2 && len(ops[0].Data) == pubKeyLen && ops[1].Opcode == OP_CHECKSIG
+}
+
+func isSmallInt(op ScriptOp) bool {
+	return op.Opcode >= OP_1 && op.Opcode <= OP_16
+}
+
+func smallIntValue(op ScriptOp) int {
+	return int(op.Opcode-OP_1) + 1
+}
+
+func isMultiSigOps(ops []ScriptOp) bool {
+	if len(ops) < 4 || !isSmallInt(ops[0]) || !isSmallInt(ops[len(ops)-2]) ||
+		ops[len(ops)-1].Opcode != OP_CHECKMULTISIG {
+		return false
+	}
+
+	m, n := smallIntValue(ops[0]), smallIntValue(ops[len(ops)-2])
+	if m > n || len(ops) != n+3 {
+		return false
+	}
+
+	for _, op := range ops[1 : n+1] {
+		if len(op.Data) != pubKeyLen {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isHashLockOps(ops []ScriptOp) bool {
+	return len(ops) == 8 &&
+		ops[0].Opcode == OP_SHA256 &&
+		len(ops[1].Data) == sha256.Size &&
+		ops[2].Opcode == OP_EQUALVERIFY &&
+		isPubKeyHashOps(ops[3:])
+}
+
+func isTimeLockOps(ops []ScriptOp) bool {
+	return len(ops) == 8 &&
+		(ops[0].Data != nil || isSmallInt(ops[0])) &&
+		ops[1].Opcode == OP_CHECKLOCKTIMEVERIFY &&
+		ops[2].Opcode == OP_DROP &&
+		isPubKeyHashOps(ops[3:])
+}
+
+func isScriptHashOps(ops []ScriptOp) bool {
+	return len(ops) == 3 &&
+		ops[0].Opcode == OP_HASH160 &&
+		len(ops[1].Data) == pubKeyHashLen &&
+		ops[2].Opcode == OP_EQUAL
+}
+
+func isHTLCOps(ops []ScriptOp) bool {
+	return len(ops) == 20 &&
+		ops[0].Opcode == OP_IF &&
+		ops[1].Opcode == OP_SIZE &&
+		bytes.Equal(ops[2].Data, scriptNumBytes(htlcSecretLen)) &&
+		ops[3].Opcode == OP_EQUALVERIFY &&
+		ops[4].Opcode == OP_SHA256 &&
+		len(ops[5].Data) == sha256.Size &&
+		ops[6].Opcode == OP_EQUALVERIFY &&
+		ops[7].Opcode == OP_DUP &&
+		ops[8].Opcode == OP_HASH160 &&
+		len(ops[9].Data) == pubKeyHashLen &&
+		ops[10].Opcode == OP_ELSE &&
+		(ops[11].Data != nil || isSmallInt(ops[11])) &&
+		ops[12].Opcode == OP_CHECKLOCKTIMEVERIFY &&
+		ops[13].Opcode == OP_DROP &&
+		ops[14].Opcode == OP_DUP &&
+		ops[15].Opcode == OP_HASH160 &&
+		len(ops[16].Data) == pubKeyHashLen &&
+		ops[17].Opcode == OP_ENDIF &&
+		ops[18].Opcode == OP_EQUALVERIFY &&
+		ops[19].Opcode == OP_CHECKSIG
+}
+
+func isStakeOps(ops []ScriptOp) bool {
+	return len(ops) == 6 && ops[0].Opcode == OP_STAKE && isPubKeyHashOps(ops[1:])
+}
+
+func isUnbondingOps(ops []ScriptOp) bool {
+	return len(ops) == 9 &&
+		ops[0].Opcode == OP_STAKE &&
+		(ops[1].Data != nil || isSmallInt(ops[1])) &&
+		ops[2].Opcode == OP_CHECKSEQUENCEVERIFY &&
+		ops[3].Opcode == OP_DROP &&
+		isPubKeyHashOps(ops[4:])
+}
+
+func isNullDataOps(ops []ScriptOp) bool {
+	return len(ops) == 2 &&
+		ops[0].Opcode == OP_RETURN &&
+		ops[1].isPush() &&
+		len(ops[1].Data) <= maxDataCarrierSize
+}
+
+// IsUnspendableScript reports whether no unlocking script can satisfy
+// script, so that outputs locked by it can be left out of the UTXO set.
+func IsUnspendableScript(script []byte) bool {
+	return (len(script) > 0 && script[0] == OP_RETURN) || len(script) > maxScriptSize
+}
+
+func ClassifyScript(script []byte) ScriptClass {
+	ops, err := ParseScript(script)
+	if err != nil {
+		return NonStandardTy
+	}
+
+	switch {
+	case isPubKeyHashOps(ops):
+		return PubKeyHashTy
+	case isPubKeyOps(ops):
+		return PubKeyTy
+	case isMultiSigOps(ops):
+		return MultiSigTy
+	case isHashLockOps(ops):
+		return HashLockTy
+	case isTimeLockOps(ops):
+		return TimeLockTy
+	case isScriptHashOps(ops):
+		return ScriptHashTy
+	case isHTLCOps(ops):
+		return HTLCTy
+	case isNullDataOps(ops):
+		return NullDataTy
+	case isStakeOps(ops):
+		return StakeTy
+	case isUnbondingOps(ops):
+		return UnbondingTy
+	}
+
+	return NonStandardTy
+}
+
+// ExtractPubKeyHash returns the hash of the key that can spend script alone,
+// or nil when the script isn't locked to a single key.
+func ExtractPubKeyHash(script []byte) []byte {
+	ops, err := ParseScript(script)
+	if err != nil {
+		return nil
+	}
+
+	switch {
+	case isPubKeyHashOps(ops):
+		return ops[2].Data
+	case isPubKeyOps(ops):
+		return HashPubKey(ops[0].Data)
+	}
+
+	return nil
+}
+
+// ExtractScriptHash returns the redeem script hash a pay-to-script-hash
+// script commits to, or nil for any other script.
+func ExtractScriptHash(script []byte) []byte {
+	ops, err := ParseScript(script)
+	if err != nil || !isScriptHashOps(ops) {
+		return nil
+	}
+
+	return ops[1].Data
+}
+
+// ExtractMultiSig returns the threshold and keys of a multisig script.
+func ExtractMultiSig(script []byte) (int, [][]byte, bool) {
+	ops, err := ParseScript(script)
+	if err != nil || !isMultiSigOps(ops) {
+		return 0, nil, false
+	}
+
+	var pubKeys [][]byte
+	for _, op := range ops[1 : len(ops)-2] {
+		pubKeys = append(pubKeys, op.Data)
+	}
+
+	return smallIntValue(ops[0]), pubKeys, true
+}
+
+// ExtractHTLC returns the terms of a hash time-locked contract script.
+func ExtractHTLC(script []byte) (*HTLC, bool) {
+	ops, err := ParseScript(script)
+	if err != nil || !isHTLCOps(ops) {
+		return nil, false
+	}
+
+	lockTime := int64(0)
+	if isSmallInt(ops[11]) {
+		lockTime = int64(smallIntValue(ops[11]))
+	} else {
+		lockTime, err = parseScriptNum(ops[11].Data, maxLockTimeNumLen)
+		if err != nil {
+			return nil, false
+		}
+	}
+
+	return &HTLC{ops[5].Data, ops[9].Data, ops[16].Data, lockTime}, true
+}
+
+// ExtractStake returns the key hash of a stake or unbonding script and, for
+// an unbonding script, the blocks it holds the coins for after they are
+// unstaked. The blocks are zero for bonded stake.
+func ExtractStake(script []byte) ([]byte, int64, bool) {
+	ops, err := ParseScript(script)
+	if err != nil {
+		return nil, 0, false
+	}
+
+	switch {
+	case isStakeOps(ops):
+		return ops[3].Data, 0, true
+	case isUnbondingOps(ops):
+		blocks := int64(0)
+		if isSmallInt(ops[1]) {
+			blocks = int64(smallIntValue(ops[1]))
+		} else {
+			blocks, err = parseScriptNum(ops[1].Data, maxLockTimeNumLen)
+			if err != nil || blocks <= 0 {
+				return nil, 0, false
+			}
+		}
+		return ops[6].Data, blocks, true
+	}
+
+	return nil, 0, false
+}
+
+// ExtractNullData returns the data carried by a null data script.
+func ExtractNullData(script []byte) ([]byte, bool) {
+	ops, err := ParseScript(script)
+	if err != nil || !isNullDataOps(ops) {
+		return nil, false
+	}
+
+	return ops[1].Data, true
+}
+
+// pushedData returns the data pushed by a push-only script such as a
+// scriptSig.
+func pushedData(script []byte) [][]byte {
+	ops, err := ParseScript(script)
+	if err != nil {
+		return nil
+	}
+
+	var data [][]byte
+	for _, op := range ops {
+		if !op.isPush() {
+			return nil
+		}
+		data = append(data, op.Data)
+	}
+
+	return data
+}
+
+// scriptUsesKey reports whether scriptSig reveals a public key hashing to
+// pubKeyHash.
+func scriptUsesKey(scriptSig, pubKeyHash []byte) bool {
+	for _, data := range pushedData(scriptSig) {
+		if len(data) > 0 && bytes.Equal(HashPubKey(data), pubKeyHash) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CountSigOps returns the number of signature checks script can make. A
+// multisig check counts as many keys as it names when accurate is set and
+// the key count is pushed right before it, and as the maximum otherwise, as
+// when counting the scripts of outputs not yet spent. Scripts that don't
+// parse count as none, since they fail anyway.
+func CountSigOps(script []byte, accurate bool) int {
+	ops, err := ParseScript(script)
+	if err != nil {
+		return 0
+	}
+
+	count := 0
+	for i, op := range ops {
+		switch op.Opcode {
+		case OP_CHECKSIG, OP_CHECKSIGVERIFY:
+			count++
+		case OP_CHECKMULTISIG, OP_CHECKMULTISIGVERIFY:
+			if accurate && i > 0 && isSmallInt(ops[i-1]) {
+				count += smallIntValue(ops[i-1])
+			} else {
+				count += maxPubKeysPerMultisig
+			}
+		}
+	}
+
+	return count
+}
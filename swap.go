@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"log"
+)
+
+// Atomic swaps lock coins on two chains in hash time-locked contracts behind
+// pay-to-script-hash addresses. The initiator knows the secret and redeems
+// the participant's contract first, which reveals the secret on that chain;
+// the participant then uses it to redeem the initiator's contract. If either
+// side walks away, the contracts are refunded after their lock times, the
+// initiator's being the later one.
+
+// NewSwapSecret returns a random swap secret and its hash.
+func NewSwapSecret() ([]byte, []byte) {
+	secret := make([]byte, htlcSecretLen)
+	_, err := rand.Read(secret)
+	if err != nil {
+		log.Panic(err)
+	}
+	hash := sha256.Sum256(secret)
+
+	return secret, hash[:]
+}
+
+// FindContractOutput returns the index of the output of contractTx that pays
+// to the script hash of contract.
+func FindContractOutput(contractTx *Transaction, contract []byte) (int, error) {
+	script := PayToScriptHashScript(HashPubKey(contract))
+	for i, out := range contractTx.Vout {
+		if bytes.Equal(out.ScriptPubKey, script) {
+			return i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("transaction %x doesn't pay to the contract", contractTx.ID)
+}
+
+// NewHTLCRedeemTransaction claims the contract output of contractTx for the
+// recipient, whose wallet signs it, by revealing secret.
+func NewHTLCRedeemTransaction(contractTx *Transaction, contract, secret []byte, wallet *Wallet) (*Transaction, error) {
+	htlc, ok := ExtractHTLC(contract)
+	if !ok {
+		return nil, fmt.Errorf("%x is not a swap contract", contract)
+	}
+	hash := sha256.Sum256(secret)
+	if !bytes.Equal(hash[:], htlc.SecretHash) {
+		return nil, fmt.Errorf("secret doesn't hash to %x", htlc.SecretHash)
+	}
+	if !bytes.Equal(HashPubKey(wallet.PublicKey), htlc.RecipientHash) {
+		return nil, fmt.Errorf("wallet is not the recipient of the contract")
+	}
+
+	branch := NewScriptBuilder().AddData(secret).AddInt64(1).Script()
+
+	return newHTLCSpend(contractTx, contract, htlc.RecipientHash, 0, branch, wallet)
+}
+
+// NewHTLCRefundTransaction returns the contract output of contractTx to the
+// refund key, whose wallet signs it. It can only be mined after the contract
+// lock time.
+func NewHTLCRefundTransaction(contractTx *Transaction, contract []byte, wallet *Wallet) (*Transaction, error) {
+	htlc, ok := ExtractHTLC(contract)
+	if !ok {
+		return nil, fmt.Errorf("%x is not a swap contract", contract)
+	}
+	if !bytes.Equal(HashPubKey(wallet.PublicKey), htlc.RefundHash) {
+		return nil, fmt.Errorf("wallet is not the refund address of the contract")
+	}
+
+	branch := NewScriptBuilder().AddInt64(0).Script()
+
+	return newHTLCSpend(contractTx, contract, htlc.RefundHash, htlc.LockTime, branch, wallet)
+}
+
+// newHTLCSpend moves the contract output to pubKeyHash. The unlocking script
+// is <sig> <pubKey> <branch...> <contract>, where branch selects the path
+// through the contract.
+func newHTLCSpend(contractTx *Transaction, contract, pubKeyHash []byte, lockTime int64, branch []byte, wallet *Wallet) (*Transaction, error) {
+	vout, err := FindContractOutput(contractTx, contract)
+	if err != nil {
+		return nil, err
+	}
+	prevOut := contractTx.Vout[vout]
+
+	input := TXInput{contractTx.ID, vout, nil, 0}
+	output := TXOutput{prevOut.Value, PayToPubKeyHashScript(pubKeyHash)}
+	tx := Transaction{nil, []TXInput{input}, []TXOutput{output}, lockTime}
+	tx.ID = tx.Hash()
+
+	signature := SignHash(wallet.PrivateKey, tx.SignatureHash(0, prevOut.ScriptPubKey))
+	scriptSig := NewScriptBuilder().AddData(signature).AddData(wallet.PublicKey).Script()
+	scriptSig = append(scriptSig, branch...)
+	tx.Vin[0].ScriptSig = append(scriptSig, NewScriptBuilder().AddData(contract).Script()...)
+
+	return &tx, nil
+}
+
+// ExtractSwapSecret finds the preimage of secretHash revealed by a
+// transaction redeeming a swap contract.
+func ExtractSwapSecret(tx *Transaction, secretHash []byte) ([]byte, error) {
+	for _, vin := range tx.Vin {
+		for _, data := range pushedData(vin.ScriptSig) {
+			hash := sha256.Sum256(data)
+			if len(data) == htlcSecretLen && bytes.Equal(hash[:], secretHash) {
+				return data, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("transaction %x doesn't reveal the secret of %x", tx.ID, secretHash)
+}
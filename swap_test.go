@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+// TestHTLCSpends spends a swap contract through its hashlock branch, with
+// the secret, and its timeout branch, after the lock time.
+func TestHTLCSpends(t *testing.T) {
+	recipient, refunder := NewWallet(), NewWallet()
+	secret, secretHash := NewSwapSecret()
+	shortSecret := []byte("short secret")
+	shortHash := sha256.Sum256(shortSecret)
+	const lockTime = 500
+
+	// newContract returns a swap contract locked to secretHash and a
+	// transaction paying to it.
+	newContract := func(secretHash []byte) ([]byte, *Transaction) {
+		htlc := &HTLC{secretHash, HashPubKey(recipient.PublicKey), HashPubKey(refunder.PublicKey), lockTime}
+		contract := htlc.Script()
+		contractTx := &Transaction{Vout: []TXOutput{{10, PayToScriptHashScript(HashPubKey(contract))}}}
+		contractTx.ID = contractTx.Hash()
+
+		return contract, contractTx
+	}
+	contract, contractTx := newContract(secretHash)
+	shortContract, shortContractTx := newContract(shortHash[:])
+
+	hashlock := func(secret []byte) []byte { return NewScriptBuilder().AddData(secret).AddInt64(1).Script() }
+	timeout := NewScriptBuilder().AddInt64(0).Script()
+	recipientHash, refundHash := HashPubKey(recipient.PublicKey), HashPubKey(refunder.PublicKey)
+
+	tests := []struct {
+		name       string
+		contract   []byte
+		contractTx *Transaction
+		pubKeyHash []byte
+		lockTime   int64
+		branch     []byte
+		wallet     *Wallet
+		valid      bool
+	}{
+		{"hashlock", contract, contractTx, recipientHash, 0, hashlock(secret), recipient, true},
+		{"hashlock with another secret", contract, contractTx, recipientHash, 0, hashlock(bytes.Repeat([]byte{0x01}, htlcSecretLen)), recipient, false},
+		{"hashlock with a secret of another size", shortContract, shortContractTx, recipientHash, 0, hashlock(shortSecret), recipient, false},
+		{"hashlock signed by the refund key", contract, contractTx, recipientHash, 0, hashlock(secret), refunder, false},
+		{"timeout at the lock time", contract, contractTx, refundHash, lockTime, timeout, refunder, true},
+		{"timeout after the lock time", contract, contractTx, refundHash, lockTime + 1, timeout, refunder, true},
+		{"timeout before the lock time", contract, contractTx, refundHash, lockTime - 1, timeout, refunder, false},
+		{"timeout signed by the recipient", contract, contractTx, refundHash, lockTime, timeout, recipient, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, err := newHTLCSpend(tt.contractTx, tt.contract, tt.pubKeyHash, tt.lockTime, tt.branch, tt.wallet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			prevTXs := map[string]Transaction{hex.EncodeToString(tt.contractTx.ID): *tt.contractTx}
+			if valid := tx.Verify(prevTXs); valid != tt.valid {
+				t.Errorf("Verify() = %t, want %t", valid, tt.valid)
+			}
+		})
+	}
+}
+
+func TestHTLCTransactions(t *testing.T) {
+	recipient, refunder := NewWallet(), NewWallet()
+	secret, secretHash := NewSwapSecret()
+	htlc := &HTLC{secretHash, HashPubKey(recipient.PublicKey), HashPubKey(refunder.PublicKey), 500}
+	contract := htlc.Script()
+	contractTx := &Transaction{Vout: []TXOutput{{10, PayToScriptHashScript(HashPubKey(contract))}}}
+	contractTx.ID = contractTx.Hash()
+	prevTXs := map[string]Transaction{hex.EncodeToString(contractTx.ID): *contractTx}
+
+	redeem, err := NewHTLCRedeemTransaction(contractTx, contract, secret, recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !redeem.Verify(prevTXs) {
+		t.Error("redeeming transaction doesn't verify")
+	}
+	revealed, err := ExtractSwapSecret(redeem, secretHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(revealed, secret) {
+		t.Errorf("redeeming transaction reveals %x, want %x", revealed, secret)
+	}
+
+	refund, err := NewHTLCRefundTransaction(contractTx, contract, refunder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refund.LockTime != htlc.LockTime || !refund.Verify(prevTXs) {
+		t.Errorf("refund with lock time %d doesn't verify", refund.LockTime)
+	}
+	if _, err := ExtractSwapSecret(refund, secretHash); err == nil {
+		t.Error("refund reveals the secret")
+	}
+
+	_, err = NewHTLCRedeemTransaction(contractTx, contract, bytes.Repeat([]byte{0x01}, htlcSecretLen), recipient)
+	checkError(t, err, "secret doesn't hash to")
+	_, err = NewHTLCRedeemTransaction(contractTx, contract, secret, refunder)
+	checkError(t, err, "not the recipient")
+	_, err = NewHTLCRefundTransaction(contractTx, contract, recipient)
+	checkError(t, err, "not the refund address")
+	_, err = NewHTLCRefundTransaction(contractTx, PayToPubKeyHashScript(htlc.RefundHash), refunder)
+	checkError(t, err, "is not a swap contract")
+}
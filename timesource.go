@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// The clock is only adjusted once enough peers have reported their
+	// time, and never by more than maxTimeOffset seconds.
+	minTimeSamples = 5
+	maxTimeSamples = 200
+	maxTimeOffset  = 70 * 60
+)
+
+// MedianTimeSource keeps the network-adjusted time: the local clock moved by
+// the median of the offsets between the clocks of peers and ours, as they
+// report them in their version messages.
+type MedianTimeSource struct {
+	mu      sync.Mutex
+	offsets map[string]int64
+	offset  int64
+}
+
+func NewMedianTimeSource() *MedianTimeSource {
+	return &MedianTimeSource{offsets: make(map[string]int64)}
+}
+
+// AddTimeSample records the time the peer at host reported. Each host counts
+// once.
+func (s *MedianTimeSource) AddTimeSample(host string, peerTime int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.offsets[host]; ok || len(s.offsets) >= maxTimeSamples {
+		return
+	}
+	s.offsets[host] = peerTime - time.Now().Unix()
+	if len(s.offsets) < minTimeSamples {
+		return
+	}
+
+	var offsets []int64
+	for _, offset := range s.offsets {
+		offsets = append(offsets, offset)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	median := offsets[len(offsets)/2]
+
+	if median > maxTimeOffset || median < -maxTimeOffset {
+		fmt.Printf("Peers' clocks are %d seconds off ours; check the system clock\n", median)
+		s.offset = 0
+		return
+	}
+	s.offset = median
+}
+
+// Offset returns the seconds added to the local clock.
+func (s *MedianTimeSource) Offset() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.offset
+}
+
+// AdjustedTime returns the network-adjusted Unix time.
+func (s *MedianTimeSource) AdjustedTime() int64 {
+	return time.Now().Unix() + s.Offset()
+}
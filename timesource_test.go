@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMedianTimeSource(t *testing.T) {
+	tests := []struct {
+		name string
+		// offsets are the clock offsets reported, one per host.
+		offsets []int64
+		want    int64
+	}{
+		{"no samples", nil, 0},
+		{"too few samples", []int64{100, 100, 100, 100}, 0},
+		{"enough samples", []int64{100, 100, 100, 100, 100}, 100},
+		{"behind", []int64{-100, -100, -100, -100, -100}, -100},
+		{"median of the samples", []int64{-50, 5000, 10, 200, 100}, 100},
+		{"up to the cap", []int64{maxTimeOffset, maxTimeOffset, maxTimeOffset, maxTimeOffset, maxTimeOffset}, maxTimeOffset},
+		{"beyond the cap", []int64{maxTimeOffset + 100, maxTimeOffset + 100, maxTimeOffset + 100, maxTimeOffset + 100, maxTimeOffset + 100}, 0},
+		{"beyond the cap behind", []int64{-maxTimeOffset - 100, -maxTimeOffset - 100, -maxTimeOffset - 100, -maxTimeOffset - 100, -maxTimeOffset - 100}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewMedianTimeSource()
+			for i, offset := range tt.offsets {
+				s.AddTimeSample(fmt.Sprintf("host%d", i), time.Now().Unix()+offset)
+			}
+			checkOffset(t, s, tt.want)
+		})
+	}
+
+	t.Run("each host counts once", func(t *testing.T) {
+		s := NewMedianTimeSource()
+		for i := 0; i < minTimeSamples; i++ {
+			s.AddTimeSample("host", time.Now().Unix()+100)
+		}
+		checkOffset(t, s, 0)
+
+		// Four more hosts make the five samples needed.
+		for i := 1; i < minTimeSamples; i++ {
+			s.AddTimeSample(fmt.Sprintf("host%d", i), time.Now().Unix()-100)
+		}
+		checkOffset(t, s, -100)
+	})
+
+	t.Run("samples stop at the limit", func(t *testing.T) {
+		s := NewMedianTimeSource()
+		for i := 0; i < maxTimeSamples; i++ {
+			s.AddTimeSample(fmt.Sprintf("host%d", i), time.Now().Unix()+100)
+		}
+		for i := maxTimeSamples; i < 3*maxTimeSamples; i++ {
+			s.AddTimeSample(fmt.Sprintf("host%d", i), time.Now().Unix()-100)
+		}
+		checkOffset(t, s, 100)
+	})
+}
+
+// checkOffset fails t unless s adjusts the clock by want, or a second less
+// if the clock ticked between a peer reporting and the sample being taken.
+func checkOffset(t *testing.T, s *MedianTimeSource, want int64) {
+	t.Helper()
+
+	if offset := s.Offset(); offset != want && offset != want-1 {
+		t.Errorf("offset is %d, want %d", offset, want)
+	}
+}
@@ -6,20 +6,48 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/gob"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"math/big"
 	"strings"
 )
 
-const subsidy = 10
+const (
+	subsidy = 10
+	// maxMoney bounds every amount and every sum of amounts validation
+	// accepts, so that adding them up can't overflow.
+	maxMoney = 21000000 * 100000000
+
+	// Fee rates are in coins per feeRateUnit bytes of serialized transaction.
+	feeRateUnit    = 1000
+	DefaultFeeRate = 1
+
+	// estimatedScriptSigSize is the size added by signing a pay-to-pubkey-hash
+	// input: a signature and a public key with their push opcodes.
+	estimatedScriptSigSize = 2 + 64 + 2 + 64
+
+	// Lock times below lockTimeThreshold are block heights, the others Unix
+	// timestamps.
+	lockTimeThreshold = 500000000
+
+	// The input of a coinbase starts with the height of its block and an
+	// extra nonce, 8 bytes each, followed by free-form data. The height
+	// makes every coinbase ID unique; the miner changes the extra nonce,
+	// and with it the merkle root, when it runs out of nonces.
+	coinbaseHeaderLen = 16
+)
 
 type Transaction struct {
 	ID   []byte
 	Vin  []TXInput
 	Vout []TXOutput
+	// LockTime is the height or time before which the transaction can't be
+	// mined. Zero means no lock.
+	LockTime int64
 }
 
 func (tx *Transaction) IsCoinbase() bool {
@@ -38,13 +66,63 @@ func (tx Transaction) Serialize() []byte {
 	return encoded.Bytes()
 }
 
+// HashData is the encoding of tx that its ID, signature hashes and merkle
+// roots are computed over. Serialize can't be used for them: gob numbers types
+// in the order a process first meets them and writes the numbers into its
+// output. HashData lays out the ID, the inputs, the outputs and the lock time
+// in that order, with integers in big-endian and byte strings and lists
+// preceded by their lengths.
+func (tx Transaction) HashData() []byte {
+	data := appendBytes(nil, tx.ID)
+
+	data = binary.BigEndian.AppendUint32(data, uint32(len(tx.Vin)))
+	for _, vin := range tx.Vin {
+		data = appendBytes(data, vin.Txid)
+		data = binary.BigEndian.AppendUint64(data, uint64(vin.Vout))
+		data = appendBytes(data, vin.ScriptSig)
+		data = binary.BigEndian.AppendUint32(data, vin.Sequence)
+	}
+
+	data = binary.BigEndian.AppendUint32(data, uint32(len(tx.Vout)))
+	for _, out := range tx.Vout {
+		data = binary.BigEndian.AppendUint64(data, uint64(out.Value))
+		data = appendBytes(data, out.ScriptPubKey)
+	}
+
+	return binary.BigEndian.AppendUint64(data, uint64(tx.LockTime))
+}
+
+// appendBytes appends b to data, preceded by its length.
+func appendBytes(data, b []byte) []byte {
+	data = binary.BigEndian.AppendUint32(data, uint32(len(b)))
+	return append(data, b...)
+}
+
+// Hash returns the ID of tx. The signatures in the unlocking scripts sign the
+// ID, so it leaves them out, except in a coinbase, whose unlocking script
+// holds data instead.
 func (tx *Transaction) Hash() []byte {
 	var hash [32]byte
 
 	txCopy := *tx
+	if !tx.IsCoinbase() {
+		txCopy = tx.TrimmedCopy()
+	}
 	txCopy.ID = []byte{}
 
-	hash = sha256.Sum256(txCopy.Serialize())
+	hash = sha256.Sum256(txCopy.HashData())
+
+	return hash[:]
+}
+
+// SignatureHash is the digest signed for input inID: a copy of the
+// transaction with every unlocking script cleared except that of inID, which
+// is replaced by the locking script of the output it spends.
+func (tx *Transaction) SignatureHash(inID int, prevScript []byte) []byte {
+	txCopy := tx.TrimmedCopy()
+	txCopy.Vin[inID].ScriptSig = prevScript
+
+	hash := sha256.Sum256(txCopy.HashData())
 
 	return hash[:]
 }
@@ -61,23 +139,20 @@ func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transac
 		}
 	}
 
-	txCopy := tx.TrimmedCopy()
-
-	for inID, vin := range txCopy.Vin {
-		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
-		txCopy.Vin[inID].Signature = nil
-		txCopy.Vin[inID].PubKey = prevTx.Vout[vin.Vout].PubKeyHash
+	pubKey := PubKeyBytes(&privKey.PublicKey)
 
-		dataToSign := fmt.Sprintf("%x\n", txCopy)
-
-		r, s, err := ecdsa.Sign(rand.Reader, &privKey, []byte(dataToSign))
-		if err != nil {
-			log.Panic(err)
+	for inID, vin := range tx.Vin {
+		prevOut := prevTXs[hex.EncodeToString(vin.Txid)].Vout[vin.Vout]
+		signature := SignHash(privKey, tx.SignatureHash(inID, prevOut.ScriptPubKey))
+
+		switch ClassifyScript(prevOut.ScriptPubKey) {
+		case PubKeyHashTy, TimeLockTy, StakeTy, UnbondingTy:
+			tx.Vin[inID].ScriptSig = NewScriptBuilder().AddData(signature).AddData(pubKey).Script()
+		case PubKeyTy:
+			tx.Vin[inID].ScriptSig = NewScriptBuilder().AddData(signature).Script()
+		default:
+			log.Panicf("ERROR: Can't sign input %d: output %x:%d is not locked to a single key", inID, vin.Txid, vin.Vout)
 		}
-		signature := append(r.Bytes(), s.Bytes()...)
-
-		tx.Vin[inID].Signature = signature
-		txCopy.Vin[inID].PubKey = nil
 	}
 }
 
@@ -85,41 +160,109 @@ func (tx Transaction) String() string {
 	var lines []string
 
 	lines = append(lines, fmt.Sprintf("--- Transaction %x:", tx.ID))
+	if tx.LockTime != 0 {
+		lines = append(lines, fmt.Sprintf("     Lock time: %d", tx.LockTime))
+	}
 
 	for i, input := range tx.Vin {
 
 		lines = append(lines, fmt.Sprintf("     Input %d:", i))
 		lines = append(lines, fmt.Sprintf("       TXID:      %x", input.Txid))
 		lines = append(lines, fmt.Sprintf("       Out:       %d", input.Vout))
-		lines = append(lines, fmt.Sprintf("       Signature: %x", input.Signature))
-		lines = append(lines, fmt.Sprintf("       PubKey:    %x", input.PubKey))
+		if tx.IsCoinbase() {
+			lines = append(lines, fmt.Sprintf("       Coinbase:  %x", input.ScriptSig))
+		} else {
+			lines = append(lines, fmt.Sprintf("       ScriptSig: %s", DisasmScript(input.ScriptSig)))
+		}
+		if input.Sequence != 0 {
+			lines = append(lines, fmt.Sprintf("       Sequence:  %#x", input.Sequence))
+		}
 	}
 
 	for i, output := range tx.Vout {
 		lines = append(lines, fmt.Sprintf("     Output %d:", i))
 		lines = append(lines, fmt.Sprintf("       Value:  %d", output.Value))
-		lines = append(lines, fmt.Sprintf("       Script: %x", output.PubKeyHash))
+		lines = append(lines, fmt.Sprintf("       Script: %s", DisasmScript(output.ScriptPubKey)))
 	}
 
 	return strings.Join(lines, "\n")
 }
 
+// SetLocks sets the lock time of tx and the relative lock of every input.
+// Locks are covered by the signatures, so they must be set before signing.
+func (tx *Transaction) SetLocks(lockTime int64, sequence uint32) {
+	tx.LockTime = lockTime
+	for i := range tx.Vin {
+		tx.Vin[i].Sequence = sequence
+	}
+	tx.ID = tx.Hash()
+}
+
+// SigOps returns the signature checks tx counts towards the limit of its
+// block: those of its unlocking scripts and new outputs, and those of the
+// redeem scripts of the script hash outputs it spends. prevTXs must hold the
+// transactions it spends.
+func (tx *Transaction) SigOps(prevTXs map[string]Transaction) int {
+	count := 0
+	for _, out := range tx.Vout {
+		count += CountSigOps(out.ScriptPubKey, false)
+	}
+	if tx.IsCoinbase() {
+		return count
+	}
+
+	for _, vin := range tx.Vin {
+		count += CountSigOps(vin.ScriptSig, false)
+
+		prevOut := prevTXs[hex.EncodeToString(vin.Txid)].Vout[vin.Vout]
+		if ClassifyScript(prevOut.ScriptPubKey) != ScriptHashTy {
+			continue
+		}
+		pushes := pushedData(vin.ScriptSig)
+		if len(pushes) > 0 {
+			count += CountSigOps(pushes[len(pushes)-1], true)
+		}
+	}
+
+	return count
+}
+
+// SignalsReplacement reports whether tx may be replaced in the mempool.
+func (tx *Transaction) SignalsReplacement() bool {
+	for _, vin := range tx.Vin {
+		if vin.Sequence < SequenceMaxNonReplaceable {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AddData attaches data to tx in a null data output. Like SetLocks, it must
+// be called before signing.
+func (tx *Transaction) AddData(data []byte) {
+	tx.Vout = append(tx.Vout, TXOutput{0, NullDataScript(data)})
+	tx.ID = tx.Hash()
+}
+
 func (tx *Transaction) TrimmedCopy() Transaction {
 	var inputs []TXInput
 	var outputs []TXOutput
 
 	for _, vin := range tx.Vin {
-		inputs = append(inputs, TXInput{vin.Txid, vin.Vout, nil, nil})
+		inputs = append(inputs, TXInput{vin.Txid, vin.Vout, nil, vin.Sequence})
 	}
 
 	for _, vout := range tx.Vout {
-		outputs = append(outputs, TXOutput{vout.Value, vout.PubKeyHash})
+		outputs = append(outputs, TXOutput{vout.Value, vout.ScriptPubKey})
 	}
 
-	txCopy := Transaction{tx.ID, inputs, outputs}
+	txCopy := Transaction{tx.ID, inputs, outputs, tx.LockTime}
 	return txCopy
 }
 
+// Verify runs the unlocking script of every input against the locking
+// script of the output it spends.
 func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
 	if tx.IsCoinbase() {
 		return true
@@ -131,87 +274,285 @@ func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
 		}
 	}
 
-	txCopy := tx.TrimmedCopy()
-	curve := elliptic.P256()
-
 	for inID, vin := range tx.Vin {
 		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
-		txCopy.Vin[inID].Signature = nil
-		txCopy.Vin[inID].PubKey = prevTx.Vout[vin.Vout].PubKeyHash
-		// txCopy.ID = txCopy.Hash()
-		// txCopy.Vin[inID].PubKey = nil
-
-		r := big.Int{}
-		s := big.Int{}
-		sigLen := len(vin.Signature)
-		r.SetBytes(vin.Signature[:(sigLen / 2)])
-		s.SetBytes(vin.Signature[(sigLen / 2):])
-
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(vin.PubKey)
-		x.SetBytes(vin.PubKey[:(keyLen / 2)])
-		y.SetBytes(vin.PubKey[(keyLen / 2):])
-
-		dataToVerify := fmt.Sprintf("%x\n", txCopy)
-
-		rawPubKey := ecdsa.PublicKey{curve, &x, &y}
-		if !ecdsa.Verify(&rawPubKey, []byte(dataToVerify), &r, &s) {
+		if vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+			return false
+		}
+		prevOut := prevTx.Vout[vin.Vout]
+
+		// Slashed stake is spent with the evidence against its owner instead
+		// of a signature. checkStakeSpends limits what that can pay for.
+		if slashesOutput(vin.ScriptSig, prevOut.ScriptPubKey) {
+			continue
+		}
+
+		checker := &txSignatureChecker{tx, inID, prevOut.ScriptPubKey}
+		err := VerifyScript(vin.ScriptSig, prevOut.ScriptPubKey, checker)
+		if err != nil {
+			fmt.Printf("Input %d of transaction %x is invalid: %s\n", inID, tx.ID, err)
 			return false
 		}
-		txCopy.Vin[inID].PubKey = nil
 	}
 
 	return true
 }
 
-func NewCoinbaseTX(to, data string) *Transaction {
+type txSignatureChecker struct {
+	tx         *Transaction
+	inID       int
+	prevScript []byte
+}
+
+func (c *txSignatureChecker) CheckSignature(sig, pubKey []byte) bool {
+	return VerifySignature(pubKey, c.tx.SignatureHash(c.inID, c.prevScript), sig)
+}
+
+// CheckLockTime accepts lockTime if the transaction's own lock time is of the
+// same kind and at least as late, so that the transaction can't be mined
+// before lockTime.
+func (c *txSignatureChecker) CheckLockTime(lockTime int64) bool {
+	if (lockTime < lockTimeThreshold) != (c.tx.LockTime < lockTimeThreshold) {
+		return false
+	}
+
+	return lockTime <= c.tx.LockTime
+}
+
+// CheckSequence accepts sequence if the input's relative lock is of the same
+// kind and at least as long.
+func (c *txSignatureChecker) CheckSequence(sequence int64) bool {
+	txSequence := int64(c.tx.Vin[c.inID].Sequence)
+	if txSequence&SequenceLockTimeDisabled != 0 {
+		return false
+	}
+	if sequence&SequenceLockTimeIsSeconds != txSequence&SequenceLockTimeIsSeconds {
+		return false
+	}
+
+	return sequence&SequenceLockTimeMask <= txSequence&SequenceLockTimeMask
+}
+
+// SignHash signs hash and encodes the signature as r || s, each padded to 32
+// bytes.
+func SignHash(privKey ecdsa.PrivateKey, hash []byte) []byte {
+	r, s, err := ecdsa.Sign(rand.Reader, &privKey, hash)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	return signature
+}
+
+func VerifySignature(pubKey, hash, signature []byte) bool {
+	if len(signature) == 0 || len(pubKey) == 0 {
+		return false
+	}
+
+	r := big.Int{}
+	s := big.Int{}
+	sigLen := len(signature)
+	r.SetBytes(signature[:(sigLen / 2)])
+	s.SetBytes(signature[(sigLen / 2):])
+
+	x := big.Int{}
+	y := big.Int{}
+	keyLen := len(pubKey)
+	x.SetBytes(pubKey[:(keyLen / 2)])
+	y.SetBytes(pubKey[(keyLen / 2):])
+
+	curve := elliptic.P256()
+	if !curve.IsOnCurve(&x, &y) {
+		return false
+	}
+	rawPubKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}
+
+	return ecdsa.Verify(&rawPubKey, hash, &r, &s)
+}
+
+// NewCoinbaseTX pays the block subsidy and the fees of the other
+// transactions in the block at height to the miner.
+func NewCoinbaseTX(to, data string, height, fees int) *Transaction {
 	if data == "" {
 		data = fmt.Sprintf("Reward to '%s'", to)
 	}
 
-	txin := TXInput{[]byte{}, -1, nil, []byte(data)}
-	txout := NewTXOutput(subsidy, to)
-	tx := Transaction{nil, []TXInput{txin}, []TXOutput{*txout}}
+	return NewCoinbaseTXPayments([]Payment{{to, subsidy + fees}}, data, height)
+}
+
+// NewCoinbaseTXPayments is a coinbase splitting the reward of the block at
+// height between payments.
+func NewCoinbaseTXPayments(payments []Payment, data string, height int) *Transaction {
+	scriptSig := make([]byte, coinbaseHeaderLen, coinbaseHeaderLen+len(data))
+	binary.BigEndian.PutUint64(scriptSig, uint64(height))
+	scriptSig = append(scriptSig, data...)
+
+	txin := TXInput{[]byte{}, -1, scriptSig, 0}
+	var txouts []TXOutput
+	for _, payment := range payments {
+		txouts = append(txouts, *NewTXOutput(payment.Amount, payment.Address))
+	}
+	tx := Transaction{nil, []TXInput{txin}, txouts, 0}
 	tx.ID = tx.Hash()
 
 	return &tx
 }
 
+// CoinbaseHeight returns the block height a coinbase commits to.
+func (tx *Transaction) CoinbaseHeight() int {
+	scriptSig := tx.Vin[0].ScriptSig
+	if len(scriptSig) < coinbaseHeaderLen {
+		return -1
+	}
+
+	return int(binary.BigEndian.Uint64(scriptSig))
+}
+
+// WithExtraNonce returns a copy of a coinbase with extraNonce in its input.
+func (tx *Transaction) WithExtraNonce(extraNonce uint64) *Transaction {
+	scriptSig := make([]byte, len(tx.Vin[0].ScriptSig))
+	copy(scriptSig, tx.Vin[0].ScriptSig)
+	binary.BigEndian.PutUint64(scriptSig[8:], extraNonce)
+
+	coinbase := Transaction{nil, []TXInput{{tx.Vin[0].Txid, tx.Vin[0].Vout, scriptSig, tx.Vin[0].Sequence}}, tx.Vout, tx.LockTime}
+	coinbase.ID = coinbase.Hash()
+
+	return &coinbase
+}
+
+// Payment is an amount paid to an address.
+type Payment struct {
+	Address string
+	Amount  int
+}
+
+// TransactionRequest describes a transaction for a node to build from the
+// outputs of a single address. The outputs spent are Inputs when given, and
+// otherwise picked by the CoinSelection strategy. Data, if any, is carried in
+// a null data output, which the fee covers.
+type TransactionRequest struct {
+	From          string
+	Payments      []Payment
+	FeeRate       int
+	CoinSelection string
+	Inputs        []OutPoint
+	Data          []byte
+}
+
 func NewUTXOTransaction(wallet *Wallet, to string, amount int, UTXOSet *UTXOSet) *Transaction {
-	var inputs []TXInput
-	var outputs []TXOutput
+	req := TransactionRequest{From: string(wallet.GetAddress()), Payments: []Payment{{to, amount}}, FeeRate: DefaultFeeRate}
+	tx, err := NewUnsignedUTXOTransaction(req, UTXOSet.FindUnspentOutputs(req.From))
+	if err != nil {
+		log.Panic(err)
+	}
+	UTXOSet.Blockchain.SignTransaction(tx, wallet.PrivateKey)
 
-	pubKeyHash := HashPubKey(wallet.PublicKey)
-	acc, validOutputs := UTXOSet.FindSpendableOutputs(pubKeyHash, amount)
+	return tx
+}
 
-	if acc < amount {
-		log.Panic("ERROR: Not enough funds")
+// NewUnsignedUTXOTransaction makes the payments of req from UTXOs, the
+// outputs locked to its from address, as chosen by the coin selector of req.
+// It pays the fee for req.FeeRate and returns the change to the from address
+// in a single output. The inputs are left for the owners of the from address
+// to sign.
+func NewUnsignedUTXOTransaction(req TransactionRequest, UTXOs []UTXO) (*Transaction, error) {
+	amount := 0
+	for _, payment := range req.Payments {
+		if payment.Amount <= 0 {
+			return nil, fmt.Errorf("ERROR: Invalid amount %d for %s", payment.Amount, payment.Address)
+		}
+		amount += payment.Amount
+	}
+	if amount == 0 {
+		return nil, errors.New("ERROR: No payments")
+	}
+
+	selector, err := NewCoinSelector(req)
+	if err != nil {
+		return nil, err
 	}
 
-	for txid, outs := range validOutputs {
-		txID, err := hex.DecodeString(txid)
+	// The fee depends on the size of the transaction, which depends on the
+	// inputs needed to pay the fee, so repeat until the fee is covered.
+	fee := 0
+	for {
+		selected, err := selector.Select(UTXOs, amount+fee)
 		if err != nil {
-			log.Panic(err)
+			return nil, err
 		}
 
-		for _, out := range outs {
-			input := TXInput{txID, out, nil, wallet.PublicKey}
-			inputs = append(inputs, input)
+		var inputs []TXInput
+		var outputs []TXOutput
+		acc := 0
+
+		for _, utxo := range selected {
+			inputs = append(inputs, TXInput{utxo.TxID, utxo.Index, nil, SequenceFinal})
+			acc += utxo.Output.Value
 		}
-	}
 
-	from := string(wallet.GetAddress())
-	outputs = append(outputs, *NewTXOutput(amount, to))
-	if acc > amount {
-		outputs = append(outputs, *NewTXOutput(acc-amount, from))
+		for _, payment := range req.Payments {
+			outputs = append(outputs, *NewTXOutput(payment.Amount, payment.Address))
+		}
+		if acc > amount+fee {
+			outputs = append(outputs, *NewTXOutput(acc-amount-fee, req.From))
+		}
+		if len(req.Data) > 0 {
+			outputs = append(outputs, TXOutput{0, NullDataScript(req.Data)})
+		}
+
+		tx := Transaction{nil, inputs, outputs, 0}
+		tx.ID = tx.Hash()
+
+		requiredFee := FeeForSize(tx.EstimatedSignedSize(), req.FeeRate)
+		if requiredFee <= fee {
+			return &tx, nil
+		}
+		fee = requiredFee
 	}
+}
 
-	tx := Transaction{nil, inputs, outputs}
-	tx.ID = tx.Hash()
-	UTXOSet.Blockchain.SignTransaction(&tx, wallet.PrivateKey)
+// Size returns the size of the transaction, on which fees are charged and
+// block limits counted. Like hashes, it is taken from HashData, which every
+// node encodes the same.
+func (tx *Transaction) Size() int {
+	return len(tx.HashData())
+}
 
-	return &tx
+// EstimatedSignedSize guesses the size of tx once its inputs are signed,
+// assuming they spend pay-to-pubkey-hash outputs.
+func (tx *Transaction) EstimatedSignedSize() int {
+	return tx.Size() + len(tx.Vin)*estimatedScriptSigSize
+}
+
+// FeeForSize returns the fee for size bytes at feeRate, rounded up.
+func FeeForSize(size, feeRate int) int {
+	return (size*feeRate + feeRateUnit - 1) / feeRateUnit
+}
+
+// Fee returns what the inputs of tx spend beyond its outputs. An output
+// listed by several inputs is only spent once, so it counts once; validation
+// rejects such transactions anyway, as it does amounts beyond maxMoney, which
+// could overflow the sums. prevTXs must hold the transactions tx spends.
+func (tx *Transaction) Fee(prevTXs map[string]Transaction) int {
+	fee := 0
+	counted := make(map[string]bool)
+	for _, vin := range tx.Vin {
+		outPoint := OutPoint{vin.Txid, vin.Vout}.String()
+		if counted[outPoint] {
+			continue
+		}
+		counted[outPoint] = true
+		fee += prevTXs[hex.EncodeToString(vin.Txid)].Vout[vin.Vout].Value
+	}
+	for _, out := range tx.Vout {
+		fee -= out.Value
+	}
+
+	return fee
 }
 
 func DeserializeTransaction(data []byte) Transaction {
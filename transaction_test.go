@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestTransactionHashData(t *testing.T) {
+	tx := Transaction{
+		ID:       []byte{0xaa},
+		Vin:      []TXInput{{[]byte{0x01, 0x02}, 1, []byte{0x03}, SequenceFinal}},
+		Vout:     []TXOutput{{10, []byte{OP_RETURN}}},
+		LockTime: 5,
+	}
+	want := "00000001aa" +
+		"00000001" + "0000000201020000000000000001" + "0000000103" + "ffffffff" +
+		"00000001" + "000000000000000a" + "000000016a" +
+		"0000000000000005"
+
+	if got := hex.EncodeToString(tx.HashData()); got != want {
+		t.Errorf("HashData() = %s, want %s", got, want)
+	}
+}
+
+func TestFeeCountsOutpointsOnce(t *testing.T) {
+	prev := Transaction{[]byte{0x01}, nil, []TXOutput{{10, nil}, {5, nil}}, 0}
+	prevTXs := map[string]Transaction{hex.EncodeToString(prev.ID): prev}
+
+	tests := []struct {
+		name  string
+		vouts []int
+		pays  int
+		fee   int
+	}{
+		{"distinct", []int{0, 1}, 12, 3},
+		{"duplicate", []int{0, 0}, 20, -10},
+		{"duplicate with another", []int{0, 1, 0}, 12, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := Transaction{Vout: []TXOutput{{tt.pays, nil}}}
+			for _, vout := range tt.vouts {
+				tx.Vin = append(tx.Vin, TXInput{prev.ID, vout, nil, SequenceFinal})
+			}
+
+			if fee := tx.Fee(prevTXs); fee != tt.fee {
+				t.Errorf("Fee() = %d, want %d", fee, tt.fee)
+			}
+		})
+	}
+}
+
+// TestNewUnsignedUTXOTransactionFee pays the fee on the size of the whole
+// transaction, data output included.
+func TestNewUnsignedUTXOTransactionFee(t *testing.T) {
+	from, to := NewWallet(), NewWallet()
+	utxo := UTXO{[]byte{0x01}, 0, *NewTXOutput(100000, string(from.GetAddress()))}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"no data", nil},
+		{"data", make([]byte, 80)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := TransactionRequest{
+				From:     string(from.GetAddress()),
+				Payments: []Payment{{string(to.GetAddress()), 1000}},
+				FeeRate:  feeRateUnit,
+				Data:     tt.data,
+			}
+			tx, err := NewUnsignedUTXOTransaction(req, []UTXO{utxo})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			paid := utxo.Output.Value
+			for _, out := range tx.Vout {
+				paid -= out.Value
+			}
+			if want := FeeForSize(tx.EstimatedSignedSize(), req.FeeRate); paid < want {
+				t.Errorf("fee is %d, want at least %d", paid, want)
+			}
+			last := tx.Vout[len(tx.Vout)-1].ScriptPubKey
+			if hasData := bytes.Equal(last, NullDataScript(tt.data)); hasData != (tt.data != nil) {
+				t.Errorf("last output is %x, want data %x", last, tt.data)
+			}
+		})
+	}
+}
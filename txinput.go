@@ -1,16 +1,48 @@
 package main
 
-import "bytes"
+// Relative lock times are encoded in TXInput.Sequence the way BIP 68 does:
+// the low 16 bits hold a number of blocks, or of 512 second units when
+// SequenceLockTimeIsSeconds is set, counted from the block that confirmed the
+// spent output. Setting SequenceLockTimeDisabled turns the lock off.
+const (
+	SequenceLockTimeDisabled    = 1 << 31
+	SequenceLockTimeIsSeconds   = 1 << 22
+	SequenceLockTimeMask        = 0x0000ffff
+	SequenceLockTimeGranularity = 9
+)
+
+// Inputs built by the wallet are final. A transaction with an input whose
+// sequence is below SequenceMaxNonReplaceable, as any with a relative lock,
+// opts in to being replaced in the mempool by one paying a higher fee.
+const (
+	SequenceFinal             = 0xffffffff
+	SequenceMaxNonReplaceable = 0xfffffffe
+	SequenceReplaceable       = 0xfffffffd
+)
 
 type TXInput struct {
 	Txid      []byte
 	Vout      int
-	Signature []byte
-	PubKey    []byte
+	ScriptSig []byte
+	Sequence  uint32
 }
 
 func (in *TXInput) UsesKey(pubKeyHash []byte) bool {
-	lockingHash := HashPubKey(in.PubKey)
+	return scriptUsesKey(in.ScriptSig, pubKeyHash)
+}
+
+// SequenceLockSatisfied reports whether the input's relative lock has expired
+// for a block at height with timestamp blockTime, given the block that
+// confirmed the output it spends.
+func (in *TXInput) SequenceLockSatisfied(prevBlock *Block, height int, blockTime int64) bool {
+	if in.Sequence&SequenceLockTimeDisabled != 0 {
+		return true
+	}
+
+	lock := int64(in.Sequence & SequenceLockTimeMask)
+	if in.Sequence&SequenceLockTimeIsSeconds != 0 {
+		return blockTime >= prevBlock.Timestamp+lock<<SequenceLockTimeGranularity
+	}
 
-	return bytes.Equal(lockingHash, pubKeyHash)
+	return int64(height) >= int64(prevBlock.Height)+lock
 }
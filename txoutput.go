@@ -7,18 +7,48 @@ import (
 )
 
 type TXOutput struct {
-	Value      int
-	PubKeyHash []byte
+	Value        int
+	ScriptPubKey []byte
 }
 
 func (out *TXOutput) Lock(address []byte) {
-	pubKeyHash := Base58Decode(address)
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	out.PubKeyHash = pubKeyHash
+	script, err := PayToAddrScript(string(address))
+	if err != nil {
+		log.Panic(err)
+	}
+	out.ScriptPubKey = script
 }
 
+// IsLockedWithKey reports whether the key hashing to pubKeyHash can spend the
+// output on its own.
 func (out *TXOutput) IsLockedWithKey(pubKeyHash []byte) bool {
-	return bytes.Equal(out.PubKeyHash, pubKeyHash)
+	return bytes.Equal(ExtractPubKeyHash(out.ScriptPubKey), pubKeyHash)
+}
+
+// Address returns the address the output pays to, or an empty string for
+// scripts that have no address form.
+func (out *TXOutput) Address() string {
+	switch ClassifyScript(out.ScriptPubKey) {
+	case MultiSigTy:
+		return string(EncodeAddress(multiSigVersion, out.ScriptPubKey))
+	case ScriptHashTy:
+		return string(EncodeAddress(scriptHashVersion, ExtractScriptHash(out.ScriptPubKey)))
+	}
+
+	pubKeyHash := ExtractPubKeyHash(out.ScriptPubKey)
+	if pubKeyHash == nil {
+		return ""
+	}
+
+	return string(AddressFromPubKeyHash(pubKeyHash))
+}
+
+func (out *TXOutput) IsUnspendable() bool {
+	return IsUnspendableScript(out.ScriptPubKey)
+}
+
+func (out *TXOutput) IsLockedToAddress(address string) bool {
+	return out.Address() == address
 }
 
 func NewTXOutput(value int, address string) *TXOutput {
@@ -28,8 +58,10 @@ func NewTXOutput(value int, address string) *TXOutput {
 	return txo
 }
 
+// TXOutputs holds the unspent outputs of a transaction keyed by their index
+// in Vout, so that spending one output doesn't shift the others.
 type TXOutputs struct {
-	Outputs []TXOutput
+	Outputs map[int]TXOutput
 }
 
 func (outs TXOutputs) Serialize() []byte {
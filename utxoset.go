@@ -1,47 +1,68 @@
 package main
 
 import (
-	"encoding/hex"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
 	"log"
+	"sort"
 
 	"github.com/boltdb/bolt"
 )
 
-const utxoBucket = "chainstate"
+const (
+	utxoBucket = "chainstate"
+	// undoBucket holds the undo data of the blocks connected to the UTXO
+	// set, and under utxoTipKey the hash of the block the set is at.
+	undoBucket = "undo"
+	// txIndexBucket maps the IDs of the transactions connected to the UTXO
+	// set to the hashes of their blocks.
+	txIndexBucket = "txindex"
+	// stakeBucket indexes the stake and unbonding outputs in the UTXO set
+	// by outpoint.
+	stakeBucket = "stakes"
+	// nullDataBucket maps the data carried in null data outputs of the
+	// transactions connected to the UTXO set to the ID of the oldest carrier.
+	nullDataBucket = "nulldata"
+)
+
+var utxoTipKey = []byte("l")
 
 type UTXOSet struct {
 	Blockchain *BlockChain
 }
 
-func (u UTXOSet) Reindex() {
-	db := u.Blockchain.db
-	bucketName := []byte(utxoBucket)
-
-	err := db.Update(func(tx *bolt.Tx) error {
-		err := tx.DeleteBucket(bucketName)
-		if err != nil && err != bolt.ErrBucketNotFound {
-			return err
-		}
+type UTXO struct {
+	TxID   []byte
+	Index  int
+	Output TXOutput
+}
 
-		_, err = tx.CreateBucket(bucketName)
-		return err
-	})
-	if err != nil {
-		log.Panic(err)
+// Reindex rebuilds the UTXO set, the undo data and the transaction, stake and
+// null data indexes of the main chain by connecting its blocks from the genesis block on. A
+// pruned chain no longer has the blocks to do so.
+func (u UTXOSet) Reindex() {
+	if height := u.Blockchain.PrunedHeight(); height > 0 {
+		log.Panicf("The chain is pruned up to height %d, so the UTXO set can't be rebuilt. Resync it from scratch.", height)
 	}
+	hashes := u.Blockchain.GetBlockHashes()
 
-	UTXO := u.Blockchain.FindUTXO()
-
-	err = db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(bucketName)
-
-		for txID, outs := range UTXO {
-			key, err := hex.DecodeString(txID)
+	err := u.Blockchain.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{utxoBucket, undoBucket, txIndexBucket, stakeBucket, nullDataBucket} {
+			err := tx.DeleteBucket([]byte(name))
+			if err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+			_, err = tx.CreateBucket([]byte(name))
 			if err != nil {
 				return err
 			}
+		}
 
-			err = b.Put(key, outs.Serialize())
+		b := tx.Bucket([]byte(blocksBucket))
+		for i := len(hashes) - 1; i >= 0; i-- {
+			err := connectBlock(tx, DeserializeBlock(b.Get(hashes[i])))
 			if err != nil {
 				return err
 			}
@@ -53,6 +74,36 @@ func (u UTXOSet) Reindex() {
 	}
 }
 
+// chainStateCurrent reports whether the UTXO set in db is stored in the
+// current format. It used to hold the unspent outputs of a transaction in a
+// slice rather than keyed by index, which doesn't decode into TXOutputs, and
+// had no stake or null data index.
+func chainStateCurrent(db *bolt.DB) bool {
+	current := true
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(utxoBucket))
+		if b == nil {
+			return nil
+		}
+		if tx.Bucket([]byte(stakeBucket)) == nil || tx.Bucket([]byte(nullDataBucket)) == nil {
+			current = false
+			return nil
+		}
+		_, v := b.Cursor().First()
+		if v != nil {
+			var outs TXOutputs
+			current = gob.NewDecoder(bytes.NewReader(v)).Decode(&outs) == nil
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return current
+}
+
 func (u UTXOSet) CountTransactions() int {
 	db := u.Blockchain.db
 	count := 0
@@ -74,9 +125,8 @@ func (u UTXOSet) CountTransactions() int {
 	return count
 }
 
-func (u UTXOSet) FindSpendableOutputs(pubkeyHash []byte, amount int) (int, map[string][]int) {
-	unspentOutputs := make(map[string][]int)
-	accumulated := 0
+func (u UTXOSet) FindUTXO(address string) []TXOutput {
+	var UTXOs []TXOutput
 	db := u.Blockchain.db
 
 	err := db.View(func(tx *bolt.Tx) error {
@@ -84,13 +134,10 @@ func (u UTXOSet) FindSpendableOutputs(pubkeyHash []byte, amount int) (int, map[s
 		c := b.Cursor()
 
 		for k, v := c.First(); k != nil; k, v = c.Next() {
-			txID := hex.EncodeToString(k)
 			outs := DeserializeOutputs(v)
-
-			for outIdx, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubkeyHash) && accumulated < amount {
-					accumulated += out.Value
-					unspentOutputs[txID] = append(unspentOutputs[txID], outIdx)
+			for _, out := range outs.Outputs {
+				if out.IsLockedToAddress(address) {
+					UTXOs = append(UTXOs, out)
 				}
 			}
 		}
@@ -100,11 +147,11 @@ func (u UTXOSet) FindSpendableOutputs(pubkeyHash []byte, amount int) (int, map[s
 		log.Panic(err)
 	}
 
-	return accumulated, unspentOutputs
+	return UTXOs
 }
 
-func (u UTXOSet) FindUTXO(pubkeyHash []byte) []TXOutput {
-	var UTXOs []TXOutput
+func (u UTXOSet) FindUnspentOutputs(address string) []UTXO {
+	var UTXOs []UTXO
 	db := u.Blockchain.db
 
 	err := db.View(func(tx *bolt.Tx) error {
@@ -113,9 +160,11 @@ func (u UTXOSet) FindUTXO(pubkeyHash []byte) []TXOutput {
 
 		for k, v := c.First(); k != nil; k, v = c.Next() {
 			outs := DeserializeOutputs(v)
-			for _, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubkeyHash) {
-					UTXOs = append(UTXOs, out)
+			for outIdx, out := range outs.Outputs {
+				if out.IsLockedToAddress(address) {
+					txID := make([]byte, len(k))
+					copy(txID, k)
+					UTXOs = append(UTXOs, UTXO{txID, outIdx, out})
 				}
 			}
 		}
@@ -125,53 +174,338 @@ func (u UTXOSet) FindUTXO(pubkeyHash []byte) []TXOutput {
 		log.Panic(err)
 	}
 
+	sort.Slice(UTXOs, func(i, j int) bool {
+		if c := bytes.Compare(UTXOs[i].TxID, UTXOs[j].TxID); c != 0 {
+			return c < 0
+		}
+		return UTXOs[i].Index < UTXOs[j].Index
+	})
+
 	return UTXOs
 }
 
-func (u UTXOSet) Update(block *Block) {
+// FindOutputs returns the unspent outputs of the transaction txID.
+func (u UTXOSet) FindOutputs(txID []byte) map[int]TXOutput {
+	var outs TXOutputs
 	db := u.Blockchain.db
 
-	err := db.Update(func(tx *bolt.Tx) error {
+	err := db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(utxoBucket))
+		outsBytes := b.Get(txID)
+		if outsBytes != nil {
+			outs = DeserializeOutputs(outsBytes)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
 
-		for _, tx := range block.Transactions {
-			if !tx.IsCoinbase() {
-				for _, vin := range tx.Vin {
-					updateOuts := TXOutputs{}
-					outsBytes := b.Get(vin.Txid)
-					outs := DeserializeOutputs(outsBytes)
-
-					for outIdx, out := range outs.Outputs {
-						if outIdx != vin.Vout {
-							updateOuts.Outputs = append(updateOuts.Outputs, out)
-						}
-					}
+	return outs.Outputs
+}
 
-					if len(updateOuts.Outputs) == 0 {
-						err := b.Delete(vin.Txid)
-						if err != nil {
-							return err
-						}
-					} else {
-						err := b.Put(vin.Txid, updateOuts.Serialize())
-						if err != nil {
-							return err
-						}
-					}
-				}
-			}
+// unspentTransaction stands in for the transaction txID with outputs, those
+// of its outputs still unspent, which are all a transaction spending it is
+// validated against. The others are made unspendable.
+func unspentTransaction(txID []byte, outputs map[int]TXOutput) *Transaction {
+	n := 0
+	for index := range outputs {
+		if index >= n {
+			n = index + 1
+		}
+	}
+
+	vout := make([]TXOutput, n)
+	for i := range vout {
+		out, ok := outputs[i]
+		if !ok {
+			out = TXOutput{0, []byte{OP_RETURN}}
+		}
+		vout[i] = out
+	}
+
+	return &Transaction{txID, nil, vout, 0}
+}
+
+// SyncTip moves the UTXO set to the tip of the chain, disconnecting the
+// blocks that left the main chain with their undo data and connecting the
+// ones that joined it. The set is rebuilt if it isn't known which block it is
+// at, or the undo data it needs is missing. The chain must be locked, or not
+// yet shared.
+func (u UTXOSet) SyncTip() {
+	bc := u.Blockchain
+	tip := bc.Tip()
+
+	err := bc.db.Update(func(tx *bolt.Tx) error {
+		undo := tx.Bucket([]byte(undoBucket))
+		if undo == nil {
+			return errNoUndoData
+		}
+		at := undo.Get(utxoTipKey)
+		if bytes.Equal(at, tip) {
+			return nil
+		}
 
-			newOutputs := TXOutputs{}
-			newOutputs.Outputs = append(newOutputs.Outputs, tx.Vout...)
+		if at == nil {
+			return errNoUndoData
+		}
+		b := tx.Bucket([]byte(blocksBucket))
+		atData := b.Get(at)
+		if atData == nil {
+			return errNoUndoData
+		}
+		connected, disconnected := findReorg(b, DeserializeBlock(atData), DeserializeBlock(b.Get(tip)))
 
-			err := b.Put(tx.ID, newOutputs.Serialize())
+		for _, block := range disconnected {
+			err := disconnectBlock(tx, block)
 			if err != nil {
 				return err
 			}
 		}
+		for _, block := range connected {
+			err := connectBlock(tx, block)
+			if err != nil {
+				return err
+			}
+		}
+
+		if !bytes.Equal(undo.Get(utxoTipKey), tip) {
+			return errNoUndoData
+		}
 		return nil
 	})
+	if err == errNoUndoData {
+		u.Reindex()
+		return
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// blockUndo holds the outputs each transaction of a block spent, so that
+// they can be put back when the block is disconnected, and the stake index
+// entries of the stakes among them.
+type blockUndo struct {
+	Spent  [][]UTXO
+	Stakes []Stake
+}
+
+func (undo blockUndo) Serialize() []byte {
+	var buf bytes.Buffer
+
+	enc := gob.NewEncoder(&buf)
+	err := enc.Encode(undo)
 	if err != nil {
 		log.Panic(err)
 	}
+
+	return buf.Bytes()
+}
+
+func deserializeBlockUndo(data []byte) blockUndo {
+	var undo blockUndo
+
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	err := dec.Decode(&undo)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return undo
+}
+
+var errNoUndoData = errors.New("undo data is missing")
+
+// connectBlock spends the outputs block spends and adds the ones it creates,
+// indexing its transactions, stakes and null data and storing its undo data.
+// The UTXO set must be at the parent of block, and block may only spend
+// outputs in it.
+func connectBlock(tx *bolt.Tx, block *Block) error {
+	b := tx.Bucket([]byte(utxoBucket))
+	undos, err := tx.CreateBucketIfNotExists([]byte(undoBucket))
+	if err != nil {
+		return err
+	}
+	err = moveUTXOTip(undos, block.PrevBlockHash, block.Hash)
+	if err != nil {
+		return err
+	}
+	index, err := tx.CreateBucketIfNotExists([]byte(txIndexBucket))
+	if err != nil {
+		return err
+	}
+	stakes, err := tx.CreateBucketIfNotExists([]byte(stakeBucket))
+	if err != nil {
+		return err
+	}
+	carriers, err := tx.CreateBucketIfNotExists([]byte(nullDataBucket))
+	if err != nil {
+		return err
+	}
+	undo := blockUndo{Spent: make([][]UTXO, len(block.Transactions))}
+
+	for i, tx := range block.Transactions {
+		err := index.Put(tx.ID, block.Hash)
+		if err != nil {
+			return err
+		}
+
+		if !tx.IsCoinbase() {
+			for _, vin := range tx.Vin {
+				outsBytes := b.Get(vin.Txid)
+				if outsBytes == nil {
+					return fmt.Errorf("block %x spends missing output %s", block.Hash, OutPoint{vin.Txid, vin.Vout})
+				}
+				outs := DeserializeOutputs(outsBytes)
+				out, ok := outs.Outputs[vin.Vout]
+				if !ok {
+					return fmt.Errorf("block %x spends missing output %s", block.Hash, OutPoint{vin.Txid, vin.Vout})
+				}
+				undo.Spent[i] = append(undo.Spent[i], UTXO{vin.Txid, vin.Vout, out})
+				delete(outs.Outputs, vin.Vout)
+
+				key := []byte(OutPoint{vin.Txid, vin.Vout}.String())
+				if stake := stakes.Get(key); stake != nil {
+					undo.Stakes = append(undo.Stakes, deserializeStake(stake))
+					err := stakes.Delete(key)
+					if err != nil {
+						return err
+					}
+				}
+
+				if len(outs.Outputs) == 0 {
+					err := b.Delete(vin.Txid)
+					if err != nil {
+						return err
+					}
+				} else {
+					err := b.Put(vin.Txid, outs.Serialize())
+					if err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		newOutputs := TXOutputs{make(map[int]TXOutput)}
+		for outIdx, out := range tx.Vout {
+			if !out.IsUnspendable() {
+				newOutputs.Outputs[outIdx] = out
+			}
+			if stake, ok := outputStake(tx, outIdx, block.Height); ok {
+				err := stakes.Put([]byte(OutPoint{tx.ID, outIdx}.String()), stake.Serialize())
+				if err != nil {
+					return err
+				}
+			}
+			if data, ok := ExtractNullData(out.ScriptPubKey); ok && len(data) > 0 && carriers.Get(data) == nil {
+				err := carriers.Put(data, tx.ID)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if len(newOutputs.Outputs) == 0 {
+			continue
+		}
+		if b.Get(tx.ID) != nil {
+			return fmt.Errorf("block %x overwrites unspent transaction %x", block.Hash, tx.ID)
+		}
+
+		err = b.Put(tx.ID, newOutputs.Serialize())
+		if err != nil {
+			return err
+		}
+	}
+
+	return undos.Put(block.Hash, undo.Serialize())
+}
+
+// disconnectBlock removes the outputs block created and puts back the ones
+// it spent, dropping its transactions and null data from the indexes and
+// restoring the stake index. The UTXO set must be at block.
+func disconnectBlock(tx *bolt.Tx, block *Block) error {
+	b := tx.Bucket([]byte(utxoBucket))
+	index := tx.Bucket([]byte(txIndexBucket))
+	stakes := tx.Bucket([]byte(stakeBucket))
+	carriers := tx.Bucket([]byte(nullDataBucket))
+	undos := tx.Bucket([]byte(undoBucket))
+	undoData := undos.Get(block.Hash)
+	if undoData == nil {
+		return errNoUndoData
+	}
+	undo := deserializeBlockUndo(undoData)
+	err := moveUTXOTip(undos, block.Hash, block.PrevBlockHash)
+	if err != nil {
+		return err
+	}
+
+	// Restore the stakes first, so that those created and spent within the
+	// block are then removed with the others it created.
+	for _, stake := range undo.Stakes {
+		err := stakes.Put([]byte(OutPoint{stake.TxID, stake.Index}.String()), stake.Serialize())
+		if err != nil {
+			return err
+		}
+	}
+	for _, tx := range block.Transactions {
+		for outIdx, out := range tx.Vout {
+			err := stakes.Delete([]byte(OutPoint{tx.ID, outIdx}.String()))
+			if err != nil {
+				return err
+			}
+			// Later carriers of the data were disconnected before, so the
+			// index points at this one unless an earlier one exists.
+			data, ok := ExtractNullData(out.ScriptPubKey)
+			if ok && len(data) > 0 && bytes.Equal(carriers.Get(data), tx.ID) {
+				err := carriers.Delete(data)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// Go through the block backwards so that outputs created and spent
+	// within it are put back before their transaction is removed.
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		tx := block.Transactions[i]
+		err := b.Delete(tx.ID)
+		if err != nil {
+			return err
+		}
+		if index != nil {
+			err = index.Delete(tx.ID)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, utxo := range undo.Spent[i] {
+			outs := TXOutputs{make(map[int]TXOutput)}
+			if outsBytes := b.Get(utxo.TxID); outsBytes != nil {
+				outs = DeserializeOutputs(outsBytes)
+			}
+			outs.Outputs[utxo.Index] = utxo.Output
+
+			err := b.Put(utxo.TxID, outs.Serialize())
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// moveUTXOTip records that the UTXO set moved from the block with hash from
+// to the one with hash to. It fails if the set isn't at from, so that no block
+// is connected or disconnected twice.
+func moveUTXOTip(undo *bolt.Bucket, from, to []byte) error {
+	if at := undo.Get(utxoTipKey); !bytes.Equal(at, from) {
+		return fmt.Errorf("UTXO set is at block %x, not %x", at, from)
+	}
+
+	return undo.Put(utxoTipKey, to)
 }
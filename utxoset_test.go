@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestChainStateCurrent(t *testing.T) {
+	// legacyTXOutputs is how TXOutputs used to be stored.
+	type legacyTXOutputs struct {
+		Outputs []TXOutput
+	}
+	var legacy bytes.Buffer
+	err := gob.NewEncoder(&legacy).Encode(legacyTXOutputs{[]TXOutput{{10, []byte{OP_RETURN}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := TXOutputs{map[int]TXOutput{0: {10, []byte{OP_RETURN}}}}.Serialize()
+
+	tests := []struct {
+		name    string
+		entry   []byte
+		missing string
+		current bool
+	}{
+		{"empty", nil, "", true},
+		{"current", current, "", true},
+		{"legacy", legacy.Bytes(), "", false},
+		{"no stake index", current, stakeBucket, false},
+		{"no null data index", current, nullDataBucket, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := bolt.Open(filepath.Join(t.TempDir(), "chainstate.db"), 0600, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			err = db.Update(func(tx *bolt.Tx) error {
+				for _, name := range []string{stakeBucket, nullDataBucket} {
+					if name == tt.missing {
+						continue
+					}
+					_, err := tx.CreateBucket([]byte(name))
+					if err != nil {
+						return err
+					}
+				}
+				b, err := tx.CreateBucket([]byte(utxoBucket))
+				if err != nil || tt.entry == nil {
+					return err
+				}
+				return b.Put([]byte("tx"), tt.entry)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := chainStateCurrent(db); got != tt.current {
+				t.Errorf("chainStateCurrent() = %v, want %v", got, tt.current)
+			}
+		})
+	}
+}
+
+func TestConnectBlockErrors(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	genesis := genesisCoinbase(t, bc)
+
+	block := newTestBlock(tipBlock(t, bc), wallet, newTestTx(wallet, genesis, []int{0}, subsidy))
+	addTestBlock(t, bc, block)
+
+	// connectBlock doesn't check signatures.
+	nonexistent := &Transaction{nil, []TXInput{{block.Transactions[1].ID, 1, nil, SequenceFinal}}, []TXOutput{{1, nil}}, 0}
+	nonexistent.ID = nonexistent.Hash()
+
+	tests := []struct {
+		name  string
+		block *Block
+		err   string
+	}{
+		{"connected twice", block, "UTXO set is at block"},
+		{"spends a spent output", newTestBlock(block, wallet, newTestTx(wallet, genesis, []int{0}, subsidy-1)), "spends missing output"},
+		{"spends a nonexistent output", newTestBlock(block, wallet, nonexistent), "spends missing output"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := bc.db.Update(func(tx *bolt.Tx) error {
+				return connectBlock(tx, tt.block)
+			})
+			checkError(t, err, tt.err)
+
+			if balance := utxoBalance(bc, wallet); balance != 2*subsidy {
+				t.Errorf("balance is %d after the failed connect, want %d", balance, 2*subsidy)
+			}
+		})
+	}
+}
+
+// utxoBalance sums the outputs of wallet in the UTXO set of bc.
+func utxoBalance(bc *BlockChain, wallet *Wallet) int {
+	UTXOSet := UTXOSet{bc}
+	balance := 0
+	for _, out := range UTXOSet.FindUTXO(string(wallet.GetAddress())) {
+		balance += out.Value
+	}
+
+	return balance
+}
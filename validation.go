@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// IsFinal reports whether tx may be included in a block at height with
+// timestamp blockTime. As in Bitcoin, the lock time must lie strictly before
+// the block.
+func (tx *Transaction) IsFinal(height int, blockTime int64) bool {
+	if tx.LockTime == 0 {
+		return true
+	}
+
+	if tx.LockTime < lockTimeThreshold {
+		return tx.LockTime < int64(height)
+	}
+
+	return tx.LockTime < blockTime
+}
+
+// ValidateTransaction checks that tx could be included in a block at height
+// with timestamp blockTime on top of the current chain: its ID is its hash,
+// its lock time and the relative locks of its inputs have expired, the
+// outputs it spends are unspent, each spent once, and cover its outputs, any
+// stake it spends goes where it may, and its unlocking scripts verify. It
+// returns the fee tx pays.
+//
+// pending holds the unconfirmed transactions, keyed by hex ID, whose outputs
+// tx may spend: the mempool, or the transactions before it in a block. They
+// are taken to be confirmed in the same block as tx.
+func (bc *BlockChain) ValidateTransaction(tx *Transaction, height int, blockTime int64, pending map[string]Transaction) (int, error) {
+	fee, _, err := bc.validateTransaction(tx, height, blockTime, pending, true)
+	return fee, err
+}
+
+// validateTransaction is ValidateTransaction, also returning the signature
+// checks tx counts towards the limit of its block. The unlocking scripts are
+// only run if verifyScripts is set.
+func (bc *BlockChain) validateTransaction(tx *Transaction, height int, blockTime int64, pending map[string]Transaction, verifyScripts bool) (int, int, error) {
+	if size := tx.Size(); size > netParams.MaxTxSize {
+		return 0, 0, fmt.Errorf("size %d exceeds %d bytes", size, netParams.MaxTxSize)
+	}
+	if !bytes.Equal(tx.ID, tx.Hash()) {
+		return 0, 0, errors.New("ID doesn't match the transaction's hash")
+	}
+
+	outputs := 0
+	for i, out := range tx.Vout {
+		if out.Value < 0 {
+			return 0, 0, fmt.Errorf("output %d has negative value %d", i, out.Value)
+		}
+		if out.Value > maxMoney {
+			return 0, 0, fmt.Errorf("output %d value %d exceeds %d", i, out.Value, maxMoney)
+		}
+		outputs += out.Value
+		if outputs > maxMoney {
+			return 0, 0, fmt.Errorf("outputs total more than %d", maxMoney)
+		}
+	}
+
+	err := checkDataCarriers(tx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if tx.IsCoinbase() {
+		return 0, tx.SigOps(nil), nil
+	}
+
+	if !tx.IsFinal(height, blockTime) {
+		return 0, 0, fmt.Errorf("transaction is locked until %d", tx.LockTime)
+	}
+
+	prevTXs := make(map[string]Transaction)
+	prevHeights := make(map[string]int)
+	spent := make(map[string]bool)
+	inputs := 0
+	for inID, vin := range tx.Vin {
+		outPoint := OutPoint{vin.Txid, vin.Vout}.String()
+		if spent[outPoint] {
+			return 0, 0, fmt.Errorf("input %d spends output %s again", inID, outPoint)
+		}
+		spent[outPoint] = true
+
+		prevTx, prevBlock, err := bc.findInputTransaction(vin.Txid, height, blockTime, pending)
+		if err != nil {
+			return 0, 0, fmt.Errorf("input %d spends unknown or spent transaction %x", inID, vin.Txid)
+		}
+		if vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) || prevTx.Vout[vin.Vout].IsUnspendable() {
+			return 0, 0, fmt.Errorf("input %d spends nonexistent or spent output %x:%d", inID, vin.Txid, vin.Vout)
+		}
+
+		if !vin.SequenceLockSatisfied(prevBlock, height, blockTime) {
+			return 0, 0, fmt.Errorf("input %d is locked by sequence %#x", inID, vin.Sequence)
+		}
+		value := prevTx.Vout[vin.Vout].Value
+		inputs += value
+		if value < 0 || value > maxMoney || inputs > maxMoney {
+			return 0, 0, fmt.Errorf("inputs total more than %d", maxMoney)
+		}
+
+		prevTXs[hex.EncodeToString(prevTx.ID)] = *prevTx
+		prevHeights[hex.EncodeToString(prevTx.ID)] = prevBlock.Height
+	}
+
+	fee := tx.Fee(prevTXs)
+	if fee < 0 {
+		return 0, 0, fmt.Errorf("outputs exceed inputs by %d", -fee)
+	}
+
+	err = checkStakeSpends(tx, prevTXs, prevHeights, bc.blockHeight)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if verifyScripts && !tx.Verify(prevTXs) {
+		return 0, 0, errors.New("script verification failed")
+	}
+
+	sigOps := tx.SigOps(prevTXs)
+	if sigOps > netParams.MaxBlockSigOps {
+		return 0, 0, fmt.Errorf("%d signature checks exceed the block limit of %d", sigOps, netParams.MaxBlockSigOps)
+	}
+
+	return fee, sigOps, nil
+}
+
+// findInputTransaction returns what an input spending the transaction with ID
+// txID is validated against, and the block confirming the transaction. A
+// pending transaction is returned whole, with a stand-in for the block at
+// height being validated. Any other is looked up in the UTXO set, whether its
+// block is pruned or not, and stands in with only its unspent outputs.
+func (bc *BlockChain) findInputTransaction(txID []byte, height int, blockTime int64, pending map[string]Transaction) (*Transaction, *Block, error) {
+	if tx, ok := pending[hex.EncodeToString(txID)]; ok {
+		return &tx, &Block{Timestamp: blockTime, Height: height}, nil
+	}
+
+	UTXOSet := UTXOSet{bc}
+	outputs := UTXOSet.FindOutputs(txID)
+	if len(outputs) == 0 {
+		return nil, nil, errors.New("Transaction has no unspent outputs")
+	}
+	block, err := bc.FindTransactionBlock(txID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return unspentTransaction(txID, outputs), block, nil
+}
+
+// checkDataCarriers allows a transaction a single null data output carrying
+// at most maxDataCarrierSize bytes.
+func checkDataCarriers(tx *Transaction) error {
+	carriers := 0
+	for i, out := range tx.Vout {
+		if len(out.ScriptPubKey) == 0 || out.ScriptPubKey[0] != OP_RETURN {
+			continue
+		}
+		if ClassifyScript(out.ScriptPubKey) != NullDataTy {
+			return fmt.Errorf("output %d carries more than %d bytes of data", i, maxDataCarrierSize)
+		}
+		carriers++
+	}
+	if carriers > 1 {
+		return fmt.Errorf("transaction has %d data carrier outputs", carriers)
+	}
+
+	return nil
+}
+
+// ValidateBlock checks a block received from a peer before it is stored. The
+// block has to extend a block we already have, so blocks must arrive oldest
+// first, agree with the checkpoints and not fork off the main chain where it
+// is pruned. Its transactions are validated when
+// it joins the main chain, against the UTXO set of its parent, since a block
+// on a side chain may spend outputs the main chain has spent.
+func (bc *BlockChain) ValidateBlock(block *Block) error {
+	parent, err := bc.GetBlock(block.PrevBlockHash)
+	if err != nil {
+		return fmt.Errorf("parent block %x is unknown", block.PrevBlockHash)
+	}
+	if status := bc.GetBlockStatus(parent.Hash); status != 0 {
+		return fmt.Errorf("parent block %x is %s", parent.Hash, status)
+	}
+	if parent.Pruned() {
+		return fmt.Errorf("parent block %x is pruned", parent.Hash)
+	}
+	if pruned := bc.PrunedHeight(); pruned > 0 && !bytes.Equal(parent.Hash, bc.Tip()) {
+		if fork := bc.forkHeight(&parent); fork <= pruned {
+			return fmt.Errorf("forks off the main chain at height %d, which is pruned up to %d", fork, pruned)
+		}
+	}
+
+	if block.Height != parent.Height+1 {
+		return fmt.Errorf("height %d doesn't follow parent height %d", block.Height, parent.Height)
+	}
+
+	if medianTime := bc.MedianTimePast(&parent); block.Timestamp <= medianTime {
+		return fmt.Errorf("timestamp %d isn't later than the median time past %d", block.Timestamp, medianTime)
+	}
+	if maxTime := timeSource.AdjustedTime() + netParams.MaxFutureBlockTime; block.Timestamp > maxTime {
+		return fmt.Errorf("timestamp %d is more than %d seconds in the future", block.Timestamp, netParams.MaxFutureBlockTime)
+	}
+
+	if size := block.Size(); size > netParams.MaxBlockSize {
+		return fmt.Errorf("size %d exceeds %d bytes", size, netParams.MaxBlockSize)
+	}
+	// The merkle root the seal commits to needs a transaction.
+	if len(block.Transactions) == 0 {
+		return errors.New("block has no transactions")
+	}
+
+	err = bc.checkCheckpoints(block)
+	if err != nil {
+		return err
+	}
+
+	return bc.engine.VerifySeal(&parent, block)
+}
+
+// ValidateBlockTransactions checks the transactions of a block at height with
+// timestamp blockTime. Each may spend the outputs of those before it, but no
+// output may be spent twice, and the coinbase must be the only one. Together
+// they may not make more signature checks than the network allows.
+func (bc *BlockChain) ValidateBlockTransactions(txs []*Transaction, height int, blockTime int64) error {
+	return bc.validateBlockTransactions(txs, height, blockTime, true)
+}
+
+// validateBlockTransactions is ValidateBlockTransactions, running the
+// unlocking scripts only if verifyScripts is set.
+func (bc *BlockChain) validateBlockTransactions(txs []*Transaction, height int, blockTime int64, verifyScripts bool) error {
+	pending := make(map[string]Transaction)
+	spent := make(map[string]bool)
+	fees, sigOps := 0, 0
+	for _, tx := range txs {
+		fee, txSigOps, err := bc.validateTransaction(tx, height, blockTime, pending, verifyScripts)
+		if err != nil {
+			return fmt.Errorf("transaction %x: %w", tx.ID, err)
+		}
+		fees += fee
+		if fees > maxMoney {
+			return fmt.Errorf("fees total more than %d", maxMoney)
+		}
+		sigOps += txSigOps
+		if sigOps > netParams.MaxBlockSigOps {
+			return fmt.Errorf("transactions make more than %d signature checks", netParams.MaxBlockSigOps)
+		}
+
+		if !tx.IsCoinbase() {
+			for _, vin := range tx.Vin {
+				outPoint := OutPoint{vin.Txid, vin.Vout}.String()
+				if spent[outPoint] {
+					return fmt.Errorf("transaction %x: output %s is spent twice", tx.ID, outPoint)
+				}
+				spent[outPoint] = true
+			}
+		}
+		pending[hex.EncodeToString(tx.ID)] = *tx
+	}
+
+	return CheckCoinbase(txs, height, fees)
+}
+
+// CheckCoinbase checks that the transactions of a block at height hold
+// exactly one coinbase, that it commits to the height, and that it claims no
+// more than the subsidy and the fees of the others.
+func CheckCoinbase(txs []*Transaction, height, fees int) error {
+	var coinbase *Transaction
+	for _, tx := range txs {
+		if !tx.IsCoinbase() {
+			continue
+		}
+		if coinbase != nil {
+			return errors.New("block has more than one coinbase")
+		}
+		coinbase = tx
+	}
+	if coinbase == nil {
+		return errors.New("block has no coinbase")
+	}
+	if coinbase.CoinbaseHeight() != height {
+		return fmt.Errorf("coinbase commits to height %d", coinbase.CoinbaseHeight())
+	}
+
+	reward := 0
+	for i, out := range coinbase.Vout {
+		if out.Value < 0 || out.Value > maxMoney {
+			return fmt.Errorf("coinbase output %d has value %d out of range", i, out.Value)
+		}
+		reward += out.Value
+		if reward > maxMoney {
+			return fmt.Errorf("coinbase claims more than %d", maxMoney)
+		}
+	}
+	if reward > subsidy+fees {
+		return fmt.Errorf("coinbase claims %d, more than subsidy %d and fees %d", reward, subsidy, fees)
+	}
+
+	return nil
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestValidateTransactionInputs(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	genesis := genesisCoinbase(t, bc)
+
+	// The genesis reward is spent in block 1.
+	spend := newTestTx(wallet, genesis, []int{0}, subsidy)
+	addTestBlock(t, bc, newTestBlock(tipBlock(t, bc), wallet, spend))
+	height := bc.GetBestHeight() + 1
+
+	tests := []struct {
+		name string
+		tx   *Transaction
+		err  string
+	}{
+		{"unspent output", newTestTx(wallet, spend, []int{0}, subsidy), ""},
+		{"spent output", newTestTx(wallet, genesis, []int{0}, subsidy-1), "spent"},
+		{"duplicate outpoint", newTestTx(wallet, spend, []int{0, 0}, 2*subsidy), "again"},
+		{"duplicate outpoint paying once", newTestTx(wallet, spend, []int{0, 0}, subsidy), "again"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := bc.ValidateTransaction(tt.tx, height, tipBlock(t, bc).Timestamp+1, nil)
+			checkError(t, err, tt.err)
+		})
+	}
+}
+
+func TestProcessBlockRespend(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	genesis := genesisCoinbase(t, bc)
+
+	addTestBlock(t, bc, newTestBlock(tipBlock(t, bc), wallet, newTestTx(wallet, genesis, []int{0}, subsidy)))
+	tip := tipBlock(t, bc)
+
+	block := newTestBlock(tip, wallet, newTestTx(wallet, genesis, []int{0}, subsidy-1))
+	err := bc.ProcessBlock(block)
+	checkError(t, err, "spent")
+	if !bytes.Equal(bc.Tip(), tip.Hash) {
+		t.Errorf("tip moved to %x", bc.Tip())
+	}
+	if status := bc.GetBlockStatus(block.Hash); status != BlockInvalid {
+		t.Errorf("block is %s, want invalid", status)
+	}
+}
+
+// TestProcessBlockSideChain switches to a side chain that spends the genesis
+// reward the main chain spent too. Its blocks are validated against their own
+// branch.
+func TestProcessBlockSideChain(t *testing.T) {
+	tests := []struct {
+		name string
+		// spend returns the transaction of the second side chain block,
+		// given that of the first.
+		spend  func(wallet *Wallet, genesis, first *Transaction) *Transaction
+		err    string
+		height int
+	}{
+		{"spends its branch", func(wallet *Wallet, genesis, first *Transaction) *Transaction {
+			return newTestTx(wallet, first, []int{0}, subsidy-2)
+		}, "", 2},
+		{"respends on its branch", func(wallet *Wallet, genesis, first *Transaction) *Transaction {
+			return newTestTx(wallet, genesis, []int{0}, subsidy-2)
+		}, "spent", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc, wallet := newTestChain(t)
+			genesis := genesisCoinbase(t, bc)
+			genesisBlock := tipBlock(t, bc)
+			addTestBlock(t, bc, newTestBlock(genesisBlock, wallet, newTestTx(wallet, genesis, []int{0}, subsidy)))
+
+			first := newTestTx(wallet, genesis, []int{0}, subsidy-1)
+			side := newTestBlock(genesisBlock, wallet, first)
+			addTestBlock(t, bc, side)
+			side = newTestBlock(side, wallet, tt.spend(wallet, genesis, first))
+			err := bc.ProcessBlock(side)
+			checkError(t, err, tt.err)
+
+			if height := bc.GetBestHeight(); height != tt.height {
+				t.Errorf("tip is at height %d, want %d", height, tt.height)
+			}
+			if tt.err != "" && bc.GetBlockStatus(side.Hash) != BlockInvalid {
+				t.Errorf("block is %s, want invalid", bc.GetBlockStatus(side.Hash))
+			}
+			want := utxoBalance(bc, wallet)
+			UTXOSet := UTXOSet{bc}
+			UTXOSet.Reindex()
+			if balance := utxoBalance(bc, wallet); balance != want {
+				t.Errorf("balance is %d after reindexing, want %d", balance, want)
+			}
+		})
+	}
+}
+
+func TestProcessTxRejectsDuplicateOutpoints(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	genesis := genesisCoinbase(t, bc)
+
+	err := processTx(bc, *newTestTx(wallet, genesis, []int{0, 0}, 2*subsidy), "")
+	checkError(t, err, "again")
+	if n := mempool.Len(); n != 0 {
+		t.Errorf("mempool holds %d transactions, want 0", n)
+	}
+}
+
+// checkError fails t unless err contains want, or is nil when want is empty.
+func checkError(t *testing.T, err error, want string) {
+	t.Helper()
+
+	switch {
+	case want == "" && err != nil:
+		t.Errorf("unexpected error: %s", err)
+	case want != "" && err == nil:
+		t.Errorf("got no error, want one containing %q", want)
+	case want != "" && !strings.Contains(err.Error(), want):
+		t.Errorf("error %q doesn't contain %q", err, want)
+	}
+}
+
+func TestValidateTransactionID(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	genesis := genesisCoinbase(t, bc)
+	height := bc.GetBestHeight() + 1
+
+	withID := func(tx *Transaction, id []byte) *Transaction {
+		tx.ID = id
+		return tx
+	}
+	coinbase := NewCoinbaseTX(string(wallet.GetAddress()), "", height, 0)
+	other := newTestTx(wallet, genesis, []int{0}, subsidy-1)
+
+	tests := []struct {
+		name string
+		tx   *Transaction
+		err  string
+	}{
+		{"signed", newTestTx(wallet, genesis, []int{0}, subsidy), ""},
+		{"ID of another transaction", withID(newTestTx(wallet, genesis, []int{0}, subsidy), other.ID), "doesn't match"},
+		{"no ID", withID(newTestTx(wallet, genesis, []int{0}, subsidy), nil), "doesn't match"},
+		{"coinbase", coinbase, ""},
+		{"coinbase with another ID", withID(NewCoinbaseTX(string(wallet.GetAddress()), "", height, 0), genesis.ID), "doesn't match"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := bc.ValidateTransaction(tt.tx, height, tipBlock(t, bc).Timestamp+1, nil)
+			checkError(t, err, tt.err)
+		})
+	}
+}
+
+func TestValidateTransactionAmounts(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	genesis := genesisCoinbase(t, bc)
+	height := bc.GetBestHeight() + 1
+	script := genesis.Vout[0].ScriptPubKey
+
+	spend := func(values ...int) *Transaction {
+		tx := &Transaction{nil, []TXInput{{genesis.ID, 0, nil, SequenceFinal}}, nil, 0}
+		for _, value := range values {
+			tx.Vout = append(tx.Vout, TXOutput{value, script})
+		}
+		tx.ID = tx.Hash()
+		tx.Sign(wallet.PrivateKey, map[string]Transaction{hex.EncodeToString(genesis.ID): *genesis})
+		return tx
+	}
+
+	tests := []struct {
+		name string
+		tx   *Transaction
+		err  string
+	}{
+		{"within the inputs", spend(subsidy/2, subsidy/2), ""},
+		{"beyond the inputs", spend(subsidy, 1), "outputs exceed inputs"},
+		{"negative", spend(subsidy+1, -1), "negative value"},
+		{"beyond max money", spend(maxMoney + 1), "exceeds"},
+		{"summing beyond max money", spend(maxMoney, 1), "total more than"},
+		{"wrapping around", spend(math.MaxInt, math.MaxInt, 2), "exceeds"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := bc.ValidateTransaction(tt.tx, height, tipBlock(t, bc).Timestamp+1, nil)
+			checkError(t, err, tt.err)
+		})
+	}
+}
+
+func TestCheckCoinbaseAmounts(t *testing.T) {
+	coinbase := func(values ...int) []*Transaction {
+		tx := NewCoinbaseTX(string(NewWallet().GetAddress()), "", 1, 0)
+		tx.Vout = nil
+		for _, value := range values {
+			tx.Vout = append(tx.Vout, TXOutput{value, nil})
+		}
+		tx.ID = tx.Hash()
+		return []*Transaction{tx}
+	}
+
+	tests := []struct {
+		name string
+		txs  []*Transaction
+		fees int
+		err  string
+	}{
+		{"subsidy and fees", coinbase(subsidy, 5), 5, ""},
+		{"beyond subsidy and fees", coinbase(subsidy, 6), 5, "more than subsidy"},
+		{"negative output", coinbase(subsidy+1, -1), 0, "out of range"},
+		{"summing beyond max money", coinbase(maxMoney, maxMoney), 0, "more than"},
+		{"wrapping around", coinbase(math.MaxInt, math.MaxInt, 2), 0, "out of range"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkError(t, CheckCoinbase(tt.txs, 1, tt.fees), tt.err)
+		})
+	}
+}
+
+// newTimedBlock seals a block on parent with timestamp.
+func newTimedBlock(parent *Block, signer *Wallet, timestamp int64) *Block {
+	block := newTestBlock(parent, signer)
+	block.Timestamp = timestamp
+	block.Hash = headerHash(block)
+	block.Signature = SignHash(signer.PrivateKey, block.Hash)
+
+	return block
+}
+
+func TestMedianTimePast(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	genesis := tipBlock(t, bc)
+	if mtp := bc.MedianTimePast(genesis); mtp != genesis.Timestamp {
+		t.Errorf("median time past of the genesis block is %d, want its timestamp %d", mtp, genesis.Timestamp)
+	}
+
+	// Block i is 10*i seconds after the genesis block.
+	block := genesis
+	for i := 1; i <= netParams.MedianTimeBlocks+1; i++ {
+		block = newTimedBlock(block, wallet, genesis.Timestamp+int64(10*i))
+		addTestBlock(t, bc, block)
+	}
+
+	// The genesis block and the first block fall out of the window of the
+	// last MedianTimeBlocks, blocks 2 to 12, whose median is block 7.
+	if mtp, want := bc.MedianTimePast(block), genesis.Timestamp+70; mtp != want {
+		t.Errorf("median time past is %d, want %d", mtp, want)
+	}
+}
+
+func TestValidateBlockTimestamp(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	genesis := tipBlock(t, bc)
+
+	// The last two blocks share a timestamp, which becomes the median.
+	parent := genesis
+	for _, offset := range []int64{10, 11, 11} {
+		parent = newTimedBlock(parent, wallet, genesis.Timestamp+offset)
+		addTestBlock(t, bc, parent)
+	}
+	now := timeSource.AdjustedTime()
+
+	tests := []struct {
+		name      string
+		timestamp int64
+		errMsg    string
+	}{
+		{"at the median time past", parent.Timestamp, "isn't later than the median time past"},
+		{"after the median time past", parent.Timestamp + 1, ""},
+		{"within the future limit", now + netParams.MaxFutureBlockTime - 60, ""},
+		{"beyond the future limit", now + netParams.MaxFutureBlockTime + 60, "seconds in the future"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkError(t, bc.ValidateBlock(newTimedBlock(parent, wallet, tt.timestamp)), tt.errMsg)
+		})
+	}
+
+	t.Run("next block time", func(t *testing.T) {
+		future := parent
+		for i := 0; i < netParams.MedianTimeBlocks; i++ {
+			future = newTimedBlock(future, wallet, now+1000+int64(i))
+			addTestBlock(t, bc, future)
+		}
+		mtp := bc.MedianTimePast(future)
+		if next := bc.NextBlockTime(future); next != mtp+1 {
+			t.Errorf("next block time is %d, want %d after a median time past ahead of the clock", next, mtp+1)
+		}
+	})
+}
@@ -8,6 +8,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"log"
 	"math/big"
 
@@ -16,6 +17,8 @@ import (
 
 const (
 	version            = byte(0x00)
+	multiSigVersion    = byte(0x32)
+	scriptHashVersion  = byte(0x05)
 	addressChecksumLen = 4
 	walletFile         = "wallet_%s.dat"
 )
@@ -26,9 +29,18 @@ type Wallet struct {
 }
 
 func (w Wallet) GetAddress() []byte {
-	pubKeyHash := HashPubKey(w.PublicKey)
+	return AddressFromPubKeyHash(HashPubKey(w.PublicKey))
+}
+
+func AddressFromPubKeyHash(pubKeyHash []byte) []byte {
+	return EncodeAddress(version, pubKeyHash)
+}
 
-	versionPayload := append([]byte{version}, pubKeyHash...)
+// EncodeAddress builds a Base58Check address. Pay-to-pubkey-hash addresses
+// carry a key hash, pay-to-script-hash addresses a redeem script hash and
+// multisig addresses the whole locking script.
+func EncodeAddress(addrVersion byte, payload []byte) []byte {
+	versionPayload := append([]byte{addrVersion}, payload...)
 	checksum := checksum(versionPayload)
 
 	fullPayload := append(versionPayload, checksum...)
@@ -37,6 +49,71 @@ func (w Wallet) GetAddress() []byte {
 	return address
 }
 
+func DecodeAddress(address string) (byte, []byte, error) {
+	if !ValidateAddress(address) {
+		return 0, nil, fmt.Errorf("invalid address %s", address)
+	}
+
+	decoded := Base58Decode([]byte(address))
+
+	addrVersion, payload := decoded[0], decoded[1:len(decoded)-addressChecksumLen]
+	if addrVersion != multiSigVersion && len(payload) != pubKeyHashLen {
+		return 0, nil, fmt.Errorf("address %s has a %d byte hash", address, len(payload))
+	}
+
+	return addrVersion, payload, nil
+}
+
+// DecodePubKeyHashAddress returns the key hash of a pay-to-pubkey-hash
+// address.
+func DecodePubKeyHashAddress(address string) ([]byte, error) {
+	addrVersion, pubKeyHash, err := DecodeAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	if addrVersion != version {
+		return nil, fmt.Errorf("address %s doesn't belong to a single key", address)
+	}
+
+	return pubKeyHash, nil
+}
+
+// PayToAddrScript returns the locking script paying to address.
+func PayToAddrScript(address string) ([]byte, error) {
+	addrVersion, payload, err := DecodeAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	switch addrVersion {
+	case version:
+		return PayToPubKeyHashScript(payload), nil
+	case multiSigVersion:
+		if ClassifyScript(payload) != MultiSigTy {
+			return nil, fmt.Errorf("address %s doesn't hold a multisig script", address)
+		}
+		return payload, nil
+	case scriptHashVersion:
+		return PayToScriptHashScript(payload), nil
+	}
+
+	return nil, fmt.Errorf("unknown address version %#x", addrVersion)
+}
+
+// NewScriptHashAddress returns the pay-to-script-hash address committing to
+// redeemScript. The script itself is only revealed when the funds are spent.
+func NewScriptHashAddress(redeemScript []byte) (string, error) {
+	if len(redeemScript) > maxScriptElementSize {
+		return "", fmt.Errorf("redeem script of %d bytes exceeds %d", len(redeemScript), maxScriptElementSize)
+	}
+	_, err := ParseScript(redeemScript)
+	if err != nil {
+		return "", err
+	}
+
+	return string(EncodeAddress(scriptHashVersion, HashPubKey(redeemScript))), nil
+}
+
 func (w Wallet) MarshalJSON() ([]byte, error) {
 	mapStringAny := map[string]any{
 		"PrivateKey": map[string]any{
@@ -108,11 +185,20 @@ func newKeyPair() (ecdsa.PrivateKey, []byte) {
 	if err != nil {
 		log.Panic(err)
 	}
-	pubKey := append(private.X.Bytes(), private.Y.Bytes()...)
+	pubKey := PubKeyBytes(&private.PublicKey)
 
 	return *private, pubKey
 }
 
+// PubKeyBytes encodes a public key as X || Y, each padded to 32 bytes.
+func PubKeyBytes(pubKey *ecdsa.PublicKey) []byte {
+	encoded := make([]byte, 64)
+	pubKey.X.FillBytes(encoded[:32])
+	pubKey.Y.FillBytes(encoded[32:])
+
+	return encoded
+}
+
 func HashPubKey(pubKey []byte) []byte {
 	publicSHA256 := sha256.Sum256(pubKey)
 
@@ -134,11 +220,27 @@ func checksum(payload []byte) []byte {
 }
 
 func ValidateAddress(address string) bool {
+	if len(address) == 0 {
+		return false
+	}
+
 	pubKeyHash := Base58Decode([]byte(address))
+	if len(pubKeyHash) <= addressChecksumLen {
+		return false
+	}
 	actualChecksum := pubKeyHash[len(pubKeyHash)-addressChecksumLen:]
 	version := pubKeyHash[0]
 	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-addressChecksumLen]
 	targetChecksum := checksum(append([]byte{version}, pubKeyHash...))
 
-	return bytes.Equal(actualChecksum, targetChecksum)
+	return bytes.Equal(actualChecksum, targetChecksum) && isKnownAddressVersion(version)
+}
+
+func isKnownAddressVersion(addrVersion byte) bool {
+	switch addrVersion {
+	case version, multiSigVersion, scriptHashVersion:
+		return true
+	}
+
+	return false
 }